@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/rpc"
+	"strings"
+
+	"github.com/lodthe/external-merge-sort/pkg/cluster"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+)
+
+func main() {
+	var mode = flag.String("mode", "coordinator", "Process role. Supported values: worker, coordinator.")
+	var listen = flag.String("listen", "127.0.0.1:7070", "Worker: TCP address to accept coordinator requests on.")
+	var dir = flag.String("dir", ".", "Worker: where sorted ranges and temporary files are stored.")
+	var workers = flag.String("workers", "127.0.0.1:7070", "Coordinator: comma-separated addresses of workers.")
+	var blockSize = flag.Int("blocksize", 1024*1024, "Coordinator: size of one block (in bytes).")
+	var memoryLimit = flag.Int("memory", 512*1024*1024, "Coordinator: each worker will use at most O(memory) main memory.")
+	var delimiter = flag.String("delimiter", "\n", "Coordinator: bytes used to separate tokens. Escape sequences are supported, e.g. \\r\\n.")
+	var order = flag.String("order", "ASC", "Coordinator: sort order. Supported values: ASC, DESC.")
+	var inputFilepath = flag.String("input", "input.txt", "Coordinator: input file path. Workers must be able to open it.")
+	var outputFilepath = flag.String("output", "output.txt", "Coordinator: output file path.")
+	var frontCoding = flag.Bool("front-coding", false, "Coordinator: workers store intermediate runs with prefix compression.")
+
+	flag.Parse()
+	log.SetFlags(0)
+
+	switch strings.ToLower(*mode) {
+	case "worker":
+		runWorker(*listen, *dir)
+
+	case "coordinator":
+		if *blockSize <= 0 {
+			log.Fatalf("blocksize must be positive, but %d was given", *blockSize)
+		}
+
+		if *memoryLimit / *blockSize < 3 {
+			log.Fatalf("'memory' must be at least three times larger than 'blocksize'")
+		}
+
+		delim, err := config.ParseDelimiter(*delimiter)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		ord, err := config.ParseOrder(*order)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		settings := cluster.Settings{
+			BlockSize:   *blockSize,
+			MemoryLimit: *memoryLimit,
+			Delimiter:   delim,
+			Order:       ord,
+			FrontCoding: *frontCoding,
+		}
+
+		runCoordinator(settings, strings.Split(*workers, ","), *inputFilepath, *outputFilepath)
+
+	default:
+		log.Fatalf("only worker and coordinator modes are supported, but %s was given", *mode)
+	}
+}
+
+func runWorker(listen, dir string) {
+	server := rpc.NewServer()
+	err := server.RegisterName(cluster.ServiceName, cluster.NewWorker(dir))
+	if err != nil {
+		log.Fatalf("failed to register worker: %v\n", err)
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Fatalf("failed to listen: %v\n", err)
+	}
+
+	log.Printf("worker is listening on %s, files are stored in %s\n", listener.Addr(), dir)
+
+	server.Accept(listener)
+}
+
+func runCoordinator(settings cluster.Settings, workers []string, inputPath, outputPath string) {
+	coordinator, err := cluster.NewCoordinator(settings, workers)
+	if err != nil {
+		log.Fatalf("failed to connect to workers: %v\n", err)
+	}
+	defer func() {
+		_ = coordinator.Close()
+	}()
+
+	err = coordinator.Sort(inputPath, outputPath)
+	if err != nil {
+		log.Fatalf("sort failed: %v\n", err)
+	}
+}
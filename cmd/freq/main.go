@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/lodthe/external-merge-sort/pkg/algo"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+)
+
+func main() {
+	var blockSize = flag.Int("blocksize", 1024*1024, "Size of one block (in bytes).")
+	var memoryLimit = flag.Int("memory", 512*1024*1024, "The algorithm will use at most O(memory) main memory.")
+	var top = flag.Int("top", 0, "How many of the most frequent tokens are written. If zero, all tokens are written ordered by count.")
+	var delimiter = flag.String("delimiter", "\n", "Bytes used to separate tokens. Escape sequences are supported, e.g. \\r\\n.")
+	var crlf = flag.String("crlf", "keep", "How \"\\r\" before the \"\\n\" delimiter is handled. Supported values: keep (a part of the token), normalize (removed), preserve (ignored while counting, all output lines end with \"\\r\\n\", even if they ended with \"\\n\" in the input).")
+	var framing = flag.String("framing", "delimiter", "How tokens are stored in the input and output files. Supported values: delimiter, varint (uvarint length prefix), fixed32 (big-endian uint32 length prefix).")
+	var fieldSeparator = flag.String("field-separator", "\\t", "Bytes written between the count and the token. Escape sequences are supported.")
+	var inputFilepath = flag.String("input", "input.txt", "Input file path.")
+	var outputFilepath = flag.String("output", "output.txt", "Output file path. Each output token is a count and a token, the most frequent tokens go first.")
+	var tempDir = flag.String("tempdir", ".", "Where temporary files can be created.")
+
+	flag.Parse()
+	log.SetFlags(0)
+
+	if *blockSize <= 0 {
+		log.Fatalf("blocksize must be positive, but %d was given", *blockSize)
+	}
+
+	if *memoryLimit / *blockSize < 3 {
+		log.Fatalf("'memory' must be at least three times larger than 'blocksize'")
+	}
+
+	if *top < 0 {
+		log.Fatalf("top must not be negative, but %d was given", *top)
+	}
+
+	delim, err := config.ParseDelimiter(*delimiter)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	crlfMode, err := config.ParseCRLF(*crlf)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	fr, err := config.ParseFraming(*framing)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if fr != config.FramingDelimiter && fr != config.FramingVarint && fr != config.FramingFixed32 {
+		log.Fatalf("freq supports the delimiter, varint and fixed32 framings only")
+	}
+
+	sep, err := config.ParseDelimiter(*fieldSeparator)
+	if err != nil {
+		log.Fatalf("invalid field-separator: %v", err)
+	}
+
+	cfg := &config.Config{
+		BlockSize:   *blockSize,
+		MemoryLimit: *memoryLimit,
+		Delimiter:   delim,
+		CRLF:        crlfMode,
+		Framing:     fr,
+		Less:        config.LessASC,
+		Order:       config.OrderASC,
+		Merger:      algo.KWayMerger{},
+	}
+
+	err = algo.NewFrequency(cfg, *top, sep).Count(*inputFilepath, *outputFilepath, *tempDir)
+	if err != nil {
+		log.Fatalf("freq failed: %v\n", err)
+	}
+}
@@ -36,7 +36,7 @@ func alphabetNumbers() (res string) {
 
 func alphabetNonSpace() (res string) {
 	for c := 33; c <= 127; c++ {
-		res += string(c)
+		res += string(rune(c))
 	}
 
 	return res
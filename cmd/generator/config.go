@@ -2,6 +2,8 @@ package main
 
 import (
 	"os"
+
+	sortconfig "github.com/lodthe/external-merge-sort/pkg/config"
 )
 
 type config struct {
@@ -13,6 +15,7 @@ type config struct {
 	maxLength         int
 	equalPrefixLength int
 
-	delimiter byte
+	delimiter []byte
+	framing   sortconfig.Framing
 	alphabet  []byte
 }
@@ -1,10 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"math/rand"
+
+	sortconfig "github.com/lodthe/external-merge-sort/pkg/config"
 )
 
+const bufferSize = 128 * 1024
+
 type generator struct {
 	cfg *config
 	rnd *rand.Rand
@@ -18,24 +21,21 @@ func newGenerator(cfg *config, rnd *rand.Rand) *generator {
 }
 
 func (g *generator) generate() error {
-	w := bufio.NewWriter(g.cfg.output)
+	cfg := &sortconfig.Config{
+		BlockSize:  bufferSize,
+		Delimiter:  g.cfg.delimiter,
+		Framing:    g.cfg.framing,
+		RecordSize: g.cfg.maxLength,
+	}
+	w := cfg.NewWriter(g.cfg.output, 0)
 
 	equalPref := g.token(g.cfg.equalPrefixLength)
 
 	for i := 0; i < g.cfg.count; i++ {
-		_, err := w.Write(equalPref)
-		if err != nil {
-			return err
-		}
-
 		length := g.cfg.minLength + g.rnd.Intn(g.cfg.maxLength-g.cfg.minLength+1)
-		suffix := g.token(length - g.cfg.equalPrefixLength)
-		_, err = w.Write(suffix)
-		if err != nil {
-			return err
-		}
+		token := append(append([]byte(nil), equalPref...), g.token(length-g.cfg.equalPrefixLength)...)
 
-		err = w.WriteByte(g.cfg.delimiter)
+		err := w.Write(token)
 		if err != nil {
 			return err
 		}
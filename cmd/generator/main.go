@@ -7,6 +7,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	sortconfig "github.com/lodthe/external-merge-sort/pkg/config"
 )
 
 func main() {
@@ -15,7 +17,8 @@ func main() {
 	var minLength = flag.Int("min-length", 128, "Minimum allowed token length.")
 	var maxLength = flag.Int("max-length", 128, "Maximum allowed token length.")
 	var equalPrefixLength = flag.Int("equal-prefix-length", 0, "All tokens will begin with the same prefix, and you can specify the length of this prefix.")
-	var delimiter = flag.String("delimiter", "\n", "A character used to separate tokens.")
+	var delimiter = flag.String("delimiter", "\n", "Bytes used to separate tokens. Escape sequences are supported, e.g. \\r\\n.")
+	var framing = flag.String("framing", "delimiter", "How tokens are stored. Supported values: delimiter, varint (uvarint length prefix), fixed32 (big-endian uint32 length prefix), fixed (records of max-length bytes, requires min-length = max-length).")
 	var alphabetName = flag.String("alphabet", "lower", `You can specify one of the supported sets of characters used for generation:
 binary - 01;
 lower - abc..xyz;
@@ -30,8 +33,14 @@ If the specified value doesn't match with any of the predefined alphabet names,
 	flag.Parse()
 	log.SetFlags(0)
 
-	if len(*delimiter) != 1 {
-		log.Fatalf("only one character can be specified as delimiter, but '%s' was given\n", *delimiter)
+	delim, err := sortconfig.ParseDelimiter(*delimiter)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	fr, err := sortconfig.ParseFraming(*framing)
+	if err != nil {
+		log.Fatalf("%v\n", err)
 	}
 
 	if *filepath == "" {
@@ -54,6 +63,10 @@ If the specified value doesn't match with any of the predefined alphabet names,
 		*minLength = *maxLength
 	}
 
+	if fr == sortconfig.FramingFixed && *minLength != *maxLength {
+		log.Fatalf("min-length and max-length must be equal for the fixed framing\n")
+	}
+
 	if *equalPrefixLength > *minLength {
 		*equalPrefixLength = *minLength
 	}
@@ -81,7 +94,8 @@ If the specified value doesn't match with any of the predefined alphabet names,
 		minLength:         *minLength,
 		maxLength:         *maxLength,
 		equalPrefixLength: *equalPrefixLength,
-		delimiter:         (*delimiter)[0],
+		delimiter:         delim,
+		framing:           fr,
 		alphabet:          []byte(alphabet),
 	}
 
@@ -98,7 +112,8 @@ length: [%d; %d]
 equal prefix length: %d
 
 delimiter: %#v
-alphabet: %s`, *filepath, cfg.count, cfg.minLength, cfg.maxLength, cfg.equalPrefixLength, string(cfg.delimiter), string(cfg.alphabet))
+framing: %s
+alphabet: %s`, *filepath, cfg.count, cfg.minLength, cfg.maxLength, cfg.equalPrefixLength, string(cfg.delimiter), *framing, string(cfg.alphabet))
 
 	err = gen.generate()
 	if err != nil {
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/lodthe/external-merge-sort/pkg/algo"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+)
+
+func main() {
+	var blockSize = flag.Int("blocksize", 1024*1024, "Size of one block (in bytes).")
+	var memoryLimit = flag.Int("memory", 512*1024*1024, "The algorithm will use at most O(memory) main memory.")
+	var joinTypeName = flag.String("type", "inner", "Join type. Supported values: inner, left (left records without a match are kept), full (left and right records without a match are kept).")
+	var framing = flag.String("framing", "delimiter", "How records are stored in the input and output files. Supported values: delimiter (fields are separated by field-separator), csv (records with quoted fields).")
+	var delimiter = flag.String("delimiter", "\n", "Bytes used to separate records for the delimiter framing. Escape sequences are supported, e.g. \\r\\n.")
+	var fieldSeparator = flag.String("field-separator", "\\t", "Bytes used to separate fields for the delimiter framing. Escape sequences are supported.")
+	var csvComma = flag.String("csv-comma", ",", "CSV field separator, e.g. ; or \\t.")
+	var header = flag.Bool("header", false, "The first record of each input is a header. Column names can be used in keys, and the output starts with both headers joined.")
+	var leftKeys = flag.String("left-keys", "1", "Key columns of the left input, e.g. id or 2:number. A column is a name from the header or a 1-based number, optionally followed by a type (string, number).")
+	var rightKeys = flag.String("right-keys", "1", "Key columns of the right input, in the same format and with the same types as left-keys.")
+	var leftFilepath = flag.String("left", "left.txt", "Left input file path.")
+	var rightFilepath = flag.String("right", "right.txt", "Right input file path.")
+	var outputFilepath = flag.String("output", "output.txt", "Output file path.")
+	var tempDir = flag.String("tempdir", ".", "Where temporary files can be created. Unsorted inputs are sorted there.")
+
+	flag.Parse()
+	log.SetFlags(0)
+
+	if *blockSize <= 0 {
+		log.Fatalf("blocksize must be positive, but %d was given", *blockSize)
+	}
+
+	if *memoryLimit / *blockSize < 3 {
+		log.Fatalf("'memory' must be at least three times larger than 'blocksize'")
+	}
+
+	joinType, err := algo.ParseJoinType(*joinTypeName)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	fr, err := config.ParseFraming(*framing)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	delim, err := config.ParseDelimiter(*delimiter)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var separator []byte
+	var split func(record []byte) ([]string, error)
+	switch fr {
+	case config.FramingDelimiter:
+		separator, err = config.ParseDelimiter(*fieldSeparator)
+		if err != nil {
+			log.Fatalf("invalid field-separator: %v", err)
+		}
+
+		split = func(record []byte) ([]string, error) {
+			return strings.Split(string(record), string(separator)), nil
+		}
+
+	case config.FramingCSV:
+		comma, err := config.ParseComma(*csvComma)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		separator = []byte(string(comma))
+		split = func(record []byte) ([]string, error) {
+			return config.CSVFields(record, comma)
+		}
+
+	default:
+		log.Fatalf("join supports the delimiter and csv framings only")
+	}
+
+	cfg := &config.Config{
+		BlockSize:   *blockSize,
+		MemoryLimit: *memoryLimit,
+		Delimiter:   delim,
+		Framing:     fr,
+		Header:      *header,
+		Less:        config.LessASC,
+		Order:       config.OrderASC,
+	}
+
+	leftColumns := parseKeys(cfg, "left-keys", *leftKeys, *leftFilepath, split)
+	rightColumns := parseKeys(cfg, "right-keys", *rightKeys, *rightFilepath, split)
+
+	if len(leftColumns) != len(rightColumns) {
+		log.Fatalf("left-keys and right-keys must have the same number of columns")
+	}
+	for i := range leftColumns {
+		if leftColumns[i].Type != rightColumns[i].Type || leftColumns[i].Desc != rightColumns[i].Desc {
+			log.Fatalf("types of key column %d differ in left-keys and right-keys", i+1)
+		}
+	}
+
+	err = algo.NewSortMergeJoin(cfg, joinType, separator, split).Join(
+		context.Background(),
+		algo.JoinSide{Path: *leftFilepath, KeyFunc: config.ColumnKeyFunc(leftColumns, split)},
+		algo.JoinSide{Path: *rightFilepath, KeyFunc: config.ColumnKeyFunc(rightColumns, split)},
+		*outputFilepath,
+		*tempDir,
+	)
+	if err != nil {
+		log.Fatalf("join failed: %v\n", err)
+	}
+}
+
+// parseKeys parses key columns of an input. Column names are resolved with the header of the input.
+func parseKeys(cfg *config.Config, name, spec, path string, split func(record []byte) ([]string, error)) []config.Column {
+	columns, err := config.ParseColumns(spec)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+	if len(columns) == 0 {
+		log.Fatalf("%s must not be empty", name)
+	}
+
+	var names []string
+	if cfg.Header {
+		first, err := cfg.ReadHeader(path)
+		if err != nil {
+			log.Fatalf("failed to read the header of %s: %v", path, err)
+		}
+
+		names, err = split(first)
+		if err != nil {
+			log.Fatalf("failed to parse the header of %s: %v", path, err)
+		}
+	}
+
+	err = config.ResolveColumns(columns, names)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+
+	return columns
+}
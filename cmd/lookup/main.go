@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/lodthe/external-merge-sort/pkg/index"
+)
+
+func main() {
+	var blockSize = flag.Int("blocksize", 64*1024, "Size of one block (in bytes) read from the sorted file.")
+	var delimiter = flag.String("delimiter", "\n", "Bytes used to separate tokens. Escape sequences are supported, e.g. \\r\\n.")
+	var crlf = flag.String("crlf", "keep", "How \"\\r\" before the \"\\n\" delimiter is handled. Supported values: keep (a part of the token), normalize (removed), preserve (ignored while comparing).")
+	var order = flag.String("order", "ASC", "Sort order. Supported values: ASC, DESC.")
+	var framing = flag.String("framing", "delimiter", "How tokens are stored in the sorted file. Supported values: delimiter, varint, fixed32, multiline (records of several lines, see record-start), csv (records with quoted fields, see columns).")
+	var recordStart = flag.String("record-start", "", "Regular expression matching the first line of a record for the multiline framing. If empty, records are paragraphs separated by blank lines. Records are compared by their first lines.")
+	var header = flag.Bool("header", false, "The first token of the sorted file is a header (e.g. CSV column names). It's never returned.")
+	var columns = flag.String("columns", "", "CSV columns the records are sorted by, e.g. price:number:desc,2. A column is a name from the header or a 1-based number, optionally followed by a type (string, number) and a direction (asc, desc). If empty, all fields are compared as strings.")
+	var csvComma = flag.String("csv-comma", ",", "CSV field separator, e.g. ; or \\t.")
+	var jsonKeys = flag.String("json-keys", "", "JSON lines are sorted by the given paths, e.g. .user.id,.ts:desc. Queries are JSON documents then, e.g. {\"user\":{\"id\":5}}.")
+	var jsonMissing = flag.String("json-missing", "first", "Where records without a JSON key or with invalid JSON go. Supported values: first, last, error.")
+	var inputFilepath = flag.String("input", "output.txt", "Sorted file path.")
+	var indexFilepath = flag.String("index", "", "Index file path. If empty, <input>.idx is used.")
+	var exact = flag.String("exact", "", "Print tokens equal to the given one.")
+	var prefix = flag.String("prefix", "", "Print tokens that begin with the given bytes. Only for the delimiter, varint and fixed32 framings without keys.")
+	var from = flag.String("from", "", "Print tokens starting with the first token not less than the given one. Can be combined with to.")
+	var to = flag.String("to", "", "Print tokens less than the given one. Can be combined with from.")
+	var count = flag.Bool("count", false, "Print the number of found tokens instead of the tokens.")
+
+	flag.Parse()
+	log.SetFlags(0)
+
+	if *blockSize <= 0 {
+		log.Fatalf("blocksize must be positive, but %d was given", *blockSize)
+	}
+
+	isSet := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		isSet[f.Name] = true
+	})
+
+	queries := 0
+	for _, name := range []string{"exact", "prefix", "from"} {
+		if isSet[name] || name == "from" && isSet["to"] {
+			queries++
+		}
+	}
+	if queries != 1 {
+		log.Fatalf("exactly one query must be given: exact, prefix or from/to")
+	}
+
+	delim, err := config.ParseDelimiter(*delimiter)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	crlfMode, err := config.ParseCRLF(*crlf)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ord, err := config.ParseOrder(*order)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	fr, err := config.ParseFraming(*framing)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if fr == config.FramingFixed {
+		log.Fatalf("lookup doesn't support the fixed framing")
+	}
+
+	less, lessOrder := ord.Less(), ord
+
+	var startPattern *regexp.Regexp
+	if fr == config.FramingMultiline {
+		if *recordStart != "" {
+			startPattern, err = regexp.Compile(*recordStart)
+			if err != nil {
+				log.Fatalf("invalid record-start: %v", err)
+			}
+		}
+
+		less, lessOrder = config.FirstLine(less), config.OrderCustom
+	}
+
+	// CSV flags would be silently ignored with other framings.
+	for _, name := range []string{"columns", "csv-comma"} {
+		if isSet[name] && fr != config.FramingCSV {
+			log.Fatalf("%s can be used with the csv framing only", name)
+		}
+	}
+
+	var keyFunc config.KeyFunc
+	if fr == config.FramingCSV {
+		comma, err := config.ParseComma(*csvComma)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		cols, err := config.ParseColumns(*columns)
+		if err != nil {
+			log.Fatalf("invalid columns: %v", err)
+		}
+
+		var names []string
+		if *header {
+			first, err := (&config.Config{BlockSize: *blockSize, Framing: fr}).ReadHeader(*inputFilepath)
+			if err != nil {
+				log.Fatalf("failed to read the header: %v", err)
+			}
+
+			names, err = config.CSVFields(first, comma)
+			if err != nil {
+				log.Fatalf("failed to parse the header: %v", err)
+			}
+		}
+
+		err = config.ResolveColumns(cols, names)
+		if err != nil {
+			log.Fatalf("invalid columns: %v", err)
+		}
+
+		keyFunc = config.CSVKeyFunc(cols, comma, ord)
+		lessOrder = config.OrderCustom
+	}
+
+	if *jsonKeys != "" {
+		if keyFunc != nil {
+			log.Fatalf("json-keys can't be used with the csv framing")
+		}
+
+		keys, err := config.ParseJSONKeys(*jsonKeys)
+		if err != nil {
+			log.Fatalf("invalid json-keys: %v", err)
+		}
+
+		missing, err := config.ParseMissingKey(*jsonMissing)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		if ord == config.OrderDESC {
+			for i := range keys {
+				keys[i].Desc = !keys[i].Desc
+			}
+		}
+
+		keyFunc = config.JSONKeyFunc(keys, missing)
+		lessOrder = config.OrderCustom
+	}
+
+	if isSet["prefix"] && lessOrder == config.OrderCustom {
+		log.Fatalf("prefix queries are supported for byte-wise orders only")
+	}
+
+	cfg := &config.Config{
+		BlockSize:   *blockSize,
+		Delimiter:   delim,
+		CRLF:        crlfMode,
+		Framing:     fr,
+		RecordStart: startPattern,
+		Header:      *header,
+		KeyFunc:     keyFunc,
+		Less:        less,
+		Order:       lessOrder,
+	}
+
+	if *indexFilepath == "" {
+		*indexFilepath = *inputFilepath + index.Suffix
+	}
+
+	idx, err := index.Load(*indexFilepath)
+	if err != nil {
+		log.Fatalf("failed to load the index: %v\n", err)
+	}
+
+	input, err := os.Open(*inputFilepath)
+	if err != nil {
+		log.Fatalf("failed to open the sorted file: %v\n", err)
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+
+	separator := []byte("\n")
+	if fr == config.FramingDelimiter {
+		separator = delim
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+
+	found := 0
+	emit := func(token []byte) error {
+		found++
+		if *count {
+			return nil
+		}
+
+		_, err := w.Write(token)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(separator)
+		return err
+	}
+
+	searcher := index.NewSearcher(cfg, input, idx)
+	switch {
+	case isSet["exact"]:
+		err = searcher.Exact([]byte(*exact), emit)
+
+	case isSet["prefix"]:
+		err = searcher.Prefix([]byte(*prefix), emit)
+
+	default:
+		var lower, upper []byte
+		if isSet["from"] {
+			lower = []byte(*from)
+		}
+		if isSet["to"] {
+			upper = []byte(*to)
+		}
+
+		err = searcher.Range(lower, upper, emit)
+	}
+	if err != nil {
+		log.Fatalf("lookup failed: %v\n", err)
+	}
+
+	if *count {
+		_, err = fmt.Fprintln(w, found)
+		if err != nil {
+			log.Fatalf("write failed: %v\n", err)
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		log.Fatalf("write failed: %v\n", err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/lodthe/external-merge-sort/pkg/algo"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+)
+
+func main() {
+	var op = flag.String("op", "union", "Set operation. Supported values: union, intersection, difference (tokens of the first input missing from the others), symdiff (tokens found in exactly one input), comm (three columns like comm(1), exactly two inputs).")
+	var blockSize = flag.Int("blocksize", 1024*1024, "Size of one block (in bytes).")
+	var delimiter = flag.String("delimiter", "\n", "Bytes used to separate tokens. Escape sequences are supported, e.g. \\r\\n.")
+	var crlf = flag.String("crlf", "keep", "How \"\\r\" before the \"\\n\" delimiter is handled. Supported values: keep (a part of the token), normalize (removed), preserve (ignored while comparing, all output lines end with \"\\r\\n\", even if they ended with \"\\n\" in the input).")
+	var order = flag.String("order", "ASC", "Sort order of the inputs. Supported values: ASC, DESC.")
+	var framing = flag.String("framing", "delimiter", "How tokens are stored in the input and output files. Supported values: delimiter, varint, fixed32, fixed, multiline (records of several lines, see record-start), csv (records with quoted fields, see columns).")
+	var recordStart = flag.String("record-start", "", "Regular expression matching the first line of a record for the multiline framing. If empty, records are paragraphs separated by blank lines. Records are compared by their first lines.")
+	var header = flag.Bool("header", false, "The first token of each input is a header (e.g. CSV column names). The header of the first input is written to the output.")
+	var columns = flag.String("columns", "", "CSV columns the records are sorted by, e.g. price:number:desc,2. A column is a name from the header or a 1-based number, optionally followed by a type (string, number) and a direction (asc, desc). If empty, all fields are compared as strings.")
+	var csvComma = flag.String("csv-comma", ",", "CSV field separator, e.g. ; or \\t.")
+	var jsonKeys = flag.String("json-keys", "", "JSON lines are sorted by the given paths, e.g. .user.id,.ts:desc. Records with equal keys are equal.")
+	var jsonMissing = flag.String("json-missing", "first", "Where records without a JSON key or with invalid JSON go. Supported values: first, last, error.")
+	var recordSize = flag.Int("record-size", 0, "Size of one record for the fixed framing.")
+	var keyOffset = flag.Int("key-offset", 0, "Offset of the key in a fixed-width record.")
+	var keyLength = flag.Int("key-length", 0, "Length of the key in a fixed-width record. If zero, the key lasts until the end of the record.")
+	var keyType = flag.String("key-type", "bytes", "How keys of fixed-width records are compared. Supported values: bytes, uint-be, uint-le, int-be, int-le, float-be, float-le.")
+	var inputFilepaths = flag.String("inputs", "", "Comma-separated paths of sorted input files, at least two.")
+	var outputFilepath = flag.String("output", "output.txt", "Output file path.")
+
+	flag.Parse()
+	log.SetFlags(0)
+
+	if *blockSize <= 0 {
+		log.Fatalf("blocksize must be positive, but %d was given", *blockSize)
+	}
+
+	setOp, err := algo.ParseSetOp(*op)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var inputs []string
+	if *inputFilepaths != "" {
+		inputs = strings.Split(*inputFilepaths, ",")
+	}
+	for _, input := range inputs {
+		if input == *outputFilepath {
+			log.Fatalf("output file %s is one of the inputs", input)
+		}
+	}
+
+	delim, err := config.ParseDelimiter(*delimiter)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	crlfMode, err := config.ParseCRLF(*crlf)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ord, err := config.ParseOrder(*order)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	fr, err := config.ParseFraming(*framing)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	less := ord.Less()
+	if fr == config.FramingFixed {
+		if *recordSize <= 0 {
+			log.Fatalf("record-size must be positive for the fixed framing, but %d was given", *recordSize)
+		}
+
+		key, err := config.ParseKey(*keyOffset, *keyLength, *keyType, *recordSize)
+		if err != nil {
+			log.Fatalf("invalid key: %v", err)
+		}
+
+		less = key.Less(ord)
+	}
+
+	var startPattern *regexp.Regexp
+	if fr == config.FramingMultiline {
+		if *recordStart != "" {
+			startPattern, err = regexp.Compile(*recordStart)
+			if err != nil {
+				log.Fatalf("invalid record-start: %v", err)
+			}
+		}
+
+		less = config.FirstLine(less)
+	}
+
+	// CSV flags would be silently ignored with other framings.
+	if fr != config.FramingCSV {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "columns" || f.Name == "csv-comma" {
+				log.Fatalf("%s can be used with the csv framing only", f.Name)
+			}
+		})
+	}
+
+	var keyFunc config.KeyFunc
+	if fr == config.FramingCSV {
+		comma, err := config.ParseComma(*csvComma)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		cols, err := config.ParseColumns(*columns)
+		if err != nil {
+			log.Fatalf("invalid columns: %v", err)
+		}
+
+		var names []string
+		if *header && len(inputs) > 0 {
+			first, err := (&config.Config{BlockSize: *blockSize, Framing: fr}).ReadHeader(inputs[0])
+			if err != nil {
+				log.Fatalf("failed to read the header: %v", err)
+			}
+
+			names, err = config.CSVFields(first, comma)
+			if err != nil {
+				log.Fatalf("failed to parse the header: %v", err)
+			}
+		}
+
+		err = config.ResolveColumns(cols, names)
+		if err != nil {
+			log.Fatalf("invalid columns: %v", err)
+		}
+
+		keyFunc = config.CSVKeyFunc(cols, comma, ord)
+	}
+
+	if *jsonKeys != "" {
+		if keyFunc != nil {
+			log.Fatalf("json-keys can't be used with the csv framing")
+		}
+
+		keys, err := config.ParseJSONKeys(*jsonKeys)
+		if err != nil {
+			log.Fatalf("invalid json-keys: %v", err)
+		}
+
+		missing, err := config.ParseMissingKey(*jsonMissing)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		if ord == config.OrderDESC {
+			for i := range keys {
+				keys[i].Desc = !keys[i].Desc
+			}
+		}
+
+		keyFunc = config.JSONKeyFunc(keys, missing)
+	}
+
+	cfg := &config.Config{
+		BlockSize:   *blockSize,
+		Delimiter:   delim,
+		CRLF:        crlfMode,
+		Framing:     fr,
+		RecordStart: startPattern,
+		Header:      *header,
+		KeyFunc:     keyFunc,
+		RecordSize:  *recordSize,
+		Less:        less,
+	}
+
+	err = algo.MergeSets(cfg, setOp, inputs, *outputFilepath)
+	if err != nil {
+		log.Fatalf("set operation failed: %v\n", err)
+	}
+}
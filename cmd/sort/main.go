@@ -1,23 +1,52 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"flag"
 	"log"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/lodthe/external-merge-sort/pkg/algo"
 	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/lodthe/external-merge-sort/pkg/index"
 )
 
 func main() {
 	var blockSize = flag.Int("blocksize", 1024*1024, "Size of one block (in bytes).")
 	var memoryLimit = flag.Int("memory", 512*1024*1024, "The algorithm will use at most O(memory) main memory.")
-	var delimiter = flag.String("delimiter", "\n", "A character used to separate tokens.")
+	var delimiter = flag.String("delimiter", "\n", "Bytes used to separate tokens. Escape sequences are supported, e.g. \\r\\n.")
+	var crlf = flag.String("crlf", "keep", "How \"\\r\" before the \"\\n\" delimiter is handled. Supported values: keep (a part of the token), normalize (removed), preserve (ignored while sorting, all output lines end with \"\\r\\n\", even if they ended with \"\\n\" in the input).")
 	var order = flag.String("order", "ASC", "Sort order. Supported values: ASC, DESC.")
+	var framing = flag.String("framing", "delimiter", "How tokens are stored in the input and output files. Supported values: delimiter, varint (uvarint length prefix), fixed32 (big-endian uint32 length prefix), fixed (records of record-size bytes), multiline (records of several lines, see record-start), csv (records with quoted fields, see columns).")
+	var recordStart = flag.String("record-start", "", "Regular expression matching the first line of a record for the multiline framing. If empty, records are paragraphs separated by blank lines. Records are compared by their first lines.")
+	var header = flag.Bool("header", false, "The first token of the input is a header (e.g. CSV column names). It isn't sorted and stays at the top of the output.")
+	var columns = flag.String("columns", "", "CSV columns the records are sorted by, e.g. price:number:desc,2. A column is a name from the header or a 1-based number, optionally followed by a type (string, number) and a direction (asc, desc). If empty, all fields are compared as strings.")
+	var csvComma = flag.String("csv-comma", ",", "CSV field separator, e.g. ; or \\t.")
+	var jsonKeys = flag.String("json-keys", "", "Sort JSON lines by the given paths, e.g. .user.id,.ts:desc. Values are compared by type first (null, false, true, numbers, strings, objects and arrays), then by value.")
+	var jsonMissing = flag.String("json-missing", "first", "Where records without a JSON key or with invalid JSON go. Supported values: first, last, error.")
+	var recordSize = flag.Int("record-size", 0, "Size of one record for the fixed framing.")
+	var keyOffset = flag.Int("key-offset", 0, "Offset of the key in a fixed-width record.")
+	var keyLength = flag.Int("key-length", 0, "Length of the key in a fixed-width record. If zero, the key lasts until the end of the record.")
+	var keyType = flag.String("key-type", "bytes", "How keys of fixed-width records are compared. Supported values: bytes, uint-be, uint-le, int-be, int-le, float-be, float-le.")
 	var inputFilepath = flag.String("input", "input.txt", "Input file path.")
 	var outputFilepath = flag.String("output", "output.txt", "Output file path.")
 	var tempDir = flag.String("tempdir", ".", "Where temporary files can be created. If you use /tmp, make sure there is enough space for two copies of the input file.")
+	var runSorterName = flag.String("run-sorter", "radix", "How runs are sorted in main memory. Supported values: comparison, radix (falls back to comparison for custom orders).")
+	var mergerName = flag.String("merger", "2way", "How runs are merged. Supported values: 2way, kway, polyphase (Fibonacci distribution of runs over fan-in+1 temp files).")
+	var fanIn = flag.Int("fan-in", 0, "How many runs kway and polyphase mergers merge at once. If not positive, it's derived from memory and blocksize.")
+	var adaptive = flag.Bool("adaptive", false, "Detect sorted and reverse-sorted portions of the input. Already sorted input is written in one pass (or not written at all if input and output are the same file).")
+	var partitions = flag.Int("partitions", 1, "Split the input into this many ranges by sampled splitters and sort them in parallel. Memory is divided between partitions.")
+	var groupBy = flag.String("group-by", "", "Replace tokens with equal group keys with one token: the key and an aggregate joined by field-separator. Supported aggregates: count, sum:N, min:N, max:N (append :number to compare numbers), first:N, last:N, where N is a 1-based field number. Tokens are combined while runs are generated and merged.")
+	var groupKey = flag.String("group-key", "", "Comma-separated 1-based numbers of the fields tokens are grouped by, e.g. 1,3. If empty, the whole token is the key.")
+	var hashGroup = flag.Bool("hash-group", false, "Group by hash partitioning instead of sorting (see group-by). Does less work than a sort, but groups are written in no particular order.")
+	var fieldSeparator = flag.String("field-separator", "\\t", "Bytes used to separate fields of tokens for group-by. Escape sequences are supported.")
+	var tagSort = flag.Bool("tag-sort", false, "Sort (key, offset, length) tags instead of whole tokens and gather tokens from the input in one final pass. Saves disk writes for wide records with short keys (see json-keys, key-offset and key-length), but reads the input randomly.")
+	var argsort = flag.String("argsort", "", "Write the permutation instead of the sorted tokens: for each position of the sorted order, the index of the token in the input. Supported values: ordinal (zero-based token number, the header isn't counted), offset (byte offset in the input). Keys are chosen like for tag-sort.")
+	var argsortFormat = flag.String("argsort-format", "text", "How argsort indices are written. Supported values: text (one number per line), binary (little-endian uint64).")
+	var indexEvery = flag.Int("index-every", 0, "Write a sparse index of the output to <output>.idx: every Nth token with its offset. It's used by lookup. If zero, no index is written.")
+	var frontCoding = flag.Bool("front-coding", false, "Store intermediate runs with prefix compression. Saves disk I/O when neighbouring tokens share long prefixes.")
 
 	flag.Parse()
 	log.SetFlags(0)
@@ -30,34 +59,273 @@ func main() {
 		log.Fatalf("'memory' must be at least three times larger than 'blocksize'")
 	}
 
-	if len(*delimiter) != 1 {
-		log.Fatalf("only one character can be specified as delimiter, but %s was given", *delimiter)
+	if *indexEvery < 0 {
+		log.Fatalf("index-every must not be negative, but %d was given", *indexEvery)
+	}
+
+	if *partitions < 1 {
+		log.Fatalf("partitions must be positive, but %d was given", *partitions)
+	}
+
+	if *memoryLimit / *partitions / *blockSize < 3 {
+		log.Fatalf("'memory' must be at least three times larger than 'blocksize' for each partition")
+	}
+
+	delim, err := config.ParseDelimiter(*delimiter)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	crlfMode, err := config.ParseCRLF(*crlf)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ord, err := config.ParseOrder(*order)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	fr, err := config.ParseFraming(*framing)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	runSorter, exists := runSorters[strings.ToLower(*runSorterName)]
+	if !exists {
+		log.Fatalf("unknown run sorter %s", *runSorterName)
+	}
+
+	merger, exists := newMerger(*mergerName, *fanIn)
+	if !exists {
+		log.Fatalf("unknown merger %s", *mergerName)
+	}
+
+	less, lessOrder := ord.Less(), ord
+
+	var key config.Key
+	if fr == config.FramingFixed {
+		if *recordSize <= 0 {
+			log.Fatalf("record-size must be positive for the fixed framing, but %d was given", *recordSize)
+		}
+
+		key, err = config.ParseKey(*keyOffset, *keyLength, *keyType, *recordSize)
+		if err != nil {
+			log.Fatalf("invalid key: %v", err)
+		}
+
+		less, lessOrder = key.Comparator(*recordSize, ord)
+	}
+
+	var startPattern *regexp.Regexp
+	if fr == config.FramingMultiline {
+		if *recordStart != "" {
+			startPattern, err = regexp.Compile(*recordStart)
+			if err != nil {
+				log.Fatalf("invalid record-start: %v", err)
+			}
+		}
+
+		less, lessOrder = config.FirstLine(less), config.OrderCustom
+	}
+
+	// CSV flags would be silently ignored with other framings.
+	if fr != config.FramingCSV {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "columns" || f.Name == "csv-comma" {
+				log.Fatalf("%s can be used with the csv framing only", f.Name)
+			}
+		})
+	}
+
+	var keyFunc config.KeyFunc
+	if fr == config.FramingCSV {
+		comma, err := config.ParseComma(*csvComma)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		cols, err := config.ParseColumns(*columns)
+		if err != nil {
+			log.Fatalf("invalid columns: %v", err)
+		}
+
+		var names []string
+		if *header {
+			first, err := (&config.Config{BlockSize: *blockSize, Framing: fr}).ReadHeader(*inputFilepath)
+			if err != nil {
+				log.Fatalf("failed to read the header: %v", err)
+			}
+
+			names, err = config.CSVFields(first, comma)
+			if err != nil {
+				log.Fatalf("failed to parse the header: %v", err)
+			}
+		}
+
+		err = config.ResolveColumns(cols, names)
+		if err != nil {
+			log.Fatalf("invalid columns: %v", err)
+		}
+
+		keyFunc = config.CSVKeyFunc(cols, comma, ord)
+	}
+
+	if *jsonKeys != "" {
+		if keyFunc != nil {
+			log.Fatalf("json-keys can't be used with the csv framing")
+		}
+
+		keys, err := config.ParseJSONKeys(*jsonKeys)
+		if err != nil {
+			log.Fatalf("invalid json-keys: %v", err)
+		}
+
+		missing, err := config.ParseMissingKey(*jsonMissing)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		if ord == config.OrderDESC {
+			for i := range keys {
+				keys[i].Desc = !keys[i].Desc
+			}
+		}
+
+		keyFunc = config.JSONKeyFunc(keys, missing)
 	}
 
 	cfg := &config.Config{
 		BlockSize:   *blockSize,
 		MemoryLimit: *memoryLimit,
-		Delimiter:   (*delimiter)[0],
+		Delimiter:   delim,
+		CRLF:        crlfMode,
+		Framing:     fr,
+		RecordStart: startPattern,
+		Header:      *header,
+		KeyFunc:     keyFunc,
+		RecordSize:  *recordSize,
+		Less:        less,
+		Order:       lessOrder,
+		FrontCoding: *frontCoding,
+		Adaptive:    *adaptive,
+		RunSorter:   runSorter,
+		Merger:      merger,
+	}
+
+	if *groupBy != "" {
+		if fr != config.FramingDelimiter && fr != config.FramingVarint && fr != config.FramingFixed32 {
+			log.Fatalf("group-by supports the delimiter, varint and fixed32 framings only")
+		}
+
+		if cfg.KeyFunc != nil {
+			log.Fatalf("group-by can't be used with json-keys")
+		}
+
+		aggregate, err := config.ParseAggregate(*groupBy)
+		if err != nil {
+			log.Fatalf("invalid group-by: %v", err)
+		}
+
+		var keyFields []int
+		if *groupKey != "" {
+			for _, f := range strings.Split(*groupKey, ",") {
+				n, err := strconv.Atoi(f)
+				if err != nil || n < 1 {
+					log.Fatalf("group-key field numbers start with 1, but %q was given", f)
+				}
+				keyFields = append(keyFields, n-1)
+			}
+		}
+
+		sep, err := config.ParseDelimiter(*fieldSeparator)
+		if err != nil {
+			log.Fatalf("invalid field-separator: %v", err)
+		}
+
+		cfg.Combiner, cfg.KeyFunc = config.GroupBy(aggregate, keyFields, sep, ord)
+	}
+
+	if *hashGroup {
+		if cfg.Combiner == nil {
+			log.Fatalf("hash-group requires group-by")
+		}
+
+		if *tagSort || *argsort != "" || *partitions > 1 {
+			log.Fatalf("hash-group can't be used with tag-sort, argsort and partitions")
+		}
+
+		if *indexEvery > 0 {
+			log.Fatalf("hash-group output isn't sorted, so it can't be indexed")
+		}
+	}
+
+	if *tagSort || *argsort != "" {
+		if cfg.Combiner != nil {
+			log.Fatalf("tag sort and argsort don't support group-by")
+		}
+
+		if *partitions > 1 {
+			log.Fatalf("tag sort and argsort don't support partitions")
+		}
+
+		if *argsort != "" && *indexEvery > 0 {
+			log.Fatalf("argsort output can't be indexed")
+		}
+
+		switch {
+		case cfg.KeyFunc != nil:
+
+		case fr == config.FramingFixed:
+			cfg.KeyFunc = key.KeyFunc(ord)
+
+		case lessOrder != config.OrderCustom:
+			cfg.KeyFunc = config.TokenKeyFunc(ord)
+
+		default:
+			log.Fatalf("tag sort and argsort support JSON keys, keys of fixed-width records and whole tokens only")
+		}
 	}
 
 	switch {
-	case strings.EqualFold(*order, "ASC"):
-		cfg.Less = func(a, b []byte) bool {
-			return bytes.Compare(a, b) < 0
+	case *argsort != "":
+		position, err := algo.ParseIndex(*argsort)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		format, err := algo.ParseIndexFormat(*argsortFormat)
+		if err != nil {
+			log.Fatalf("%v", err)
 		}
 
-	case strings.EqualFold(*order, "DESC"):
-		cfg.Less = func(a, b []byte) bool {
-			return bytes.Compare(a, b) > 0
+		err = algo.NewTagSort(cfg).Argsort(context.Background(), *inputFilepath, *outputFilepath, *tempDir, position, format)
+		if err != nil {
+			log.Fatalf("argsort failed: %v\n", err)
 		}
 
+		return
+
+	case *hashGroup:
+		err = algo.NewHashGroupBy(cfg).Group(*inputFilepath, *outputFilepath, *tempDir)
+
+	case *tagSort:
+		err = algo.NewTagSort(cfg).Sort(*inputFilepath, *outputFilepath, *tempDir)
+
+	case *partitions > 1:
+		err = algo.NewSampleSort(cfg, *partitions).Sort(*inputFilepath, *outputFilepath, *tempDir)
+
 	default:
-		log.Fatalf("only ASC and DESC orders are supported, but %s was given", *order)
+		err = algo.NewExternalMergeSort(cfg).Sort(*inputFilepath, *outputFilepath, *tempDir)
 	}
-
-	msort := algo.NewExternalMergeSort(cfg)
-	err := msort.Sort(*inputFilepath, *outputFilepath, *tempDir)
 	if err != nil {
 		log.Fatalf("sort failed: %v\n", err)
 	}
+
+	if *indexEvery > 0 {
+		err = index.Build(cfg, *outputFilepath, *outputFilepath+index.Suffix, *indexEvery)
+		if err != nil {
+			log.Fatalf("failed to build the index: %v\n", err)
+		}
+	}
 }
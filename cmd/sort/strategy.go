@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/lodthe/external-merge-sort/pkg/algo"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+)
+
+var runSorters = map[string]config.RunSorter{
+	"comparison": algo.ComparisonSorter{},
+	"radix":      algo.RadixSorter{},
+}
+
+// newMerger returns the merger with the given name.
+// If fanIn is positive, it limits the number of runs merged at once.
+func newMerger(name string, fanIn int) (config.Merger, bool) {
+	switch strings.ToLower(name) {
+	case "2way":
+		return algo.TwoWayMerger{}, true
+
+	case "kway":
+		return algo.KWayMerger{K: fanIn}, true
+
+	case "polyphase":
+		if fanIn > 0 {
+			return algo.PolyphaseMerger{Files: fanIn + 1}, true
+		}
+
+		return algo.PolyphaseMerger{}, true
+
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/algo"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/lodthe/external-merge-sort/pkg/memory"
+	"github.com/pkg/errors"
+)
+
+const (
+	statusQueued   = "queued"
+	statusRunning  = "running"
+	statusDone     = "done"
+	statusFailed   = "failed"
+	statusCanceled = "canceled"
+)
+
+// jobRequest describes a sort job. Zero values are replaced with the server defaults.
+// Input and Output are paths relative to the files directory of the daemon.
+type jobRequest struct {
+	Input       string `json:"input"`
+	Output      string `json:"output"`
+	BlockSize   int    `json:"blocksize"`
+	Memory      int    `json:"memory"`
+	Delimiter   string `json:"delimiter"`
+	Order       string `json:"order"`
+	Merger      string `json:"merger"`
+	FrontCoding bool   `json:"front_coding"`
+	Adaptive    bool   `json:"adaptive"`
+}
+
+// config validates the request and converts it to the sort configuration.
+func (r jobRequest) config() (*config.Config, error) {
+	if r.BlockSize <= 0 {
+		return nil, errors.Errorf("blocksize must be positive, but %d was given", r.BlockSize)
+	}
+
+	if r.Memory/r.BlockSize < 3 {
+		return nil, errors.New("'memory' must be at least three times larger than 'blocksize'")
+	}
+
+	delim, err := config.ParseDelimiter(r.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+
+	ord, err := config.ParseOrder(r.Order)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config.Config{
+		BlockSize:   r.BlockSize,
+		MemoryLimit: r.Memory,
+		Delimiter:   delim,
+		Less:        ord.Less(),
+		Order:       ord,
+		FrontCoding: r.FrontCoding,
+		Adaptive:    r.Adaptive,
+	}
+
+	switch strings.ToLower(r.Merger) {
+	case "2way":
+		cfg.Merger = algo.TwoWayMerger{}
+
+	case "kway":
+		cfg.Merger = algo.KWayMerger{}
+
+	case "polyphase":
+		cfg.Merger = algo.PolyphaseMerger{}
+
+	default:
+		return nil, errors.Errorf("unknown merger %s", r.Merger)
+	}
+
+	return cfg, nil
+}
+
+type job struct {
+	id  string
+	req jobRequest
+	cfg *config.Config
+
+	// inputPath and outputPath are resolved paths of the input and the output.
+	inputPath  string
+	outputPath string
+
+	// uploaded is true if the input has been uploaded with the request and must be removed later.
+	uploaded bool
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	sorter   *algo.ExternalMergeSort
+	progress *algo.Progress
+
+	mu         sync.Mutex
+	status     string
+	err        error
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// jobView is the JSON representation of a job.
+type jobView struct {
+	ID         string      `json:"id"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	Input      string      `json:"input"`
+	Output     string      `json:"output"`
+	Memory     int         `json:"memory"`
+	Progress   float64     `json:"progress"`
+	Stats      *algo.Stats `json:"stats,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+}
+
+func (j *job) view() jobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	v := jobView{
+		ID:        j.id,
+		Status:    j.status,
+		Input:     j.req.Input,
+		Output:    j.req.Output,
+		Memory:    j.req.Memory,
+		CreatedAt: j.createdAt,
+	}
+
+	if j.err != nil {
+		v.Error = j.err.Error()
+	}
+
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		v.StartedAt = &startedAt
+
+		stats := j.progress.Stats()
+		v.Stats = &stats
+		v.Progress = progress(stats)
+	}
+
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		v.FinishedAt = &finishedAt
+	}
+
+	if j.status == statusDone {
+		v.Progress = 1
+	}
+
+	return v
+}
+
+// progress estimates the done fraction of a sort: run generation is the first half, merging is the second one.
+func progress(s algo.Stats) float64 {
+	switch s.Phase {
+	case algo.PhaseRunGeneration:
+		if s.InputSize == 0 {
+			return 0
+		}
+
+		return 0.5 * float64(s.InputRead) / float64(s.InputSize)
+
+	case algo.PhaseMerge:
+		if s.Runs <= 1 || s.RunsLeft <= 1 {
+			return 1
+		}
+
+		return 0.5 + 0.5*float64(s.Runs-s.RunsLeft)/float64(s.Runs-1)
+
+	case algo.PhaseDone:
+		return 1
+
+	default:
+		return 0
+	}
+}
+
+func (j *job) setStatus(status string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status = status
+	j.err = err
+
+	switch status {
+	case statusRunning:
+		j.startedAt = time.Now()
+
+	case statusDone, statusFailed, statusCanceled:
+		j.finishedAt = time.Now()
+	}
+}
+
+func (j *job) getStatus() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.status
+}
+
+// manager runs jobs within the global memory and concurrency budget.
+// Jobs can read and write files only in the files directory, results of finished jobs are kept for retention.
+type manager struct {
+	dir       string
+	defaults  jobRequest
+	governor  *memory.Governor
+	slots     *slots
+	retention time.Duration
+
+	mu     sync.Mutex
+	jobs   map[string]*job
+	nextID int
+}
+
+func newManager(dir string, defaults jobRequest, governor *memory.Governor, s *slots, retention time.Duration) (*manager, error) {
+	for _, sub := range []string{"files", "uploads", "results", "tmp"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &manager{
+		dir:       dir,
+		defaults:  defaults,
+		governor:  governor,
+		slots:     s,
+		retention: retention,
+		jobs:      make(map[string]*job),
+	}, nil
+}
+
+// submit validates the request, stores the uploaded input (if any) and queues the job.
+func (m *manager) submit(req jobRequest, upload io.Reader) (*job, error) {
+	req = m.withDefaults(req)
+
+	cfg, err := req.config()
+	if err != nil {
+		return nil, err
+	}
+
+	if 3*req.BlockSize > m.governor.Limit() {
+		return nil, errors.Errorf("the job needs at least %d bytes of memory, but only %d are available", 3*req.BlockSize, m.governor.Limit())
+	}
+	cfg.Governor = m.governor
+
+	if upload == nil && req.Input == "" {
+		return nil, errors.New("input is not specified")
+	}
+
+	var inputPath, outputPath string
+	if upload == nil {
+		inputPath, err = m.resolve(req.Input)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid input")
+		}
+	}
+
+	if req.Output != "" {
+		outputPath, err = m.resolve(req.Output)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid output")
+		}
+	}
+
+	m.mu.Lock()
+	m.nextID++
+	id := fmt.Sprintf("%d", m.nextID)
+	m.mu.Unlock()
+
+	j := &job{
+		id:        id,
+		cfg:       cfg,
+		status:    statusQueued,
+		createdAt: time.Now(),
+		sorter:    algo.NewExternalMergeSort(cfg),
+		progress:  new(algo.Progress),
+	}
+	j.ctx, j.cancel = context.WithCancel(algo.WithProgress(context.Background(), j.progress))
+
+	if upload != nil {
+		inputPath = filepath.Join(m.dir, "uploads", id)
+		j.uploaded = true
+
+		err = saveUpload(inputPath, upload)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to save input")
+		}
+	}
+
+	if outputPath == "" {
+		outputPath = filepath.Join(m.dir, "results", id)
+	}
+
+	j.req = req
+	j.inputPath = inputPath
+	j.outputPath = outputPath
+
+	m.mu.Lock()
+	m.jobs[id] = j
+	m.mu.Unlock()
+
+	go m.run(j)
+
+	return j, nil
+}
+
+// resolve returns the path of a file in the files directory. Absolute paths and paths outside of it are rejected.
+func (m *manager) resolve(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", errors.Errorf("%s is an absolute path, paths are relative to the files directory", name)
+	}
+
+	clean := filepath.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("%s is outside of the files directory", name)
+	}
+
+	return filepath.Join(m.dir, "files", clean), nil
+}
+
+func (m *manager) withDefaults(req jobRequest) jobRequest {
+	if req.BlockSize == 0 {
+		req.BlockSize = m.defaults.BlockSize
+	}
+	if req.Memory == 0 {
+		req.Memory = m.defaults.Memory
+	}
+	if req.Delimiter == "" {
+		req.Delimiter = m.defaults.Delimiter
+	}
+	if req.Order == "" {
+		req.Order = m.defaults.Order
+	}
+	if req.Merger == "" {
+		req.Merger = m.defaults.Merger
+	}
+
+	return req
+}
+
+// run waits for a free slot and sorts. The sorter itself waits for memory of the shared governor.
+func (m *manager) run(j *job) {
+	defer func() {
+		if j.uploaded {
+			_ = os.Remove(j.inputPath)
+		}
+	}()
+
+	err := m.slots.acquire(j.ctx)
+	if err != nil {
+		j.setStatus(statusCanceled, nil)
+		return
+	}
+	defer m.slots.release()
+
+	j.setStatus(statusRunning, nil)
+	log.Printf("job %s started: %s -> %s\n", j.id, j.inputPath, j.outputPath)
+
+	err = j.sorter.SortContext(j.ctx, j.inputPath, j.outputPath, filepath.Join(m.dir, "tmp"))
+	switch {
+	case j.ctx.Err() != nil:
+		j.setStatus(statusCanceled, nil)
+		log.Printf("job %s canceled\n", j.id)
+
+	case err != nil:
+		j.setStatus(statusFailed, err)
+		log.Printf("job %s failed: %v\n", j.id, err)
+
+	default:
+		j.setStatus(statusDone, nil)
+		log.Printf("job %s finished\n", j.id)
+	}
+}
+
+func (m *manager) get(id string) (*job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, exists := m.jobs[id]
+
+	return j, exists
+}
+
+func (m *manager) list() []*job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]*job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].createdAt.Before(jobs[k].createdAt)
+	})
+
+	return jobs
+}
+
+// evict forgets jobs finished more than retention ago and removes their results.
+// Outputs written to the files directory are kept.
+func (m *manager) evict(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, j := range m.jobs {
+		j.mu.Lock()
+		expired := !j.finishedAt.IsZero() && now.Sub(j.finishedAt) > m.retention
+		j.mu.Unlock()
+
+		if !expired {
+			continue
+		}
+
+		if j.req.Output == "" {
+			err := os.Remove(j.outputPath)
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("failed to remove the result of job %s: %v\n", id, err)
+			}
+		}
+
+		delete(m.jobs, id)
+	}
+}
+
+// evictLoop calls evict every period.
+func (m *manager) evictLoop(period time.Duration) {
+	for now := range time.Tick(period) {
+		m.evict(now)
+	}
+}
+
+// cancelJob stops a queued or running job.
+func (m *manager) cancelJob(j *job) {
+	j.cancel()
+	m.slots.wake()
+}
+
+func saveUpload(path string, upload io.Reader) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, upload)
+	if err == nil {
+		err = file.Close()
+	} else {
+		_ = file.Close()
+	}
+
+	// A partial upload is removed.
+	if err != nil {
+		_ = os.Remove(path)
+	}
+
+	return err
+}
+
+// slots limits the number of concurrently running jobs.
+type slots struct {
+	total int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	used int
+}
+
+func newSlots(total int) *slots {
+	s := &slots{
+		total: total,
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	return s
+}
+
+// acquire waits until a slot is available.
+// It fails if ctx is canceled before that; call wake after canceling ctx.
+func (s *slots) acquire(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.used == s.total {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		s.cond.Wait()
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.used++
+
+	return nil
+}
+
+func (s *slots) release() {
+	s.mu.Lock()
+	s.used--
+	s.mu.Unlock()
+
+	s.cond.Broadcast()
+}
+
+// wake makes waiting jobs check whether they have been canceled.
+func (s *slots) wake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cond.Broadcast()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/memory"
+)
+
+func main() {
+	var listen = flag.String("listen", "127.0.0.1:8080", "HTTP address to accept requests on.")
+	var dir = flag.String("dir", "sortd", "Where uploaded inputs, results and temporary files are stored. Jobs read and write files in its files subdirectory.")
+	var memoryLimit = flag.Int("memory", 2*1024*1024*1024, "Memory shared by all running jobs.")
+	var concurrency = flag.Int("concurrency", 4, "How many jobs can run at the same time.")
+	var jobMemory = flag.Int("job-memory", 256*1024*1024, "Default memory of one job. A job gets less if the shared memory is in use.")
+	var blockSize = flag.Int("blocksize", 1024*1024, "Default size of one block (in bytes).")
+	var merger = flag.String("merger", "kway", "Default merger. Supported values: 2way, kway, polyphase.")
+	var retention = flag.Duration("retention", time.Hour, "How long finished jobs and their results are kept.")
+
+	flag.Parse()
+	log.SetFlags(log.LstdFlags)
+
+	if *concurrency <= 0 {
+		log.Fatalf("concurrency must be positive, but %d was given", *concurrency)
+	}
+
+	if *jobMemory > *memoryLimit {
+		log.Fatalf("job-memory must not exceed memory")
+	}
+
+	defaults := jobRequest{
+		BlockSize: *blockSize,
+		Memory:    *jobMemory,
+		Delimiter: "\n",
+		Order:     "ASC",
+		Merger:    *merger,
+	}
+
+	_, err := defaults.config()
+	if err != nil {
+		log.Fatalf("invalid defaults: %v\n", err)
+	}
+
+	if *retention <= 0 {
+		log.Fatalf("retention must be positive, but %v was given", *retention)
+	}
+
+	jobs, err := newManager(*dir, defaults, memory.NewGovernor(*memoryLimit), newSlots(*concurrency), *retention)
+	if err != nil {
+		log.Fatalf("failed to prepare directories: %v\n", err)
+	}
+
+	go jobs.evictLoop(time.Minute)
+
+	log.Printf("listening on %s\n", *listen)
+
+	err = http.ListenAndServe(*listen, newServer(jobs))
+	if err != nil {
+		log.Fatalf("server failed: %v\n", err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// server exposes the job API:
+//
+//	POST   /jobs             submit a job (JSON body or raw input with parameters in the query string);
+//	GET    /jobs             list jobs;
+//	GET    /jobs/{id}        job status, progress and stats;
+//	DELETE /jobs/{id}        cancel a job;
+//	GET    /jobs/{id}/result download the sorted file.
+type server struct {
+	jobs *manager
+}
+
+func newServer(jobs *manager) http.Handler {
+	s := &server{
+		jobs: jobs,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+
+	return mux
+}
+
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		jobs := s.jobs.list()
+		views := make([]jobView, 0, len(jobs))
+		for _, j := range jobs {
+			views = append(views, j.view())
+		}
+
+		writeJSON(w, http.StatusOK, views)
+
+	case http.MethodPost:
+		s.submit(w, r)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *server) submit(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	var upload io.Reader
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid job: "+err.Error())
+			return
+		}
+	} else {
+		var err error
+		req, err = requestFromQuery(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		upload = r.Body
+	}
+
+	j, err := s.jobs.submit(req, upload)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, j.view())
+}
+
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+
+	j, exists := s.jobs.get(parts[0])
+	if !exists {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, j.view())
+
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		s.jobs.cancelJob(j)
+		writeJSON(w, http.StatusOK, j.view())
+
+	case len(parts) == 2 && parts[1] == "result" && r.Method == http.MethodGet:
+		if j.getStatus() != statusDone {
+			writeError(w, http.StatusConflict, "job is not done")
+			return
+		}
+
+		http.ServeFile(w, r, j.outputPath)
+
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// requestFromQuery reads job parameters from the query string of an upload request.
+func requestFromQuery(q url.Values) (jobRequest, error) {
+	req := jobRequest{
+		Output:    q.Get("output"),
+		Delimiter: q.Get("delimiter"),
+		Order:     q.Get("order"),
+		Merger:    q.Get("merger"),
+	}
+
+	ints := map[string]*int{
+		"blocksize": &req.BlockSize,
+		"memory":    &req.Memory,
+	}
+	for name, value := range ints {
+		if q.Get(name) == "" {
+			continue
+		}
+
+		v, err := strconv.Atoi(q.Get(name))
+		if err != nil {
+			return req, err
+		}
+		*value = v
+	}
+
+	bools := map[string]*bool{
+		"front_coding": &req.FrontCoding,
+		"adaptive":     &req.Adaptive,
+	}
+	for name, value := range bools {
+		if q.Get(name) == "" {
+			continue
+		}
+
+		v, err := strconv.ParseBool(q.Get(name))
+		if err != nil {
+			return req, err
+		}
+		*value = v
+	}
+
+	return req, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("failed to write response: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{
+		"error": message,
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/memory"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testServer struct {
+	*httptest.Server
+
+	jobs *manager
+	dir  string
+}
+
+func newTestServer(t *testing.T, concurrency int) *testServer {
+	dir := t.TempDir()
+
+	defaults := jobRequest{
+		BlockSize: 4,
+		Memory:    64,
+		Delimiter: "\n",
+		Order:     "ASC",
+		Merger:    "kway",
+	}
+
+	jobs, err := newManager(dir, defaults, memory.NewGovernor(1024), newSlots(concurrency), time.Hour)
+	require.NoError(t, err)
+
+	s := httptest.NewServer(newServer(jobs))
+	t.Cleanup(s.Close)
+
+	return &testServer{
+		Server: s,
+		jobs:   jobs,
+		dir:    dir,
+	}
+}
+
+// do sends the request and decodes the JSON response into v, if it's not nil.
+func (s *testServer) do(t *testing.T, method, path, contentType, body string, v interface{}) int {
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if v != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	return resp.StatusCode
+}
+
+// wait polls the job until it's finished.
+func (s *testServer) wait(t *testing.T, id string) jobView {
+	for i := 0; i < 500; i++ {
+		var view jobView
+		require.Equal(t, http.StatusOK, s.do(t, http.MethodGet, "/jobs/"+id, "", "", &view))
+
+		if view.Status != statusQueued && view.Status != statusRunning {
+			return view
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s isn't finished", id)
+
+	return jobView{}
+}
+
+func (s *testServer) result(t *testing.T, id string) (int, string) {
+	resp, err := http.Get(s.URL + "/jobs/" + id + "/result")
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, string(body)
+}
+
+func TestServerUpload(t *testing.T) {
+	s := newTestServer(t, 2)
+
+	var view jobView
+	require.Equal(t, http.StatusCreated, s.do(t, http.MethodPost, "/jobs?order=desc", "text/plain", "b\nc\na\n", &view))
+	assert.Equal(t, "1", view.ID)
+
+	view = s.wait(t, view.ID)
+	assert.Equal(t, statusDone, view.Status)
+	assert.Equal(t, 1.0, view.Progress)
+
+	status, body := s.result(t, view.ID)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "c\nb\na\n", body)
+
+	// The uploaded input is removed.
+	uploads, err := ioutil.ReadDir(filepath.Join(s.dir, "uploads"))
+	require.NoError(t, err)
+	assert.Empty(t, uploads)
+
+	var views []jobView
+	require.Equal(t, http.StatusOK, s.do(t, http.MethodGet, "/jobs", "", "", &views))
+	assert.Len(t, views, 1)
+
+	// Finished jobs and their results are evicted after retention.
+	s.jobs.evict(time.Now().Add(2 * time.Hour))
+	assert.Equal(t, http.StatusNotFound, s.do(t, http.MethodGet, "/jobs/"+view.ID, "", "", nil))
+
+	results, err := ioutil.ReadDir(filepath.Join(s.dir, "results"))
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestServerFiles(t *testing.T) {
+	s := newTestServer(t, 2)
+	require.NoError(t, os.MkdirAll(filepath.Join(s.dir, "files", "in"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(s.dir, "files", "in", "input.txt"), []byte("b\na\n"), 0644))
+
+	for _, body := range []string{
+		`{"input": "/etc/passwd"}`,
+		`{"input": "../uploads/1"}`,
+		`{"input": "in/input.txt", "output": "../results/1"}`,
+		`{"input": "in/input.txt", "blocksize": -1}`,
+		`{}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, s.do(t, http.MethodPost, "/jobs", "application/json", body, nil), body)
+	}
+
+	// Rejected requests don't take IDs.
+	var view jobView
+	body := `{"input": "in/input.txt", "output": "in/output.txt"}`
+	require.Equal(t, http.StatusCreated, s.do(t, http.MethodPost, "/jobs", "application/json", body, &view))
+	assert.Equal(t, "1", view.ID)
+	assert.Equal(t, statusDone, s.wait(t, view.ID).Status)
+
+	output, err := ioutil.ReadFile(filepath.Join(s.dir, "files", "in", "output.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "a\nb\n", string(output))
+
+	status, result := s.result(t, view.ID)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "a\nb\n", result)
+
+	// A missing input fails the job.
+	body = `{"input": "missing.txt"}`
+	require.Equal(t, http.StatusCreated, s.do(t, http.MethodPost, "/jobs", "application/json", body, &view))
+
+	view = s.wait(t, view.ID)
+	assert.Equal(t, statusFailed, view.Status)
+	assert.NotEmpty(t, view.Error)
+}
+
+func TestServerCancel(t *testing.T) {
+	s := newTestServer(t, 1)
+
+	// The only slot is taken, so the job stays queued.
+	require.NoError(t, s.jobs.slots.acquire(context.Background()))
+
+	var view jobView
+	require.Equal(t, http.StatusCreated, s.do(t, http.MethodPost, "/jobs", "text/plain", "b\na\n", &view))
+	assert.Equal(t, statusQueued, view.Status)
+
+	status, _ := s.result(t, view.ID)
+	assert.Equal(t, http.StatusConflict, status)
+
+	require.Equal(t, http.StatusOK, s.do(t, http.MethodDelete, "/jobs/"+view.ID, "", "", nil))
+	assert.Equal(t, statusCanceled, s.wait(t, view.ID).Status)
+
+	s.jobs.slots.release()
+
+	assert.Equal(t, http.StatusNotFound, s.do(t, http.MethodGet, "/jobs/42", "", "", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, s.do(t, http.MethodPut, "/jobs", "", "", nil))
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestSaveUpload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload")
+
+	err := saveUpload(path, io.MultiReader(strings.NewReader("partial"), failingReader{}))
+	assert.Error(t, err)
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -4,45 +4,162 @@ import (
 	"bytes"
 	"flag"
 	"log"
-	"strings"
+	"regexp"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
 )
 
 func main() {
-	var delimiter = flag.String("delimiter", "\n", "A character used to separate tokens.")
+	var delimiter = flag.String("delimiter", "\n", "Bytes used to separate tokens. Escape sequences are supported, e.g. \\r\\n.")
+	var crlf = flag.String("crlf", "keep", "How \"\\r\" before the \"\\n\" delimiter is handled. Supported values: keep (a part of the token), normalize (removed), preserve (ignored while sorting, output lines must end with \"\\r\\n\").")
 	var order = flag.String("order", "ASC", "Sort order. Supported values: ASC, DESC.")
+	var framing = flag.String("framing", "delimiter", "How tokens are stored in both files. Supported values: delimiter, varint, fixed32, fixed, multiline (records of several lines, see record-start), csv (records with quoted fields, see columns).")
+	var recordStart = flag.String("record-start", "", "Regular expression matching the first line of a record for the multiline framing. If empty, records are paragraphs separated by blank lines. Records are compared by their first lines.")
+	var header = flag.Bool("header", false, "The first token of the input is a header (e.g. CSV column names). It isn't sorted and stays at the top of the output.")
+	var columns = flag.String("columns", "", "CSV columns the records are sorted by, e.g. price:number:desc,2. A column is a name from the header or a 1-based number, optionally followed by a type (string, number) and a direction (asc, desc). If empty, all fields are compared as strings.")
+	var csvComma = flag.String("csv-comma", ",", "CSV field separator, e.g. ; or \\t.")
+	var jsonKeys = flag.String("json-keys", "", "Sort JSON lines by the given paths, e.g. .user.id,.ts:desc. Values are compared by type first (null, false, true, numbers, strings, objects and arrays), then by value.")
+	var jsonMissing = flag.String("json-missing", "first", "Where records without a JSON key or with invalid JSON go. Supported values: first, last, error.")
+	var recordSize = flag.Int("record-size", 0, "Size of one record for the fixed framing.")
+	var keyOffset = flag.Int("key-offset", 0, "Offset of the key in a fixed-width record.")
+	var keyLength = flag.Int("key-length", 0, "Length of the key in a fixed-width record. If zero, the key lasts until the end of the record.")
+	var keyType = flag.String("key-type", "bytes", "How keys of fixed-width records are compared. Supported values: bytes, uint-be, uint-le, int-be, int-le, float-be, float-le.")
 	var inputFilepath = flag.String("input", "input.txt", "Input file path.")
 	var sortedFilepath = flag.String("output", "output.txt", "Output file path.")
 
 	flag.Parse()
 	log.SetFlags(0)
 
-	if len(*delimiter) != 1 {
-		log.Fatalf("only one character can be specified as delimiter, but %s was given", *delimiter)
+	delim, err := config.ParseDelimiter(*delimiter)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	crlfMode, err := config.ParseCRLF(*crlf)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ord, err := config.ParseOrder(*order)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	fr, err := config.ParseFraming(*framing)
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 
-	var less func(a, b []byte) bool
-	switch {
-	case strings.EqualFold(*order, "ASC"):
-		less = func(a, b []byte) bool {
-			return bytes.Compare(a, b) < 0
+	less := ord.Less()
+	if fr == config.FramingFixed {
+		if *recordSize <= 0 {
+			log.Fatalf("record-size must be positive for the fixed framing, but %d was given", *recordSize)
+		}
+
+		key, err := config.ParseKey(*keyOffset, *keyLength, *keyType, *recordSize)
+		if err != nil {
+			log.Fatalf("invalid key: %v", err)
 		}
 
-	case strings.EqualFold(*order, "DESC"):
-		less = func(a, b []byte) bool {
-			return bytes.Compare(a, b) > 0
+		less = key.Less(ord)
+	}
+
+	var startPattern *regexp.Regexp
+	if fr == config.FramingMultiline {
+		if *recordStart != "" {
+			startPattern, err = regexp.Compile(*recordStart)
+			if err != nil {
+				log.Fatalf("invalid record-start: %v", err)
+			}
 		}
 
-	default:
-		log.Fatalf("only ASC and DESC orders are supported, but %s was given", *order)
+		less = config.FirstLine(less)
 	}
 
-	delim := (*delimiter)[0]
-	inputTokenCount, inputHash, err := parseFile(*inputFilepath, nil, delim)
+	// CSV flags would be silently ignored with other framings.
+	if fr != config.FramingCSV {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "columns" || f.Name == "csv-comma" {
+				log.Fatalf("%s can be used with the csv framing only", f.Name)
+			}
+		})
+	}
+
+	var keyFunc config.KeyFunc
+	if fr == config.FramingCSV {
+		comma, err := config.ParseComma(*csvComma)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		cols, err := config.ParseColumns(*columns)
+		if err != nil {
+			log.Fatalf("invalid columns: %v", err)
+		}
+
+		var names []string
+		if *header {
+			first, err := (&config.Config{BlockSize: bufferSize, Framing: fr}).ReadHeader(*inputFilepath)
+			if err != nil {
+				log.Fatalf("failed to read the header: %v", err)
+			}
+
+			names, err = config.CSVFields(first, comma)
+			if err != nil {
+				log.Fatalf("failed to parse the header: %v", err)
+			}
+		}
+
+		err = config.ResolveColumns(cols, names)
+		if err != nil {
+			log.Fatalf("invalid columns: %v", err)
+		}
+
+		keyFunc = config.CSVKeyFunc(cols, comma, ord)
+	}
+
+	if *jsonKeys != "" {
+		if keyFunc != nil {
+			log.Fatalf("json-keys can't be used with the csv framing")
+		}
+
+		keys, err := config.ParseJSONKeys(*jsonKeys)
+		if err != nil {
+			log.Fatalf("invalid json-keys: %v", err)
+		}
+
+		missing, err := config.ParseMissingKey(*jsonMissing)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		if ord == config.OrderDESC {
+			for i := range keys {
+				keys[i].Desc = !keys[i].Desc
+			}
+		}
+
+		keyFunc = config.JSONKeyFunc(keys, missing)
+	}
+
+	cfg := &config.Config{
+		BlockSize:   bufferSize,
+		Delimiter:   delim,
+		CRLF:        crlfMode,
+		Framing:     fr,
+		RecordStart: startPattern,
+		Header:      *header,
+		KeyFunc:     keyFunc,
+		RecordSize:  *recordSize,
+		Less:        less,
+	}
+
+	inputTokenCount, inputHash, err := parseFile(*inputFilepath, false, cfg)
 	if err != nil {
 		log.Fatalf("parsing input file failed: %v\n", err)
 	}
 
-	sortedTokenCount, sortedHash, err := parseFile(*sortedFilepath, less, delim)
+	sortedTokenCount, sortedHash, err := parseFile(*sortedFilepath, true, cfg)
 	if err != nil {
 		log.Fatalf("parsing sorted file failed: %v\n", err)
 	}
@@ -55,5 +172,21 @@ func main() {
 		log.Fatalf("hash mismatch: input file has %d, sorted file has %d\n", inputHash, sortedHash)
 	}
 
+	if *header {
+		inputHeader, err := cfg.ReadHeader(*inputFilepath)
+		if err != nil {
+			log.Fatalf("failed to read the input header: %v\n", err)
+		}
+
+		sortedHeader, err := cfg.ReadHeader(*sortedFilepath)
+		if err != nil {
+			log.Fatalf("failed to read the sorted header: %v\n", err)
+		}
+
+		if !bytes.Equal(inputHeader, sortedHeader) {
+			log.Fatalf("header mismatch: input file has '%s', sorted file has '%s'\n", inputHeader, sortedHeader)
+		}
+	}
+
 	log.Println("OK")
 }
@@ -1,11 +1,13 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"io"
 	"log"
+	"math"
 	"os"
 
+	"github.com/lodthe/external-merge-sort/pkg/config"
 	"github.com/lodthe/external-merge-sort/pkg/hash"
 	"github.com/pkg/errors"
 )
@@ -13,8 +15,9 @@ import (
 const bufferSize = 128 * 1024
 
 // parseFile reads file content and counts tokens and their hash.
-// If less is provided, it also checks if less(str[i], str[i + 1]) is true for each i.
-func parseFile(filepath string, less func(a, b []byte) bool, delimiter byte) (tokenCount int64, multisetHash int64, err error) {
+// If checkOrder is set, it also checks that no token goes before the previous one by cfg.KeyFunc keys or cfg.Less.
+// Tokens are read according to cfg.Framing. If cfg.Header is set, the order of the first token isn't checked.
+func parseFile(filepath string, checkOrder bool, cfg *config.Config) (tokenCount int64, multisetHash int64, err error) {
 	file, err := os.Open(filepath)
 	if err != nil {
 		return 0, 0, errors.Wrap(err, "open failed")
@@ -23,50 +26,60 @@ func parseFile(filepath string, less func(a, b []byte) bool, delimiter byte) (to
 		_ = file.Close()
 	}()
 
-	var prevToken *[]byte
+	var prevToken, prevKey, key []byte
 	hasher := hash.NewMultiset(17, 1e9+7)
 
 	handleToken := func(token []byte) error {
 		tokenCount++
 		hasher.Add(hash.Polynomial(token))
 
-		if less == nil {
+		if !checkOrder || (cfg.Header && tokenCount == 1) {
 			return nil
 		}
 
-		if prevToken != nil {
-			if less(token, *prevToken) {
-				log.Printf("wrong order: '%s' goes before '%s'\n", *prevToken, token)
-				return errors.New("wrong order")
+		// Keys are extracted once per token, so the key of the previous token is kept.
+		if cfg.KeyFunc != nil {
+			var err error
+			key, err = cfg.KeyFunc(key[:0], token)
+			if err != nil {
+				return errors.Wrapf(err, "failed to extract the key of '%s'", token)
 			}
 		}
 
-		prevToken = &token
+		var wrong bool
+		switch {
+		case prevToken == nil:
+
+		case cfg.KeyFunc != nil:
+			wrong = bytes.Compare(key, prevKey) < 0
+
+		default:
+			wrong = cfg.Less(token, prevToken)
+		}
+
+		if wrong {
+			log.Printf("wrong order: '%s' goes before '%s'\n", prevToken, token)
+			return errors.New("wrong order")
+		}
+
+		prevToken = token
+		prevKey, key = key, prevKey
 
 		return nil
 	}
 
-	reader := bufio.NewReaderSize(file, bufferSize)
+	reader := cfg.NewReader(file, 0, math.MaxInt64)
+	defer reader.Release()
 
 	for {
-		token, err := reader.ReadBytes(delimiter)
+		token, err := reader.Next()
 		if errors.Is(err, io.EOF) {
-			// Handle \n before EOF.
-			if len(token) == 0 {
-				break
-			}
-
-			err = nil
+			break
 		}
 		if err != nil {
 			return 0, 0, errors.Wrap(err, "read failed")
 		}
 
-		// Remove delimiter if it's written at the end.
-		if len(token) > 0 && token[len(token)-1] == delimiter {
-			token = token[:len(token)-1]
-		}
-
 		err = handleToken(token)
 		if err != nil {
 			return 0, 0, errors.Wrap(err, "handle failed")
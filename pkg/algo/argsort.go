@@ -0,0 +1,159 @@
+package algo
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// Index defines how Argsort refers to input tokens.
+type Index int
+
+const (
+	// IndexOrdinal is the zero-based number of the token in the input. The header isn't counted.
+	IndexOrdinal Index = iota
+
+	// IndexOffset is the file offset of the token in the input.
+	IndexOffset
+)
+
+// ParseIndex converts ordinal and offset (case-insensitive) to the corresponding index.
+func ParseIndex(s string) (Index, error) {
+	switch strings.ToLower(s) {
+	case "ordinal":
+		return IndexOrdinal, nil
+
+	case "offset":
+		return IndexOffset, nil
+
+	default:
+		return IndexOrdinal, errors.Errorf("unknown index %s", s)
+	}
+}
+
+// IndexFormat defines how Argsort writes indices.
+type IndexFormat int
+
+const (
+	// IndexText writes one decimal number per line.
+	IndexText IndexFormat = iota
+
+	// IndexBinary writes little-endian uint64 numbers one after another.
+	IndexBinary
+)
+
+// ParseIndexFormat converts text and binary (case-insensitive) to the corresponding format.
+func ParseIndexFormat(s string) (IndexFormat, error) {
+	switch strings.ToLower(s) {
+	case "text":
+		return IndexText, nil
+
+	case "binary":
+		return IndexBinary, nil
+
+	default:
+		return IndexText, errors.Errorf("unknown index format %s", s)
+	}
+}
+
+// Argsort is like Sort, but instead of the sorted tokens, it writes the permutation:
+// for each position of the sorted order, the index of the token in the input.
+func (s *TagSort) Argsort(ctx context.Context, inputPath, outputPath, tempDir string, index Index, format IndexFormat) error {
+	startedAt := time.Now()
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open input file")
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+
+	sortedPath, _, err := s.sortTags(ctx, input, tempDir)
+	defer func() {
+		_ = os.Remove(sortedPath)
+	}()
+	if err != nil {
+		return err
+	}
+
+	err = s.writeIndices(sortedPath, outputPath, index, format)
+	if err != nil {
+		return errors.Wrap(err, "failed to write indices")
+	}
+
+	log.Printf("argsort finished in %v\n", time.Since(startedAt))
+
+	return nil
+}
+
+// writeIndices writes indices of sorted tags to the output file.
+func (s *TagSort) writeIndices(tagsPath, outputPath string, index Index, format IndexFormat) error {
+	tags, err := os.Open(tagsPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tags.Close()
+	}()
+
+	output, err := os.OpenFile(outputPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = output.Close()
+	}()
+
+	r := buffer.NewRecordReader(tags, 0, MaxInt64, s.cfg.BlockSize, buffer.PrefixVarint)
+	defer r.Release()
+
+	w := bufio.NewWriterSize(output, s.cfg.BlockSize)
+
+	var number [8]byte
+	var scratch []byte
+	for {
+		tag, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read the next tag")
+		}
+
+		offset, _, ordinal := tagPosition(tag)
+
+		value := uint64(ordinal)
+		if index == IndexOffset {
+			value = uint64(offset)
+		}
+
+		if format == IndexBinary {
+			binary.LittleEndian.PutUint64(number[:], value)
+			scratch = append(scratch[:0], number[:]...)
+		} else {
+			scratch = append(strconv.AppendUint(scratch[:0], value, 10), '\n')
+		}
+
+		_, err = w.Write(scratch)
+		if err != nil {
+			return errors.Wrap(err, "write failed")
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return errors.Wrap(err, "flush failed")
+	}
+
+	return output.Sync()
+}
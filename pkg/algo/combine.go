@@ -0,0 +1,120 @@
+package algo
+
+import (
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// combine merges partial results of tokens with equal keys with cfg.Combiner.
+// Keys added by config.WithKey are kept.
+func (m *mergeSortJob) combine(dst, a, b []byte) ([]byte, error) {
+	if m.cfg.KeyFunc == nil {
+		return m.cfg.Combiner.Combine(dst, a, b)
+	}
+
+	key, tokenA := config.SplitKey(a)
+	_, tokenB := config.SplitKey(b)
+
+	combined, err := m.cfg.Combiner.Combine(nil, tokenA, tokenB)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.AppendKeyed(dst, key, combined), nil
+}
+
+// combineRun merges equal tokens of a sorted run.
+func (m *mergeSortJob) combineRun(tokens [][]byte) ([][]byte, error) {
+	if m.cfg.Combiner == nil {
+		return tokens, nil
+	}
+
+	n := 0
+	for _, token := range tokens {
+		if n > 0 && !m.cfg.Less(tokens[n-1], token) {
+			combined, err := m.combine(nil, tokens[n-1], token)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to combine tokens")
+			}
+
+			tokens[n-1] = combined
+			continue
+		}
+
+		tokens[n] = token
+		n++
+	}
+
+	return tokens[:n], nil
+}
+
+// combineWriter merges equal consecutive tokens of a sorted run before they are written.
+// The latest token is held back until a greater token comes or flushPending is called.
+type combineWriter struct {
+	buffer.TokenWriter
+
+	job     *mergeSortJob
+	pending []byte
+	scratch []byte
+	has     bool
+}
+
+func (w *combineWriter) Write(token []byte) error {
+	if w.has && !w.job.cfg.Less(w.pending, token) {
+		combined, err := w.job.combine(w.scratch[:0], w.pending, token)
+		if err != nil {
+			return errors.Wrap(err, "failed to combine tokens")
+		}
+
+		w.pending, w.scratch = combined, w.pending
+
+		return nil
+	}
+
+	err := w.flushPending()
+	if err != nil {
+		return err
+	}
+
+	w.pending = append(w.pending[:0], token...)
+	w.has = true
+
+	return nil
+}
+
+// flushPending writes the held back token.
+func (w *combineWriter) flushPending() error {
+	if !w.has {
+		return nil
+	}
+	w.has = false
+
+	return w.TokenWriter.Write(w.pending)
+}
+
+// finishWriter converts final results of cfg.Combiner to output tokens.
+type finishWriter struct {
+	buffer.TokenWriter
+
+	finish  func(dst, result []byte) ([]byte, error)
+	scratch []byte
+}
+
+func (w *finishWriter) Write(result []byte) error {
+	var err error
+	w.scratch, err = w.finish(w.scratch[:0], result)
+	if err != nil {
+		return errors.Wrap(err, "failed to finish the result")
+	}
+
+	return w.TokenWriter.Write(w.scratch)
+}
+
+func (w *finishWriter) Reset() {
+	startRun(w.TokenWriter)
+}
+
+func (w *finishWriter) Release() {
+	release(w.TokenWriter)
+}
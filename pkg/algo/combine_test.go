@@ -0,0 +1,153 @@
+package algo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// groupInput returns lines "key<TAB>value" with several values for each of seven keys.
+func groupInput() (input string, values map[string][]int) {
+	values = make(map[string][]int)
+	for i := 0; i < 120; i++ {
+		key := fmt.Sprintf("k%d", (i*5)%7)
+		value := (i * 37) % 101
+		values[key] = append(values[key], value)
+		input += fmt.Sprintf("%s\t%d\n", key, value)
+	}
+
+	return input, values
+}
+
+// groupOutput formats aggregates of groups in the key order.
+func groupOutput(values map[string][]int, aggregate func(values []int) int) string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var output string
+	for _, key := range keys {
+		output += fmt.Sprintf("%s\t%d\n", key, aggregate(values[key]))
+	}
+
+	return output
+}
+
+func TestMergeSortCombiner(t *testing.T) {
+	input, values := groupInput()
+
+	aggregates := map[string]func(values []int) int{
+		"count": func(values []int) int {
+			return len(values)
+		},
+		"sum:2": func(values []int) int {
+			sum := 0
+			for _, v := range values {
+				sum += v
+			}
+			return sum
+		},
+		"max:2:number": func(values []int) int {
+			max := values[0]
+			for _, v := range values {
+				if v > max {
+					max = v
+				}
+			}
+			return max
+		},
+		"first:2": func(values []int) int {
+			return values[0]
+		},
+		"last:2": func(values []int) int {
+			return values[len(values)-1]
+		},
+	}
+
+	strategies := []func(cfg *config.Config){
+		func(cfg *config.Config) {},
+		func(cfg *config.Config) {
+			cfg.Merger = KWayMerger{K: 3}
+		},
+		func(cfg *config.Config) {
+			cfg.Merger = PolyphaseMerger{Files: 3}
+		},
+		func(cfg *config.Config) {
+			cfg.FrontCoding = true
+			cfg.Adaptive = true
+		},
+	}
+
+	for spec, aggregate := range aggregates {
+		a, err := config.ParseAggregate(spec)
+		require.NoError(t, err)
+
+		for i, strategy := range strategies {
+			output := sortString(t, input, func(cfg *config.Config) {
+				cfg.MemoryLimit = 60
+				cfg.Combiner, cfg.KeyFunc = config.GroupBy(a, []int{0}, []byte("\t"), config.OrderASC)
+				strategy(cfg)
+			})
+			assert.Equal(t, groupOutput(values, aggregate), output, "aggregate %s, strategy %d", spec, i)
+		}
+	}
+}
+
+func TestMergeSortCombinerCustom(t *testing.T) {
+	// Distinct lines with a header, like uniq.
+	output := sortString(t, "name\nb\na\nb\nc\na\nb\n", func(cfg *config.Config) {
+		cfg.Header = true
+		cfg.Combiner = &config.Combiner{
+			Combine: func(dst, a, b []byte) ([]byte, error) {
+				return append(dst, a...), nil
+			},
+		}
+	})
+	assert.Equal(t, "name\na\nb\nc\n", output)
+
+	// The whole line is the key of count, the order is descending.
+	a, err := config.ParseAggregate("count")
+	require.NoError(t, err)
+
+	output = sortString(t, "x y\nz\nx y\n", func(cfg *config.Config) {
+		cfg.Combiner, cfg.KeyFunc = config.GroupBy(a, nil, []byte(" "), config.OrderDESC)
+	})
+	assert.Equal(t, "z 1\nx y 2\n", output)
+}
+
+func TestSampleSortCombiner(t *testing.T) {
+	input, values := groupInput()
+
+	a, err := config.ParseAggregate("count")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	cfg := &config.Config{
+		BlockSize:   2,
+		MemoryLimit: 90,
+		Delimiter:   []byte("\n"),
+	}
+	cfg.Combiner, cfg.KeyFunc = config.GroupBy(a, []int{0}, []byte("\t"), config.OrderASC)
+
+	inputPath := filepath.Join(dir, "input")
+	require.NoError(t, ioutil.WriteFile(inputPath, []byte(input), 0644))
+
+	outputPath := filepath.Join(dir, "output")
+	require.NoError(t, NewSampleSort(cfg, 3).Sort(inputPath, outputPath, dir))
+
+	output, err := ioutil.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, groupOutput(values, func(values []int) int {
+		return len(values)
+	}), string(output))
+	assert.Equal(t, len(values), strings.Count(string(output), "\n"))
+}
@@ -0,0 +1,495 @@
+package algo
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// Frequency counts occurrences of distinct tokens and writes "count<sep>token" tokens ordered by count
+// (descending, equal counts by token), like sort | uniq -c | sort -rn.
+//
+// Tokens are counted in a hash table. When it doesn't fit in MemoryLimit, partial counts are sorted by token and
+// spilled to disk as runs, which are merged by external merge sort with counts of equal tokens added up.
+// If top is positive, only the top most frequent tokens are kept while counts are read, otherwise
+// all of them are sorted by count.
+type Frequency struct {
+	cfg *config.Config
+	top int
+	sep []byte
+}
+
+// countSize is the size of a count stored after the token in spilled partial counts.
+const countSize = 8
+
+func NewFrequency(cfg *config.Config, top int, sep []byte) *Frequency {
+	return &Frequency{
+		cfg: cfg,
+		top: top,
+		sep: sep,
+	}
+}
+
+// Count loads tokens from the input file, counts them and saves counts to the output file.
+func (f *Frequency) Count(inputPath, outputPath, tempDir string) error {
+	return f.CountContext(context.Background(), inputPath, outputPath, tempDir)
+}
+
+// CountContext is like Count, but counting is stopped with an error when ctx is canceled.
+// Use WithProgress to watch the stats of counting.
+func (f *Frequency) CountContext(ctx context.Context, inputPath, outputPath, tempDir string) error {
+	var sink countSink
+	if f.top > 0 {
+		sink = &topSink{f: f}
+	} else {
+		all, err := f.newAllSink(tempDir)
+		if err != nil {
+			return err
+		}
+		defer all.release()
+
+		sink = all
+	}
+
+	err := f.count(ctx, inputPath, tempDir, sink)
+	if err != nil {
+		return err
+	}
+
+	return sink.writeTo(ctx, outputPath, tempDir)
+}
+
+// count counts tokens of the input and passes counts of distinct tokens to the sink.
+func (f *Frequency) count(ctx context.Context, inputPath, tempDir string, sink countSink) error {
+	// Partial counts are merged by a job of its own config.
+	countCfg := &config.Config{
+		BlockSize:   f.cfg.BlockSize,
+		MemoryLimit: f.cfg.MemoryLimit,
+		Governor:    f.cfg.Governor,
+		Framing:     config.FramingVarint,
+		Less:        lessCounted,
+		Order:       config.OrderCustom,
+		Merger:      KWayMerger{},
+		Combiner: &config.Combiner{
+			Combine: addCounts,
+		},
+	}
+
+	job, done, err := NewExternalMergeSort(countCfg).start(ctx, tempDir)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	job.updateStats(func(s *Stats) {
+		*s = Stats{
+			Phase:     PhaseRunGeneration,
+			StartedAt: time.Now(),
+		}
+	})
+	defer job.updateStats(func(s *Stats) {
+		s.Phase = PhaseDone
+		s.Elapsed = time.Since(s.StartedAt)
+	})
+
+	input, err := job.createDescriptors(inputPath, tempDir)
+	if err != nil {
+		return errors.Wrap(err, "failed open basic files")
+	}
+	defer func() {
+		_ = input.Close()
+		removeFiles([]*os.File{job.input, job.output})
+	}()
+
+	counts, blocks, err := f.countRuns(job, input)
+	if err != nil {
+		return err
+	}
+
+	// Everything fits in memory.
+	if len(blocks) == 0 {
+		for token, count := range counts {
+			err = sink.add([]byte(token), count)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err = job.externalSort(blocks)
+	if err != nil {
+		return errors.Wrap(err, "failed to merge counts")
+	}
+
+	r := job.newRunReader(job.input, mergeSortBlock{start: 0, end: job.resultSize})
+	defer release(r)
+
+	for {
+		entry, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read the next count")
+		}
+
+		token, count := splitCounted(entry)
+		err = sink.add(token, count)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// countRuns counts tokens of the input in memory. When counts take more than a half of the memory limit,
+// they are sorted and written to job.input as a run. If there are runs, the latest counts are written too,
+// otherwise they are returned.
+func (f *Frequency) countRuns(job *mergeSortJob, input *os.File) (map[string]int64, []mergeSortBlock, error) {
+	log.Printf("counting started...\n")
+
+	startedAt := time.Now()
+	r := f.cfg.NewReader(input, 0, MaxInt64)
+	defer r.Release()
+	w := job.newRunWriter(job.input, false)
+	defer release(w)
+
+	info, err := input.Stat()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to stat input file")
+	}
+	job.updateStats(func(s *Stats) {
+		s.InputSize = info.Size()
+	})
+
+	counts := make(map[string]int64)
+	var usage int
+	var blocks []mergeSortBlock
+	var entry []byte
+
+	spill := func() error {
+		tokens := make([]string, 0, len(counts))
+		for token := range counts {
+			tokens = append(tokens, token)
+		}
+		sort.Strings(tokens)
+
+		startRun(w)
+		blocks = append(blocks, mergeSortBlock{
+			start: w.Offset(),
+		})
+
+		for _, token := range tokens {
+			entry = appendCounted(entry[:0], []byte(token), counts[token])
+
+			err := w.Write(entry)
+			if err != nil {
+				return errors.Wrap(err, "write failed")
+			}
+		}
+
+		blocks[len(blocks)-1].end = w.Offset()
+
+		counts = make(map[string]int64)
+		usage = 0
+
+		job.updateStats(func(s *Stats) {
+			s.InputRead = r.Offset()
+			s.Runs = len(blocks)
+		})
+
+		return nil
+	}
+
+	var tokenCount int64
+	for {
+		token, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to read the next token")
+		}
+
+		tokenCount++
+		if tokenCount%contextCheckPeriod == 0 {
+			err = job.ctx.Err()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		count, exists := counts[string(token)]
+		if !exists {
+			usage += len(token) + groupEntryOverhead
+		}
+		counts[string(token)] = count + 1
+
+		if usage >= job.cfg.MemoryLimit/2 {
+			err = spill()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if len(blocks) > 0 && len(counts) > 0 {
+		err := spill()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "final flush failed")
+	}
+
+	job.resultSize = w.Offset()
+	job.updateStats(func(s *Stats) {
+		s.Phase = PhaseMerge
+		s.InputRead = s.InputSize
+		s.Tokens = tokenCount
+		s.Runs = len(blocks)
+		s.RunsLeft = len(blocks)
+		s.BytesWritten += w.Offset()
+	})
+
+	log.Printf("counting finished in %v (%d tokens, %d runs)\n\n", time.Since(startedAt), tokenCount, len(blocks))
+
+	return counts, blocks, nil
+}
+
+// appendCounted appends the token followed by its count to dst.
+func appendCounted(dst, token []byte, count int64) []byte {
+	var b [countSize]byte
+	binary.BigEndian.PutUint64(b[:], uint64(count))
+
+	return append(append(dst, token...), b[:]...)
+}
+
+// splitCounted divides an entry written by appendCounted into the token and the count.
+func splitCounted(entry []byte) (token []byte, count int64) {
+	i := len(entry) - countSize
+
+	return entry[:i], int64(binary.BigEndian.Uint64(entry[i:]))
+}
+
+// lessCounted compares entries written by appendCounted by their tokens.
+func lessCounted(a, b []byte) bool {
+	return bytes.Compare(a[:len(a)-countSize], b[:len(b)-countSize]) < 0
+}
+
+// addCounts merges entries of the same token.
+func addCounts(dst, a, b []byte) ([]byte, error) {
+	if len(a) < countSize || len(b) < countSize {
+		return nil, errors.Errorf("invalid counts of %d and %d bytes", len(a), len(b))
+	}
+
+	token, x := splitCounted(a)
+	_, y := splitCounted(b)
+
+	return appendCounted(dst, token, x+y), nil
+}
+
+// appendFrequency appends the output token "count<sep>token" to dst.
+func (f *Frequency) appendFrequency(dst, token []byte, count int64) []byte {
+	dst = strconv.AppendInt(dst, count, 10)
+	dst = append(dst, f.sep...)
+
+	return append(dst, token...)
+}
+
+// countSink receives counts of distinct tokens and writes them in the output order.
+type countSink interface {
+	add(token []byte, count int64) error
+	writeTo(ctx context.Context, outputPath, tempDir string) error
+}
+
+// topSink keeps the most frequent tokens in a heap. The least frequent of them is on the top.
+type topSink struct {
+	f     *Frequency
+	items []frequencyItem
+}
+
+type frequencyItem struct {
+	token []byte
+	count int64
+}
+
+// Less reports whether the i-th item goes after the j-th one in the output.
+func (s *topSink) Less(i, j int) bool {
+	return worse(s.items[i], s.items[j].token, s.items[j].count)
+}
+
+// worse reports whether the item goes after the token with the count in the output.
+func worse(item frequencyItem, token []byte, count int64) bool {
+	if item.count != count {
+		return item.count < count
+	}
+
+	return bytes.Compare(item.token, token) > 0
+}
+
+func (s *topSink) Len() int {
+	return len(s.items)
+}
+
+func (s *topSink) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+}
+
+func (s *topSink) Push(x interface{}) {
+	s.items = append(s.items, x.(frequencyItem))
+}
+
+func (s *topSink) Pop() interface{} {
+	item := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+
+	return item
+}
+
+func (s *topSink) add(token []byte, count int64) error {
+	if len(s.items) < s.f.top {
+		heap.Push(s, frequencyItem{
+			token: append([]byte(nil), token...),
+			count: count,
+		})
+
+		return nil
+	}
+
+	if !worse(s.items[0], token, count) {
+		return nil
+	}
+
+	s.items[0].token = append(s.items[0].token[:0], token...)
+	s.items[0].count = count
+	heap.Fix(s, 0)
+
+	return nil
+}
+
+func (s *topSink) writeTo(_ context.Context, outputPath, tempDir string) error {
+	items := make([]frequencyItem, len(s.items))
+	for i := len(items) - 1; i >= 0; i-- {
+		items[i] = heap.Pop(s).(frequencyItem)
+	}
+
+	output, err := os.CreateTemp(tempDir, tempPattern)
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp file")
+	}
+
+	w := s.f.cfg.NewWriter(output, 0)
+	defer release(w)
+
+	var token []byte
+	for _, item := range items {
+		token = s.f.appendFrequency(token[:0], item.token, item.count)
+
+		err = w.Write(token)
+		if err != nil {
+			removeFiles([]*os.File{output})
+			return errors.Wrap(err, "write failed")
+		}
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = output.Close()
+	}
+	if err == nil {
+		err = os.Rename(output.Name(), outputPath)
+	}
+	if err != nil {
+		removeFiles([]*os.File{output})
+		return errors.Wrap(err, "failed to write the output")
+	}
+
+	return nil
+}
+
+// allSink writes counts of all tokens to a temp file, which is sorted by count then.
+type allSink struct {
+	f     *Frequency
+	file  *os.File
+	w     buffer.TokenWriter
+	token []byte
+}
+
+func (f *Frequency) newAllSink(tempDir string) (*allSink, error) {
+	file, err := os.CreateTemp(tempDir, tempPattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temp file")
+	}
+
+	return &allSink{
+		f:    f,
+		file: file,
+		w:    f.cfg.NewTempWriter(file, 0),
+	}, nil
+}
+
+func (s *allSink) add(token []byte, count int64) error {
+	s.token = s.f.appendFrequency(s.token[:0], token, count)
+
+	return s.w.Write(s.token)
+}
+
+func (s *allSink) writeTo(ctx context.Context, outputPath, tempDir string) error {
+	err := s.w.Flush()
+	if err != nil {
+		return errors.Wrap(err, "flush failed")
+	}
+
+	// Counts go first, so they are found before the separator even if tokens contain it.
+	sep := s.f.sep
+	cfg := *s.f.cfg
+	cfg.Header = false
+	cfg.Combiner = nil
+	cfg.KeyFunc = func(dst, token []byte) ([]byte, error) {
+		i := bytes.Index(token, sep)
+		if i < 0 {
+			return nil, errors.Errorf("no count in %q", token)
+		}
+
+		count, err := strconv.ParseInt(string(token[:i]), 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid count in %q", token)
+		}
+
+		var b [countSize]byte
+		binary.BigEndian.PutUint64(b[:], MaxUint64-uint64(count))
+
+		return append(append(dst, b[:]...), token[i+len(sep):]...), nil
+	}
+
+	// The stats of counting are kept.
+	ctx = WithProgress(ctx, new(Progress))
+
+	err = NewExternalMergeSort(&cfg).SortContext(ctx, s.file.Name(), outputPath, tempDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to sort counts")
+	}
+
+	return nil
+}
+
+func (s *allSink) release() {
+	release(s.w)
+	removeFiles([]*os.File{s.file})
+}
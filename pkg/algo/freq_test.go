@@ -0,0 +1,109 @@
+package algo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrequency(t *testing.T) {
+	counts := make(map[string]int)
+	var input string
+	for i := 0; i < 600; i++ {
+		word := fmt.Sprintf("w%d", (i*i+3*i)%97%41)
+		counts[word]++
+		input += word + "\n"
+	}
+
+	var words []string
+	for word := range counts {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+
+	expected := func(top int) string {
+		var output string
+		for i, word := range words {
+			if top > 0 && i == top {
+				break
+			}
+			output += fmt.Sprintf("%d %s\n", counts[word], word)
+		}
+		return output
+	}
+
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "input")
+	require.NoError(t, ioutil.WriteFile(inputPath, []byte(input), 0644))
+
+	for _, memoryLimit := range []int{40, 400, 1 << 20} {
+		for _, top := range []int{0, 1, 5, 100} {
+			cfg := &config.Config{
+				BlockSize:   4,
+				MemoryLimit: memoryLimit,
+				Delimiter:   []byte("\n"),
+				Less:        config.LessASC,
+				Order:       config.OrderASC,
+			}
+
+			outputPath := filepath.Join(dir, "output")
+			require.NoError(t, NewFrequency(cfg, top, []byte(" ")).Count(inputPath, outputPath, dir))
+
+			output, err := ioutil.ReadFile(outputPath)
+			require.NoError(t, err)
+			assert.Equal(t, expected(top), string(output), "memory %d, top %d", memoryLimit, top)
+
+			// Temp files are removed.
+			files, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Len(t, files, 2)
+		}
+	}
+}
+
+func TestFrequencyCRLF(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "input")
+	require.NoError(t, ioutil.WriteFile(inputPath, []byte("a\r\nb\r\r\na\nb\r\r\nb\r\n"), 0644))
+
+	cfg := &config.Config{
+		BlockSize:   4,
+		MemoryLimit: 40,
+		Delimiter:   []byte("\n"),
+		CRLF:        config.CRLFNormalize,
+		Less:        config.LessASC,
+		Order:       config.OrderASC,
+	}
+
+	outputPath := filepath.Join(dir, "output")
+	require.NoError(t, NewFrequency(cfg, 0, []byte(" ")).Count(inputPath, outputPath, dir))
+
+	output, err := ioutil.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, "2 a\n2 b\r\n1 b\n", string(output))
+}
+
+func TestAddCounts(t *testing.T) {
+	sum, err := addCounts(nil, appendCounted(nil, []byte("x"), 3), appendCounted(nil, []byte("x"), 4))
+	require.NoError(t, err)
+
+	token, count := splitCounted(sum)
+	assert.Equal(t, "x", string(token))
+	assert.Equal(t, int64(7), count)
+
+	assert.True(t, lessCounted(appendCounted(nil, []byte("a"), 9), appendCounted(nil, []byte("ab"), 1)))
+
+	_, err = addCounts(nil, []byte("x"), appendCounted(nil, []byte("x"), 1))
+	assert.Error(t, err)
+}
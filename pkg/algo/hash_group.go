@@ -0,0 +1,442 @@
+package algo
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+	"unsafe"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// HashGroupBy merges tokens with equal keys with config.Combiner like ExternalMergeSort does, but groups them
+// by hash partitioning instead of sorting. Groups are written in no particular order.
+//
+// Tokens are aggregated in a hash table. When the table doesn't fit in MemoryLimit, it's spilled to partitions
+// on disk by hashes of keys, and the rest of the input goes there too. Then each partition is grouped the same way,
+// with another hash function, so partitions are split again until they fit.
+// Keys are extracted by KeyFunc, the whole partial result is the key if it's nil.
+type HashGroupBy struct {
+	cfg *config.Config
+}
+
+// hashGroupJob is the state of one grouping.
+type hashGroupJob struct {
+	cfg      *config.Config
+	tempDir  string
+	ctx      context.Context
+	progress *Progress
+
+	output buffer.TokenWriter
+	tokens int64
+}
+
+// maxHashLevel limits the depth of partitioning. Groups of a partition at this level are aggregated in memory
+// whatever their size: hashes of all their keys collided at every level.
+const maxHashLevel = 16
+
+// maxHashPartitions limits the number of partitions written at once.
+const maxHashPartitions = 256
+
+// groupEntryOverhead approximates the memory used by the hash table for one group besides its key and result.
+const groupEntryOverhead = int(unsafe.Sizeof([]byte(nil))) + 48
+
+func NewHashGroupBy(cfg *config.Config) *HashGroupBy {
+	return &HashGroupBy{
+		cfg: cfg,
+	}
+}
+
+// Group loads tokens from the input file, merges tokens with equal keys and saves groups to the output file.
+func (g *HashGroupBy) Group(inputPath, outputPath, tempDir string) error {
+	return g.GroupContext(context.Background(), inputPath, outputPath, tempDir)
+}
+
+// GroupContext is like Group, but grouping is stopped with an error when ctx is canceled.
+// Use WithProgress to watch the stats.
+func (g *HashGroupBy) GroupContext(ctx context.Context, inputPath, outputPath, tempDir string) error {
+	if g.cfg.Combiner == nil || g.cfg.Combiner.Combine == nil {
+		return errors.New("hash group-by requires a combiner")
+	}
+
+	cfg := *g.cfg
+	job := &hashGroupJob{
+		cfg:      &cfg,
+		tempDir:  tempDir,
+		ctx:      ctx,
+		progress: progressFrom(ctx),
+	}
+
+	if cfg.Governor != nil {
+		job.progress.update(func(s *Stats) {
+			s.Phase = PhaseWaiting
+		})
+
+		granted, err := cfg.Governor.Acquire(ctx, 3*cfg.BlockSize, cfg.MemoryLimit)
+		if err != nil {
+			return errors.Wrap(err, "failed to acquire memory")
+		}
+		defer cfg.Governor.Release(granted)
+
+		cfg.MemoryLimit = granted
+	}
+
+	job.progress.update(func(s *Stats) {
+		*s = Stats{
+			Phase:     PhaseGrouping,
+			StartedAt: time.Now(),
+		}
+	})
+	defer job.progress.update(func(s *Stats) {
+		s.Phase = PhaseDone
+		s.Elapsed = time.Since(s.StartedAt)
+	})
+
+	return job.run(inputPath, outputPath)
+}
+
+func (j *hashGroupJob) run(inputPath, outputPath string) error {
+	startedAt := time.Now()
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open input file")
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+
+	info, err := input.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat input file")
+	}
+	j.progress.update(func(s *Stats) {
+		s.InputSize = info.Size()
+	})
+
+	// Groups are written to a temp file, so the output may replace the input.
+	output, err := os.CreateTemp(j.tempDir, tempPattern)
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp file")
+	}
+	defer func() {
+		if output != nil {
+			removeFiles([]*os.File{output})
+		}
+	}()
+
+	w := j.cfg.NewWriter(output, 0)
+	defer release(w)
+
+	j.output = w
+	if j.cfg.Combiner.Finish != nil {
+		j.output = &finishWriter{TokenWriter: w, finish: j.cfg.Combiner.Finish}
+	}
+
+	r := j.cfg.NewReader(input, 0, MaxInt64)
+	defer r.Release()
+
+	if j.cfg.Header {
+		header, err := r.Next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return errors.Wrap(err, "failed to read the header")
+		}
+
+		if err == nil {
+			err = w.Write(header)
+			if err != nil {
+				return errors.Wrap(err, "failed to write the header")
+			}
+		}
+	}
+
+	err = j.group(r, true, 0)
+	if err != nil {
+		return err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return errors.Wrap(err, "final flush failed")
+	}
+
+	err = output.Sync()
+	if err != nil {
+		return errors.Wrap(err, "failed to sync the output")
+	}
+
+	err = output.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to close the output")
+	}
+
+	err = os.Rename(output.Name(), outputPath)
+	if err != nil {
+		_ = os.Remove(output.Name())
+		output = nil
+		return errors.Wrap(err, "failed to rename the output")
+	}
+	output = nil
+
+	j.progress.update(func(s *Stats) {
+		s.BytesWritten += w.Offset()
+	})
+
+	log.Printf("%d tokens grouped in %v\n", j.tokens, time.Since(startedAt))
+
+	return nil
+}
+
+// group aggregates tokens of r and writes groups to the output. Tokens of the input are converted to partial
+// results first (prepare is set), partitions contain partial results. The hash function depends on the level.
+func (j *hashGroupJob) group(r buffer.SectionReader, prepare bool, level int) error {
+	table := newGroupTable(j.cfg)
+
+	var partitions []*os.File
+	var writers []buffer.TokenWriter
+	defer func() {
+		for _, w := range writers {
+			if w != nil {
+				release(w)
+			}
+		}
+		removeFiles(partitions)
+	}()
+
+	var key []byte
+	var count int64
+	for {
+		token, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read the next token")
+		}
+
+		count++
+		if count%contextCheckPeriod == 0 {
+			err = j.ctx.Err()
+			if err != nil {
+				return err
+			}
+
+			if prepare {
+				j.progress.update(func(s *Stats) {
+					s.InputRead = r.Offset()
+					s.Tokens = count
+				})
+			}
+		}
+
+		result := token
+		if prepare {
+			result, err = j.cfg.Prepare(token, r.TokenOffset())
+			if err != nil {
+				return errors.Wrap(err, "failed to prepare the token")
+			}
+		}
+
+		key, err = table.key(key[:0], result)
+		if err != nil {
+			return errors.Wrap(err, "failed to extract the key")
+		}
+
+		if writers != nil {
+			err = writers[partitionOf(key, level, len(writers))].Write(result)
+			if err != nil {
+				return errors.Wrap(err, "write failed")
+			}
+
+			continue
+		}
+
+		err = table.add(key, result)
+		if err != nil {
+			return errors.Wrap(err, "failed to combine tokens")
+		}
+
+		// A single group can't be split by partitioning.
+		if table.usage >= j.cfg.MemoryLimit/2 && len(table.results) > 1 && level < maxHashLevel {
+			partitions, writers, err = j.spill(table, level)
+			if err != nil {
+				return errors.Wrap(err, "failed to spill groups")
+			}
+		}
+	}
+
+	if prepare {
+		j.tokens = count
+		j.progress.update(func(s *Stats) {
+			s.InputRead = s.InputSize
+			s.Tokens = count
+		})
+	}
+
+	if writers == nil {
+		return table.writeTo(j.output)
+	}
+
+	// Buffers of writers are given back before partitions are read.
+	for i, w := range writers {
+		err := w.Flush()
+		if err != nil {
+			return errors.Wrap(err, "flush failed")
+		}
+
+		j.progress.update(func(s *Stats) {
+			s.Phase = PhasePartition
+			s.BytesWritten += w.Offset()
+		})
+
+		release(w)
+		writers[i] = nil
+	}
+
+	log.Printf("level %d: groups spilled to %d partitions\n", level, len(partitions))
+
+	for i, f := range partitions {
+		err := j.groupPartition(f, level+1)
+		if err != nil {
+			return errors.Wrapf(err, "failed to group partition %d", i)
+		}
+
+		removeFiles([]*os.File{f})
+		partitions[i] = nil
+	}
+
+	return nil
+}
+
+// groupPartition groups partial results stored in the partition file.
+func (j *hashGroupJob) groupPartition(f *os.File, level int) error {
+	r := buffer.NewRecordReader(f, 0, MaxInt64, j.cfg.BlockSize, buffer.PrefixVarint)
+	defer r.Release()
+
+	return j.group(r, false, level)
+}
+
+// spill creates partitions, writes groups of the table there and empties the table.
+// Partial results may contain the delimiter, so partitions store them as length-prefixed records.
+func (j *hashGroupJob) spill(table *groupTable, level int) ([]*os.File, []buffer.TokenWriter, error) {
+	// One block is left for the reader.
+	n := j.cfg.MemoryLimit/j.cfg.BlockSize - 1
+	if n < 2 {
+		n = 2
+	}
+	if n > maxHashPartitions {
+		n = maxHashPartitions
+	}
+
+	partitions := make([]*os.File, 0, n)
+	writers := make([]buffer.TokenWriter, 0, n)
+	for i := 0; i < n; i++ {
+		f, err := os.CreateTemp(j.tempDir, tempPattern)
+		if err != nil {
+			return partitions, writers, errors.Wrap(err, "failed to create temp file")
+		}
+
+		partitions = append(partitions, f)
+		writers = append(writers, buffer.NewRecordWriter(f, 0, j.cfg.BlockSize, buffer.PrefixVarint))
+	}
+
+	for i, key := range table.keys {
+		err := writers[partitionOf([]byte(key), level, n)].Write(table.results[i])
+		if err != nil {
+			return partitions, writers, errors.Wrap(err, "write failed")
+		}
+	}
+	table.reset()
+
+	return partitions, writers, nil
+}
+
+// partitionOf returns the partition of the key. Each level uses its own hash function: FNV-1a seeded with the level.
+func partitionOf(key []byte, level, n int) int {
+	const offsetBasis = 14695981039346656037
+	const prime = 1099511628211
+
+	h := uint64(offsetBasis) ^ uint64(level)
+	h *= prime
+	for _, b := range key {
+		h ^= uint64(b)
+		h *= prime
+	}
+
+	return int(h % uint64(n))
+}
+
+// groupTable aggregates partial results by keys in memory. Groups are kept in the order of their first tokens.
+type groupTable struct {
+	cfg *config.Config
+
+	index   map[string]int
+	keys    []string
+	results [][]byte
+	scratch []byte
+
+	// usage approximates the memory used by groups.
+	usage int
+}
+
+func newGroupTable(cfg *config.Config) *groupTable {
+	return &groupTable{
+		cfg:   cfg,
+		index: make(map[string]int),
+	}
+}
+
+// key appends the key of the partial result to dst.
+func (t *groupTable) key(dst, result []byte) ([]byte, error) {
+	if t.cfg.KeyFunc == nil {
+		return append(dst, result...), nil
+	}
+
+	return t.cfg.KeyFunc(dst, result)
+}
+
+// add merges the partial result into its group.
+func (t *groupTable) add(key, result []byte) error {
+	i, exists := t.index[string(key)]
+	if !exists {
+		t.index[string(key)] = len(t.results)
+		t.keys = append(t.keys, string(key))
+		t.results = append(t.results, append([]byte(nil), result...))
+		t.usage += 2*len(key) + len(result) + groupEntryOverhead
+
+		return nil
+	}
+
+	combined, err := t.cfg.Combiner.Combine(t.scratch[:0], t.results[i], result)
+	if err != nil {
+		return err
+	}
+
+	t.usage += len(combined) - len(t.results[i])
+	t.results[i], t.scratch = append(t.results[i][:0], combined...), combined
+
+	return nil
+}
+
+// writeTo writes results of all groups.
+func (t *groupTable) writeTo(w buffer.TokenWriter) error {
+	for _, result := range t.results {
+		err := w.Write(result)
+		if err != nil {
+			return errors.Wrap(err, "write failed")
+		}
+	}
+
+	return nil
+}
+
+func (t *groupTable) reset() {
+	t.index = make(map[string]int)
+	t.keys = nil
+	t.results = nil
+	t.usage = 0
+}
@@ -0,0 +1,182 @@
+package algo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// groupString groups the input with HashGroupBy and returns the output.
+func groupString(t *testing.T, input string, configure func(cfg *config.Config)) string {
+	output, err := runSorter(t, input, func(cfg *config.Config) {
+		cfg.BlockSize = 4
+		cfg.MemoryLimit = 60
+		configure(cfg)
+	}, func(cfg *config.Config) sorter {
+		return sorterFunc(func(inputPath, outputPath, tempDir string) error {
+			progress := new(Progress)
+			ctx := WithProgress(context.Background(), progress)
+
+			err := NewHashGroupBy(cfg).GroupContext(ctx, inputPath, outputPath, tempDir)
+			if err != nil {
+				return err
+			}
+
+			stats := progress.Stats()
+			assert.Equal(t, PhaseDone, stats.Phase)
+			assert.Equal(t, int64(len(input)), stats.InputRead)
+
+			// Temp files are removed.
+			files, err := ioutil.ReadDir(tempDir)
+			require.NoError(t, err)
+			assert.Len(t, files, 2)
+
+			return nil
+		})
+	})
+	require.NoError(t, err)
+
+	return output
+}
+
+// sortedLines returns lines of s in the ascending order.
+func sortedLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	sort.Strings(lines)
+
+	return lines
+}
+
+func TestHashGroupBy(t *testing.T) {
+	input, values := groupInput()
+
+	aggregates := map[string]func(values []int) int{
+		"count": func(values []int) int {
+			return len(values)
+		},
+		"min:2:number": func(values []int) int {
+			min := values[0]
+			for _, v := range values {
+				if v < min {
+					min = v
+				}
+			}
+			return min
+		},
+		"last:2": func(values []int) int {
+			return values[len(values)-1]
+		},
+	}
+
+	for spec, aggregate := range aggregates {
+		a, err := config.ParseAggregate(spec)
+		require.NoError(t, err)
+
+		for _, memoryLimit := range []int{60, 300, 1 << 20} {
+			output := groupString(t, input, func(cfg *config.Config) {
+				cfg.MemoryLimit = memoryLimit
+				cfg.Combiner, cfg.KeyFunc = config.GroupBy(a, []int{0}, []byte("\t"), config.OrderASC)
+			})
+
+			expected := sortedLines(groupOutput(values, aggregate))
+			assert.Equal(t, expected, sortedLines(output), "aggregate %s, memory %d", spec, memoryLimit)
+		}
+	}
+}
+
+func TestHashGroupByDeep(t *testing.T) {
+	// Few partitions and many keys make partitions split again and again.
+	values := make(map[string][]int)
+	var input string
+	for i := 0; i < 1500; i++ {
+		key := fmt.Sprintf("k%d", (i*7)%500)
+		values[key] = append(values[key], i)
+		input += fmt.Sprintf("%s\t%d\n", key, i)
+	}
+
+	a, err := config.ParseAggregate("sum:2")
+	require.NoError(t, err)
+
+	output := groupString(t, input, func(cfg *config.Config) {
+		cfg.BlockSize = 100
+		cfg.MemoryLimit = 400
+		cfg.Combiner, cfg.KeyFunc = config.GroupBy(a, []int{0}, []byte("\t"), config.OrderASC)
+	})
+
+	expected := sortedLines(groupOutput(values, func(values []int) int {
+		sum := 0
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	}))
+	assert.Equal(t, expected, sortedLines(output))
+}
+
+func TestHashGroupByDelimiterInResults(t *testing.T) {
+	counts := make(map[string]int)
+	var input string
+	for i := 0; i < 600; i++ {
+		key := fmt.Sprintf("k%d", (i*7)%60)
+		counts[key]++
+		input += fmt.Sprintf("%s\t%d\n", key, i)
+	}
+
+	var expected string
+	for key, count := range counts {
+		expected += fmt.Sprintf("%s\t%d\n", key, count)
+	}
+
+	// Partial results are keys followed by values on separate lines, so they contain the delimiter.
+	keyOf := func(result []byte) []byte {
+		return bytes.SplitN(result, []byte("\n"), 2)[0]
+	}
+
+	output := groupString(t, input, func(cfg *config.Config) {
+		cfg.BlockSize = 50
+		cfg.MemoryLimit = 200
+		cfg.KeyFunc = func(dst, result []byte) ([]byte, error) {
+			return append(dst, keyOf(result)...), nil
+		}
+		cfg.Combiner = &config.Combiner{
+			Prepare: func(dst, token []byte, _ int64) ([]byte, error) {
+				return append(dst, bytes.Replace(token, []byte("\t"), []byte("\n"), 1)...), nil
+			},
+			Combine: func(dst, a, b []byte) ([]byte, error) {
+				dst = append(dst, a...)
+				return append(dst, b[len(keyOf(b)):]...), nil
+			},
+			Finish: func(dst, result []byte) ([]byte, error) {
+				return append(dst, fmt.Sprintf("%s\t%d", keyOf(result), bytes.Count(result, []byte("\n")))...), nil
+			},
+		}
+	})
+	assert.Equal(t, sortedLines(expected), sortedLines(output))
+}
+
+func TestHashGroupByHeader(t *testing.T) {
+	// Without KeyFunc, whole tokens are keys.
+	output := groupString(t, "name\nb\na\nb\nc\na\nb\n", func(cfg *config.Config) {
+		cfg.Header = true
+		cfg.Combiner = &config.Combiner{
+			Combine: func(dst, a, b []byte) ([]byte, error) {
+				return append(dst, a...), nil
+			},
+		}
+	})
+	require.True(t, strings.HasPrefix(output, "name\n"))
+	assert.Equal(t, []string{"", "a\n", "b\n", "c\n"}, sortedLines(strings.TrimPrefix(output, "name\n")))
+}
+
+func TestHashGroupByNoCombiner(t *testing.T) {
+	err := NewHashGroupBy(&config.Config{}).Group("input", "output", t.TempDir())
+	assert.Error(t, err)
+}
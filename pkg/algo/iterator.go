@@ -0,0 +1,185 @@
+package algo
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// Iterator returns sorted tokens one by one. It's created by ExternalMergeSort.Iterate.
+//
+// The last merge pass is performed lazily by Next, so the sorted data is never written to an output file.
+// Iterator owns the temp files with runs and removes them on Close.
+type Iterator struct {
+	job     *mergeSortJob
+	done    func()
+	sources []buffer.TokenReader
+	heap    *mergeHeap
+
+	read   int64
+	closed bool
+}
+
+// Iterate sorts the input like SortContext, but stops before the final merge pass
+// and returns an iterator over the merged runs instead. Close must be called when the iterator isn't needed anymore.
+func (m *ExternalMergeSort) Iterate(ctx context.Context, inputPath, tempDir string) (*Iterator, error) {
+	if m.cfg.Combiner != nil {
+		return nil, errors.New("iterator doesn't support combiners")
+	}
+
+	job, done, err := m.start(ctx, tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &Iterator{
+		job:  job,
+		done: done,
+	}
+
+	err = it.prepare(inputPath)
+	if err != nil {
+		_ = it.Close()
+		return nil, err
+	}
+
+	return it, nil
+}
+
+// prepare generates runs and merges them until the remaining runs can be merged at once.
+func (it *Iterator) prepare(inputPath string) error {
+	m := it.job
+	m.updateStats(func(s *Stats) {
+		*s = Stats{
+			Phase:     PhaseRunGeneration,
+			StartedAt: time.Now(),
+		}
+	})
+
+	originInput, err := m.createDescriptors(inputPath, m.tempDir)
+	if err != nil {
+		return errors.Wrap(err, "failed open basic files")
+	}
+	defer func() {
+		_ = originInput.Close()
+	}()
+
+	info, err := originInput.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat input file")
+	}
+	m.updateStats(func(s *Stats) {
+		s.InputSize = info.Size()
+	})
+
+	blocks, err := m.mainMemorySort(originInput, 0, info.Size(), m.input)
+	if err != nil {
+		return errors.Wrap(err, "failed to sort blocks in RAM")
+	}
+
+	// Polyphase tapes aren't needed here: the runs are merged with balanced passes of the same fan-in.
+	merger := m.merger()
+	fanIn := merger.FanIn(m.cfg)
+
+	for passes := 1; len(blocks) > fanIn; passes++ {
+		blocks, err = m.mergePass(merger, blocks, fanIn, false)
+		if err != nil {
+			return errors.Wrapf(err, "iteration #%d failed", passes)
+		}
+
+		log.Printf("iteration #%d finished, %d blocks left\n", passes, len(blocks))
+	}
+
+	for _, block := range blocks {
+		it.sources = append(it.sources, m.newRunReader(m.input, block))
+	}
+
+	it.heap, err = newMergeHeap(it.sources, m.cfg.Less)
+	if err != nil {
+		return errors.Wrap(err, "failed to start the final merge")
+	}
+
+	return nil
+}
+
+// Header returns the first token of the input if config.Header is set.
+// It isn't returned by Next.
+func (it *Iterator) Header() []byte {
+	return it.job.header
+}
+
+// Next returns the next token in the sorted order.
+// If no tokens are left, (nil, io.EOF) is returned.
+func (it *Iterator) Next() ([]byte, error) {
+	if it.closed {
+		return nil, errors.New("iterator is closed")
+	}
+
+	if it.heap.Len() == 0 {
+		it.job.updateStats(func(s *Stats) {
+			s.Phase = PhaseDone
+			s.RunsLeft = 0
+			s.Elapsed = time.Since(s.StartedAt)
+		})
+
+		return nil, io.EOF
+	}
+
+	it.read++
+	if it.read%contextCheckPeriod == 0 {
+		err := it.job.ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token := it.heap.items[0].token
+	if it.job.cfg.KeyFunc != nil {
+		_, token = config.SplitKey(token)
+	}
+
+	err := it.heap.advance()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the next token")
+	}
+
+	return token, nil
+}
+
+// Close removes temp files and gives the memory back. It's safe to call Close several times.
+func (it *Iterator) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+
+	for _, source := range it.sources {
+		release(source)
+	}
+	defer it.done()
+
+	var result error
+	for _, f := range []*os.File{it.job.input, it.job.output} {
+		if f == nil {
+			continue
+		}
+
+		err := f.Close()
+		if err != nil {
+			result = err
+		}
+
+		err = os.Remove(f.Name())
+		if err != nil {
+			result = err
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,74 @@
+package algo
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// iteratorSorter writes tokens returned by Iterator to the output file, so it can be checked by checkSorter.
+type iteratorSorter struct {
+	t    *testing.T
+	sort *ExternalMergeSort
+}
+
+func (s iteratorSorter) Sort(inputPath, outputPath, _ string) error {
+	tempDir := s.t.TempDir()
+
+	it, err := s.sort.Iterate(context.Background(), inputPath, tempDir)
+	if err != nil {
+		return err
+	}
+
+	var output []byte
+	for {
+		token, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		output = append(append(output, token...), '\n')
+	}
+
+	require.NoError(s.t, it.Close())
+
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(s.t, err)
+	assert.Empty(s.t, entries, "temp files are removed")
+
+	return os.WriteFile(outputPath, output, 0644)
+}
+
+func TestIterator(t *testing.T) {
+	configs := []func(cfg *config.Config){
+		func(cfg *config.Config) {},
+		func(cfg *config.Config) {
+			cfg.Merger = KWayMerger{}
+			cfg.FrontCoding = true
+		},
+		func(cfg *config.Config) {
+			cfg.Merger = PolyphaseMerger{Files: 3}
+			cfg.Adaptive = true
+		},
+	}
+
+	for _, sample := range samples {
+		for _, configure := range configs {
+			checkSorter(t, sample, func(cfg *config.Config) sorter {
+				configure(cfg)
+				return iteratorSorter{
+					t:    t,
+					sort: NewExternalMergeSort(cfg),
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,531 @@
+package algo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// JoinType defines which records without a match are kept by SortMergeJoin.
+type JoinType int
+
+const (
+	// JoinInner keeps only pairs of matching records.
+	JoinInner JoinType = iota
+
+	// JoinLeft also keeps left records without a match.
+	JoinLeft
+
+	// JoinFull also keeps left and right records without a match.
+	JoinFull
+)
+
+// ParseJoinType converts inner, left and full (case-insensitive) to the corresponding join type.
+func ParseJoinType(s string) (JoinType, error) {
+	switch strings.ToLower(s) {
+	case "inner":
+		return JoinInner, nil
+
+	case "left":
+		return JoinLeft, nil
+
+	case "full":
+		return JoinFull, nil
+
+	default:
+		return JoinInner, errors.Errorf("unknown join type %s", s)
+	}
+}
+
+// JoinSide is an input of SortMergeJoin.
+type JoinSide struct {
+	Path string
+
+	// KeyFunc extracts the join key of a record. Keys of both sides are compared byte-wise.
+	KeyFunc config.KeyFunc
+}
+
+// SortMergeJoin joins two files on keys. Inputs that aren't sorted by their keys are sorted by ExternalMergeSort first,
+// then both inputs are merged in one pass. An output record is the left record and the right record joined
+// by the separator. A missing record is replaced with empty fields.
+//
+// Records of the left side with equal keys are streamed, and records of the right side with the same key
+// are kept in memory or, if they don't fit in cfg.MemoryLimit, in a temp file.
+type SortMergeJoin struct {
+	cfg       *config.Config
+	joinType  JoinType
+	separator []byte
+	split     func(record []byte) ([]string, error)
+}
+
+// NewSortMergeJoin creates a join of files stored according to cfg.
+// split divides a record into fields, it's used to count fields of missing records.
+func NewSortMergeJoin(cfg *config.Config, joinType JoinType, separator []byte, split func(record []byte) ([]string, error)) *SortMergeJoin {
+	return &SortMergeJoin{
+		cfg:       cfg,
+		joinType:  joinType,
+		separator: separator,
+		split:     split,
+	}
+}
+
+// Join joins the left and the right inputs and saves the result to the output file.
+// If cfg.Header is set, the output starts with the headers of both inputs joined.
+func (j *SortMergeJoin) Join(ctx context.Context, left, right JoinSide, outputPath, tempDir string) error {
+	startedAt := time.Now()
+
+	leftPath, err := j.sorted(ctx, left, tempDir)
+	if leftPath != left.Path {
+		defer func() {
+			_ = os.Remove(leftPath)
+		}()
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to sort the left input")
+	}
+
+	rightPath, err := j.sorted(ctx, right, tempDir)
+	if rightPath != right.Path {
+		defer func() {
+			_ = os.Remove(rightPath)
+		}()
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to sort the right input")
+	}
+
+	l, err := j.newCursor(leftPath, left.KeyFunc)
+	if err != nil {
+		return errors.Wrap(err, "failed to open the left input")
+	}
+	defer l.close()
+
+	r, err := j.newCursor(rightPath, right.KeyFunc)
+	if err != nil {
+		return errors.Wrap(err, "failed to open the right input")
+	}
+	defer r.close()
+
+	output, err := os.OpenFile(outputPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create output file")
+	}
+	defer func() {
+		_ = output.Close()
+	}()
+
+	w := &contextWriter{
+		TokenWriter: j.cfg.NewWriter(output, 0),
+		ctx:         ctx,
+	}
+	defer w.Release()
+
+	group := &joinGroup{
+		limit:     j.groupLimit(),
+		blockSize: j.cfg.BlockSize,
+		tempDir:   tempDir,
+	}
+	defer group.close()
+
+	err = j.merge(l, r, group, w)
+	if err != nil {
+		return err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return errors.Wrap(err, "flush failed")
+	}
+
+	err = output.Sync()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("join finished in %v\n", time.Since(startedAt))
+
+	return nil
+}
+
+// sorted returns the path of the side input sorted by its keys. If the input is already sorted, its path is returned.
+// Otherwise, the input is sorted to a temp file, and the caller must remove it.
+func (j *SortMergeJoin) sorted(ctx context.Context, side JoinSide, tempDir string) (string, error) {
+	sorted, err := j.isSorted(side)
+	if err != nil {
+		return side.Path, err
+	}
+	if sorted {
+		log.Printf("%s is already sorted\n", side.Path)
+		return side.Path, nil
+	}
+
+	f, err := os.CreateTemp(tempDir, tempPattern)
+	if err != nil {
+		return side.Path, errors.Wrap(err, "failed to create temp file")
+	}
+	_ = f.Close()
+
+	cfg := *j.cfg
+	cfg.KeyFunc = side.KeyFunc
+
+	return f.Name(), NewExternalMergeSort(&cfg).SortContext(ctx, side.Path, f.Name(), tempDir)
+}
+
+// isSorted checks whether records of the side input go in the order of their keys.
+func (j *SortMergeJoin) isSorted(side JoinSide) (bool, error) {
+	c, err := j.newCursor(side.Path, side.KeyFunc)
+	if err != nil {
+		return false, err
+	}
+	defer c.close()
+
+	var previous []byte
+	for !c.done {
+		if previous != nil && bytes.Compare(c.key, previous) < 0 {
+			return false, nil
+		}
+		previous = append(previous[:0], c.key...)
+
+		err = c.advance()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// groupLimit returns how many bytes of right records with equal keys are kept in memory.
+// Two readers and two writers need a block each.
+func (j *SortMergeJoin) groupLimit() int {
+	limit := j.cfg.MemoryLimit - 4*j.cfg.BlockSize
+	if limit < j.cfg.BlockSize {
+		return j.cfg.BlockSize
+	}
+
+	return limit
+}
+
+func (j *SortMergeJoin) merge(l, r *joinCursor, group *joinGroup, w buffer.TokenWriter) error {
+	var record []byte
+	emit := func(left, right []byte) error {
+		record = j.pair(record[:0], l, left, r, right)
+		return w.Write(record)
+	}
+
+	if j.cfg.Header && (l.header != nil || r.header != nil) {
+		err := emit(l.header, r.header)
+		if err != nil {
+			return errors.Wrap(err, "write failed")
+		}
+	}
+
+	for !l.done || !r.done {
+		c := 0
+		switch {
+		case l.done:
+			c = 1
+
+		case r.done:
+			c = -1
+
+		default:
+			c = bytes.Compare(l.key, r.key)
+		}
+
+		if c < 0 {
+			if j.joinType != JoinInner {
+				err := emit(l.record, nil)
+				if err != nil {
+					return errors.Wrap(err, "write failed")
+				}
+			}
+
+			err := l.advance()
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if c > 0 {
+			if j.joinType == JoinFull {
+				err := emit(nil, r.record)
+				if err != nil {
+					return errors.Wrap(err, "write failed")
+				}
+			}
+
+			err := r.advance()
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err := j.matchGroup(l, r, group, emit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchGroup joins all records with the current key: right records are collected to the group,
+// and each left record is paired with all of them.
+func (j *SortMergeJoin) matchGroup(l, r *joinCursor, group *joinGroup, emit func(left, right []byte) error) error {
+	key := append([]byte(nil), l.key...)
+
+	group.reset()
+
+	for !r.done && bytes.Equal(r.key, key) {
+		err := group.add(r.record)
+		if err != nil {
+			return errors.Wrap(err, "failed to store records with equal keys")
+		}
+
+		err = r.advance()
+		if err != nil {
+			return err
+		}
+	}
+
+	for !l.done && bytes.Equal(l.key, key) {
+		err := group.each(func(right []byte) error {
+			return emit(l.record, right)
+		})
+		if err != nil {
+			return err
+		}
+
+		err = l.advance()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pair appends the output record of the left and the right records to dst.
+// A nil record is replaced with as many empty fields as the cursor records have.
+func (j *SortMergeJoin) pair(dst []byte, l *joinCursor, left []byte, r *joinCursor, right []byte) []byte {
+	dst = j.appendRecord(dst, l, left)
+	dst = append(dst, j.separator...)
+
+	return j.appendRecord(dst, r, right)
+}
+
+func (j *SortMergeJoin) appendRecord(dst []byte, c *joinCursor, record []byte) []byte {
+	if record != nil {
+		return append(dst, record...)
+	}
+
+	for i := 1; i < c.width; i++ {
+		dst = append(dst, j.separator...)
+	}
+
+	return dst
+}
+
+// joinCursor reads records of a side input with their keys.
+type joinCursor struct {
+	file    *os.File
+	r       buffer.SectionReader
+	keyFunc config.KeyFunc
+
+	header []byte
+
+	// width is the number of fields of the header or the first record.
+	width int
+
+	// record and key are copies of the current record and its key.
+	record []byte
+	key    []byte
+	done   bool
+}
+
+func (j *SortMergeJoin) newCursor(path string, keyFunc config.KeyFunc) (*joinCursor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &joinCursor{
+		file:    f,
+		r:       j.cfg.NewReader(f, 0, MaxInt64),
+		keyFunc: keyFunc,
+	}
+
+	if j.cfg.Header {
+		header, err := c.r.Next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			c.close()
+			return nil, errors.Wrap(err, "failed to read the header")
+		}
+		if err == nil {
+			c.header = append([]byte(nil), header...)
+			c.width = j.width(c.header)
+		}
+	}
+
+	err = c.advance()
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+
+	if c.width == 0 && !c.done {
+		c.width = j.width(c.record)
+	}
+
+	return c, nil
+}
+
+func (j *SortMergeJoin) width(record []byte) int {
+	fields, err := j.split(record)
+	if err != nil {
+		return 1
+	}
+
+	return len(fields)
+}
+
+// advance moves to the next record and extracts its key.
+func (c *joinCursor) advance() error {
+	record, err := c.r.Next()
+	if errors.Is(err, io.EOF) {
+		c.done = true
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the next record of %s", c.file.Name())
+	}
+
+	c.record = append(c.record[:0], record...)
+
+	c.key, err = c.keyFunc(c.key[:0], c.record)
+	if err != nil {
+		return errors.Wrapf(err, "failed to extract the key of %s", c.file.Name())
+	}
+
+	return nil
+}
+
+func (c *joinCursor) close() {
+	c.r.Release()
+	_ = c.file.Close()
+}
+
+// joinGroup stores right records with equal keys. Records are kept in memory until they exceed the limit,
+// then all of them are moved to a temp file.
+type joinGroup struct {
+	limit     int
+	blockSize int
+	tempDir   string
+
+	records [][]byte
+	size    int
+
+	spill  *os.File
+	writer *buffer.RecordWriter
+}
+
+// reset removes all records.
+func (g *joinGroup) reset() {
+	g.records = g.records[:0]
+	g.size = 0
+
+	if g.spill == nil {
+		return
+	}
+
+	g.writer.Release()
+	g.writer = nil
+
+	removeFiles([]*os.File{g.spill})
+	g.spill = nil
+}
+
+func (g *joinGroup) add(record []byte) error {
+	if g.spill != nil {
+		return g.writer.Write(record)
+	}
+
+	g.records = append(g.records, append([]byte(nil), record...))
+	g.size += len(record)
+	if g.size <= g.limit {
+		return nil
+	}
+
+	var err error
+	g.spill, err = os.CreateTemp(g.tempDir, tempPattern)
+	if err != nil {
+		return err
+	}
+
+	g.writer = buffer.NewRecordWriter(g.spill, 0, g.blockSize, buffer.PrefixVarint)
+	for _, r := range g.records {
+		err = g.writer.Write(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	g.records = g.records[:0]
+	g.size = 0
+
+	return nil
+}
+
+// each calls fn for each record.
+func (g *joinGroup) each(fn func(record []byte) error) error {
+	if g.spill == nil {
+		for _, record := range g.records {
+			err := fn(record)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err := g.writer.Flush()
+	if err != nil {
+		return errors.Wrap(err, "flush failed")
+	}
+
+	r := buffer.NewRecordReader(g.spill, 0, MaxInt64, g.blockSize, buffer.PrefixVarint)
+	defer r.Release()
+
+	for {
+		record, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read a stored record")
+		}
+
+		err = fn(record)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (g *joinGroup) close() {
+	g.reset()
+}
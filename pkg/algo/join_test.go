@@ -0,0 +1,89 @@
+package algo
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func splitTabs(record []byte) ([]string, error) {
+	return strings.Split(string(record), "\t"), nil
+}
+
+func TestSortMergeJoin(t *testing.T) {
+	left := "3\tc\n1\ta\n2\tb1\n2\tb2\n5\te\n"
+	right := "2\tx1\n4\ty\n2\tx2\n1\tz\n"
+	key := config.ColumnKeyFunc([]config.Column{{Index: 0, Type: config.ColumnNumber}}, splitTabs)
+
+	for joinType, expected := range map[JoinType][]string{
+		JoinInner: {"1\ta\t1\tz", "2\tb1\t2\tx1", "2\tb1\t2\tx2", "2\tb2\t2\tx1", "2\tb2\t2\tx2"},
+		JoinLeft:  {"1\ta\t1\tz", "2\tb1\t2\tx1", "2\tb1\t2\tx2", "2\tb2\t2\tx1", "2\tb2\t2\tx2", "3\tc\t\t", "5\te\t\t"},
+		JoinFull:  {"1\ta\t1\tz", "2\tb1\t2\tx1", "2\tb1\t2\tx2", "2\tb2\t2\tx1", "2\tb2\t2\tx2", "3\tc\t\t", "\t\t4\ty", "5\te\t\t"},
+	} {
+		for _, memory := range []int{1024, 4} {
+			output := joinStrings(t, joinType, left, right, key, key, func(cfg *config.Config) {
+				cfg.MemoryLimit = memory
+			})
+
+			lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+			assert.ElementsMatch(t, expected, lines, "join type %d, memory %d", joinType, memory)
+			assert.Equal(t, len(expected), len(lines))
+		}
+	}
+}
+
+func TestSortMergeJoinCSV(t *testing.T) {
+	comma := func(record []byte) ([]string, error) {
+		return config.CSVFields(record, ',')
+	}
+
+	left := "id,name\n2,\"Smith, J\"\n1,Doe\n"
+	right := "city,user\nParis,1\nRome,3\n"
+
+	output := joinStrings(t, JoinFull, left, right,
+		config.ColumnKeyFunc([]config.Column{{Index: 0}}, comma),
+		config.ColumnKeyFunc([]config.Column{{Index: 1}}, comma),
+		func(cfg *config.Config) {
+			cfg.Framing = config.FramingCSV
+			cfg.Header = true
+		},
+	)
+	assert.Equal(t, "id,name,city,user\n1,Doe,Paris,1\n2,\"Smith, J\",,\n,,Rome,3\n", output)
+}
+
+func joinStrings(t *testing.T, joinType JoinType, left, right string, leftKey, rightKey config.KeyFunc, configure func(cfg *config.Config)) string {
+	output, err := runSorter(t, left, func(cfg *config.Config) {
+		cfg.BlockSize = 4
+		cfg.MemoryLimit = 1024
+		configure(cfg)
+	}, func(cfg *config.Config) sorter {
+		return sorterFunc(func(leftPath, outputPath, tempDir string) error {
+			rightPath := filepath.Join(tempDir, "right")
+			require.NoError(t, ioutil.WriteFile(rightPath, []byte(right), 0644))
+
+			separator := []byte("\t")
+			split := splitTabs
+			if cfg.Framing == config.FramingCSV {
+				separator = []byte(",")
+				split = func(record []byte) ([]string, error) {
+					return config.CSVFields(record, ',')
+				}
+			}
+
+			return NewSortMergeJoin(cfg, joinType, separator, split).Join(context.Background(),
+				JoinSide{Path: leftPath, KeyFunc: leftKey},
+				JoinSide{Path: rightPath, KeyFunc: rightKey},
+				outputPath, tempDir,
+			)
+		})
+	})
+	require.NoError(t, err)
+
+	return output
+}
@@ -1,10 +1,10 @@
 package algo
 
 import (
+	"context"
 	"io"
 	"log"
 	"os"
-	"sort"
 	"time"
 	"unsafe"
 
@@ -21,16 +21,35 @@ type mergeSortBlock struct {
 	end   int64
 }
 
-// ExternalMergeSort is an implementation of external merge sort algorithm with 2-way merge.
+// ExternalMergeSort is an implementation of external merge sort algorithm.
+// Runs are sorted by config.RunSorter and merged by config.Merger (2-way merge by default).
+//
+// ExternalMergeSort keeps no state between sorts, so one instance can be reused and can run several sorts concurrently.
+// Concurrent sorts should share a memory.Governor (see config.Config), otherwise each of them uses the whole MemoryLimit.
 type ExternalMergeSort struct {
+	cfg *config.Config
+}
+
+// mergeSortJob is the state of one sort.
+type mergeSortJob struct {
 	input  *os.File
 	output *os.File
 
-	cfg *config.Config
+	// cfg is a copy of the sorter config with the memory limit granted to this sort.
+	cfg      *config.Config
+	tempDir  string
+	ctx      context.Context
+	progress *Progress
+
+	// resultSize is the size of the sorted data stored in the latest written temp file.
+	resultSize int64
 
-	tokenCount int64
+	// header is the first token of the input if cfg.Header is set. It's written before sorted tokens by the final pass.
+	header []byte
 }
 
+const tempPattern = "external_merge_sort_*"
+
 func NewExternalMergeSort(cfg *config.Config) *ExternalMergeSort {
 	return &ExternalMergeSort{
 		cfg: cfg,
@@ -39,13 +58,122 @@ func NewExternalMergeSort(cfg *config.Config) *ExternalMergeSort {
 
 // Sort loads data from the input file, sorts it and saves result to the output file.
 func (m *ExternalMergeSort) Sort(inputPath, outputPath, tempDir string) error {
-	originInput, err := m.createDescriptors(inputPath, tempDir)
+	return m.SortContext(context.Background(), inputPath, outputPath, tempDir)
+}
+
+// SortContext is like Sort, but the sort is stopped with an error when ctx is canceled.
+// Use WithProgress to watch the sort stats.
+func (m *ExternalMergeSort) SortContext(ctx context.Context, inputPath, outputPath, tempDir string) error {
+	job, done, err := m.start(ctx, tempDir)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	// Tokens of a sorted input still have to be combined, and its line endings may have to be converted.
+	if job.cfg.Adaptive && job.cfg.Combiner == nil && !job.cfg.ConvertsLineEndings() && samePath(inputPath, outputPath) {
+		sorted, err := job.isSorted(inputPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to check the input order")
+		}
+
+		if sorted {
+			log.Printf("input is already sorted\n")
+			return nil
+		}
+	}
+
+	return job.sortRange(inputPath, 0, MaxInt64, outputPath)
+}
+
+// SortRange is like Sort, but only tokens stored in [start, end) bytes of the input file are sorted.
+// start must point to the beginning of a token, and end must point to the beginning of a token or to EOF.
+func (m *ExternalMergeSort) SortRange(inputPath string, start, end int64, outputPath, tempDir string) error {
+	job, done, err := m.start(context.Background(), tempDir)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	return job.sortRange(inputPath, start, end, outputPath)
+}
+
+// start creates the state of a new sort.
+// If the config has a governor, start waits for memory, and the returned function gives it back.
+func (m *ExternalMergeSort) start(ctx context.Context, tempDir string) (*mergeSortJob, func(), error) {
+	cfg := *m.cfg
+	if cfg.KeyFunc != nil {
+		cfg.Less, cfg.Order = config.LessKeyed, config.OrderCustom
+	}
+
+	job := &mergeSortJob{
+		cfg:      &cfg,
+		tempDir:  tempDir,
+		ctx:      ctx,
+		progress: progressFrom(ctx),
+	}
+
+	if cfg.Governor == nil {
+		return job, func() {}, nil
+	}
+
+	job.updateStats(func(s *Stats) {
+		s.Phase = PhaseWaiting
+	})
+
+	granted, err := cfg.Governor.Acquire(ctx, 3*cfg.BlockSize, cfg.MemoryLimit)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to acquire memory")
+	}
+	cfg.MemoryLimit = granted
+
+	return job, func() {
+		cfg.Governor.Release(granted)
+	}, nil
+}
+
+func (m *mergeSortJob) sortRange(inputPath string, start, end int64, outputPath string) (err error) {
+	m.updateStats(func(s *Stats) {
+		*s = Stats{
+			Phase:     PhaseRunGeneration,
+			StartedAt: time.Now(),
+		}
+	})
+	defer m.updateStats(func(s *Stats) {
+		s.Phase = PhaseDone
+		s.Elapsed = time.Since(s.StartedAt)
+	})
+
+	originInput, err := m.createDescriptors(inputPath, m.tempDir)
 	if err != nil {
 		return errors.Wrap(err, "failed open basic files")
 	}
-	defer m.finish(outputPath)
+	defer func() {
+		_ = originInput.Close()
+	}()
+
+	// The output is replaced only if the sort succeeds.
+	defer func() {
+		if err != nil {
+			removeFiles([]*os.File{m.input, m.output})
+			return
+		}
 
-	blocks, err := m.mainMemorySort(originInput, m.input)
+		err = m.finish(outputPath)
+	}()
+
+	info, err := originInput.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat input file")
+	}
+	if end > info.Size() {
+		end = info.Size()
+	}
+	m.updateStats(func(s *Stats) {
+		s.InputSize = end - start
+	})
+
+	blocks, err := m.mainMemorySort(originInput, start, end, m.input)
 	if err != nil {
 		return errors.Wrap(err, "failed to sort blocks in RAM")
 	}
@@ -58,11 +186,71 @@ func (m *ExternalMergeSort) Sort(inputPath, outputPath, tempDir string) error {
 	return nil
 }
 
-func (m *ExternalMergeSort) swapDescriptors() {
+// isSorted reads the file and checks whether its tokens are sorted.
+func (m *mergeSortJob) isSorted(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	r := m.cfg.NewReader(file, 0, MaxInt64)
+	defer r.Release()
+
+	if m.cfg.Header {
+		_, err = r.Next()
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	var prev []byte
+	for {
+		token, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		token, err = m.withKey(token)
+		if err != nil {
+			return false, err
+		}
+
+		if prev != nil && m.cfg.Less(token, prev) {
+			return false, nil
+		}
+		prev = token
+	}
+}
+
+// samePath reports whether both paths point to the same existing file.
+func samePath(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(infoA, infoB)
+}
+
+func (m *mergeSortJob) swapDescriptors() {
 	m.input, m.output = m.output, m.input
 }
 
-func (m *ExternalMergeSort) createDescriptors(inputPath string, tempDir string) (originInput *os.File, err error) {
+func (m *mergeSortJob) createDescriptors(inputPath string, tempDir string) (originInput *os.File, err error) {
 	originInput, err = os.Open(inputPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open input file")
@@ -83,8 +271,6 @@ func (m *ExternalMergeSort) createDescriptors(inputPath string, tempDir string)
 		}
 	}()
 
-	const tempPattern = "external_merge_sort_*"
-
 	m.input, err = os.CreateTemp(tempDir, tempPattern)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create temp file")
@@ -99,77 +285,114 @@ func (m *ExternalMergeSort) createDescriptors(inputPath string, tempDir string)
 }
 
 // finish closes files and renames temp output with the correct name.
-func (m *ExternalMergeSort) finish(outputPath string) {
+// If it fails, temp files are removed, and the output is left as is.
+func (m *mergeSortJob) finish(outputPath string) error {
 	// After the external merge sort process, the output is stored in m.input.
 	// So we swap descriptors here to make the code cleaner.
 	m.swapDescriptors()
+	removeFiles([]*os.File{m.input})
 
-	err := m.input.Close()
+	// The temp file may contain leftovers of previous passes after the sorted data.
+	err := m.output.Truncate(m.resultSize)
 	if err != nil {
-		log.Printf("failed to close temp input file: %v\n", err)
-	}
-
-	err = os.Remove(m.input.Name())
-	if err != nil {
-		log.Printf("failed to remove temp input file: %v\n", err)
+		removeFiles([]*os.File{m.output})
+		return errors.Wrap(err, "failed to truncate temp output file")
 	}
 
 	err = m.output.Sync()
 	if err != nil {
-		log.Printf("filaed to sync temp output file: %v\n", err)
+		removeFiles([]*os.File{m.output})
+		return errors.Wrap(err, "failed to sync temp output file")
 	}
 
 	err = m.output.Close()
 	if err != nil {
-		log.Printf("failed to close temp output file: %v\n", err)
+		removeFiles([]*os.File{m.output})
+		return errors.Wrap(err, "failed to close temp output file")
 	}
 
 	err = os.Rename(m.output.Name(), outputPath)
 	if err != nil {
-		log.Printf("failed to rename %s temp file to %s output file: %v\n", m.output.Name(), outputPath, err)
+		_ = os.Remove(m.output.Name())
+		return errors.Wrapf(err, "failed to rename %s temp file to %s output file", m.output.Name(), outputPath)
 	}
+
+	return nil
 }
 
 // mainMemorySort reads data into buffer of size M and sorts them in main memory.
-func (m *ExternalMergeSort) mainMemorySort(input, output *os.File) ([]mergeSortBlock, error) {
+// Only [start, end) bytes of the input are read.
+func (m *mergeSortJob) mainMemorySort(input *os.File, start, end int64, output *os.File) ([]mergeSortBlock, error) {
 	log.Printf("main memory sort started...\n")
 
 	startedAt := time.Now()
-	r := buffer.NewReader(input, 0, MaxInt64, m.cfg.BlockSize, m.cfg.Delimiter)
-	w := buffer.NewWriter(output, 0, m.cfg.BlockSize, m.cfg.Delimiter)
+	r := m.cfg.NewReader(input, start, end)
+	defer r.Release()
+	w := m.newRunWriter(output, false)
+	defer release(w)
+
+	if m.cfg.Header && start == 0 {
+		header, err := r.Next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, errors.Wrap(err, "failed to read the header")
+		}
+		m.header = header
+	}
+
+	sorter := m.runSorter()
 
-	var offset int64
 	var tokenCapacityTotal int
 	var blocks []mergeSortBlock
 	var tokens [][]byte
 
+	// The first and the last tokens of the latest run.
+	var runFirst, runLast []byte
+
+	// reverseChain is true while all runs are reversed portions of a reverse-sorted input.
+	reverseChain := m.cfg.Adaptive
+
 	// writeTokens sorts portion of tokens and writes them.
 	writeTokens := func() (err error) {
 		if len(tokens) == 0 {
 			return nil
 		}
 
-		// TODO: a parallel sort can be used here.
-		sort.Slice(tokens, func(i, j int) bool {
-			return m.cfg.Less(tokens[i], tokens[j])
-		})
+		reversed := m.orderRun(sorter, tokens)
+
+		tokens, err = m.combineRun(tokens)
+		if err != nil {
+			return err
+		}
+
+		first, last := tokens[0], tokens[len(tokens)-1]
+
+		reverseChain = reverseChain && reversed && (runFirst == nil || !m.cfg.Less(runFirst, last))
+
+		// A portion that doesn't break the order continues the previous run.
+		extend := m.cfg.Adaptive && !reverseChain && len(blocks) > 0 && !m.cfg.Less(first, runLast)
+
+		if !extend {
+			startRun(w)
+			blocks = append(blocks, mergeSortBlock{
+				start: w.Offset(),
+			})
+			runFirst = first
+		}
 
-		var writtenCnt int64
 		for _, t := range tokens {
 			err = w.Write(t)
 			if err != nil {
 				return err
 			}
-
-			// Length of token + delimiter.
-			writtenCnt += int64(len(t)) + 1
 		}
 
-		blocks = append(blocks, mergeSortBlock{
-			start: offset,
-			end:   offset + writtenCnt,
+		blocks[len(blocks)-1].end = w.Offset()
+		runLast = last
+
+		m.updateStats(func(s *Stats) {
+			s.InputRead = r.Offset() - start
+			s.Runs = len(blocks)
 		})
-		offset += writtenCnt
 
 		tokens = tokens[:0]
 		tokenCapacityTotal = 0
@@ -178,16 +401,34 @@ func (m *ExternalMergeSort) mainMemorySort(input, output *os.File) ([]mergeSortB
 	}
 
 	// Read a token while it exists. When current memory usage is too high, sort tokens and write them.
-	for !r.EOF() {
-		m.tokenCount++
+	var tokenCount int64
+	for {
 		token, err := r.Next()
 		if errors.Is(err, io.EOF) {
-			continue
+			break
 		}
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to read the next token")
 		}
 
+		tokenCount++
+		if tokenCount%contextCheckPeriod == 0 {
+			err = m.ctx.Err()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		token, err = m.cfg.Prepare(token, r.TokenOffset())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to prepare the token")
+		}
+
+		token, err = m.withKey(token)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to extract the key")
+		}
+
 		tokens = append(tokens, token)
 		tokenCapacityTotal += cap(token)
 
@@ -211,51 +452,131 @@ func (m *ExternalMergeSort) mainMemorySort(input, output *os.File) ([]mergeSortB
 		return nil, errors.Wrap(err, "final flush failed")
 	}
 
-	log.Printf("main memory sort finished in %v\n\n", time.Since(startedAt))
+	m.resultSize = w.Offset()
+	m.updateStats(func(s *Stats) {
+		s.Phase = PhaseMerge
+		s.InputRead = s.InputSize
+		s.Tokens = tokenCount
+		s.Runs = len(blocks)
+		s.RunsLeft = len(blocks)
+		s.BytesWritten += w.Offset()
+	})
+
+	log.Printf("main memory sort finished in %v (%d runs)\n\n", time.Since(startedAt), len(blocks))
+
+	// Runs of a reverse-sorted input are sorted in the reversed order, so there is nothing to merge.
+	if reverseChain && len(blocks) > 1 {
+		for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+			blocks[i], blocks[j] = blocks[j], blocks[i]
+		}
+
+		return m.concatRuns(blocks)
+	}
 
 	return blocks, nil
 }
 
-func (m *ExternalMergeSort) externalSort(blocks []mergeSortBlock) error {
-	startedAt := time.Now()
+// orderRun sorts tokens of one run and reports whether they have been reversed.
+// If cfg.Adaptive is set, sorted tokens are left as is, and reverse-sorted tokens are reversed.
+func (m *mergeSortJob) orderRun(sorter config.RunSorter, tokens [][]byte) (reversed bool) {
+	if m.cfg.Adaptive {
+		ascending, descending := true, true
+		for i := 1; i < len(tokens) && (ascending || descending); i++ {
+			if m.cfg.Less(tokens[i], tokens[i-1]) {
+				ascending = false
+			}
+			if m.cfg.Less(tokens[i-1], tokens[i]) {
+				descending = false
+			}
+		}
 
-	log.Printf("external sort started...\n")
+		// Equal tokens are treated as reverse-sorted, so they don't break a chain of reversed runs.
+		if descending {
+			reverse(tokens)
+			return true
+		}
 
-	var iterations int
-	for len(blocks) > 1 {
-		iterations++
+		if ascending {
+			return false
+		}
+	}
 
-		writer := buffer.NewWriter(m.output, 0, m.cfg.BlockSize, m.cfg.Delimiter)
-		newBlocks := make([]mergeSortBlock, 0, len(blocks)/2+1)
+	sorter.SortRun(tokens, m.cfg)
 
-		if len(blocks)%2 == 1 {
-			blocks = append(blocks, mergeSortBlock{
-				start: blocks[len(blocks)-1].end,
-				end:   blocks[len(blocks)-1].end,
-			})
-		}
+	return false
+}
 
-		for i := 0; i+1 < len(blocks); i += 2 {
-			err := m.merge(blocks[i], blocks[i+1], writer)
+// concatRuns writes blocks of m.input one after another as a single run.
+// It's used when every token of a block isn't less than tokens of the previous blocks.
+func (m *mergeSortJob) concatRuns(blocks []mergeSortBlock) ([]mergeSortBlock, error) {
+	startedAt := time.Now()
+
+	w := m.newRunWriter(m.output, false)
+	defer release(w)
+	startRun(w)
+
+	for _, block := range blocks {
+		r := m.newRunReader(m.input, block)
+		for {
+			token, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
 			if err != nil {
-				log.Printf("iteration #%d failed: %v\n", iterations, err)
-				return errors.Wrap(err, "merge failed")
+				return nil, errors.Wrap(err, "failed to read the next token")
 			}
 
-			newBlocks = append(newBlocks, mergeSortBlock{
-				start: blocks[i].start,
-				end:   blocks[i+1].end,
-			})
+			err = w.Write(token)
+			if err != nil {
+				return nil, errors.Wrap(err, "write failed")
+			}
 		}
+		release(r)
+	}
+
+	err := w.Flush()
+	if err != nil {
+		return nil, errors.Wrap(err, "flush failed")
+	}
+
+	m.resultSize = w.Offset()
+	m.swapDescriptors()
+	m.updateStats(func(s *Stats) {
+		s.Passes++
+		s.RunsLeft = 1
+		s.BytesWritten += m.resultSize
+	})
+
+	log.Printf("reversed runs concatenated in %v\n\n", time.Since(startedAt))
+
+	return []mergeSortBlock{{start: 0, end: m.resultSize}}, nil
+}
+
+func (m *mergeSortJob) externalSort(blocks []mergeSortBlock) error {
+	startedAt := time.Now()
 
-		err := writer.Flush()
+	merger := m.merger()
+	fanIn := merger.FanIn(m.cfg)
+
+	if polyphase, ok := merger.(PolyphaseMerger); ok && len(blocks) > 1 {
+		return m.polyphaseSort(polyphase, blocks)
+	}
+
+	log.Printf("external sort started (fan-in %d)...\n", fanIn)
+
+	// Runs in their own format must be converted to the output format, the header must be written
+	// before them, and results of a combiner must be finished, so at least one pass is required.
+	var iterations int
+	for len(blocks) > 1 || (iterations == 0 && m.needsPass()) {
+		iterations++
+
+		var err error
+		blocks, err = m.mergePass(merger, blocks, fanIn, len(blocks) <= fanIn)
 		if err != nil {
-			return errors.Wrap(err, "flush failed")
+			log.Printf("iteration #%d failed: %v\n", iterations, err)
+			return err
 		}
 
-		blocks = newBlocks
-		m.swapDescriptors()
-
 		log.Printf("iteration #%d finished, %d blocks left\n", iterations, len(blocks))
 	}
 
@@ -264,61 +585,300 @@ func (m *ExternalMergeSort) externalSort(blocks []mergeSortBlock) error {
 	return nil
 }
 
-// merge merges two blocks.
-// TODO: use K-way merge, it's much faster.
-func (m *ExternalMergeSort) merge(a, b mergeSortBlock, writer *buffer.Writer) error {
-	readerA := buffer.NewReader(m.input, a.start, a.end, m.cfg.BlockSize, m.cfg.Delimiter)
-	readerB := buffer.NewReader(m.input, b.start, b.end, m.cfg.BlockSize, m.cfg.Delimiter)
+// mergePass merges each fanIn blocks of m.input into one block of m.output and swaps the files.
+// The final pass writes tokens in the output format.
+func (m *mergeSortJob) mergePass(merger config.Merger, blocks []mergeSortBlock, fanIn int, final bool) ([]mergeSortBlock, error) {
+	writer := m.newRunWriter(m.output, final)
+	newBlocks := make([]mergeSortBlock, 0, len(blocks)/fanIn+1)
 
-	tokenA, err := readerA.Next()
-	if err != nil && err != io.EOF {
-		return err
-	}
+	for i := 0; i < len(blocks); i += fanIn {
+		end := i + fanIn
+		if end > len(blocks) {
+			end = len(blocks)
+		}
 
-	tokenB, err := readerB.Next()
-	if err != nil && err != io.EOF {
-		return err
-	}
+		sources := make([]buffer.TokenReader, 0, end-i)
+		for _, block := range blocks[i:end] {
+			sources = append(sources, m.newRunReader(m.input, block))
+		}
 
-	writeAndReadNext := func(token *[]byte, reader *buffer.Reader) error {
-		err = writer.Write(*token)
+		block, err := m.merge(merger, sources, writer)
 		if err != nil {
-			return errors.Wrap(err, "write failed")
+			release(writer)
+			return nil, errors.Wrap(err, "merge failed")
 		}
 
-		*token, err = reader.Next()
-		if err != nil && err != io.EOF {
-			return err
-		}
+		newBlocks = append(newBlocks, block)
+	}
 
-		return nil
+	err := writer.Flush()
+	release(writer)
+	if err != nil {
+		return nil, errors.Wrap(err, "flush failed")
 	}
 
-	for tokenA != nil && tokenB != nil {
-		if m.cfg.Less(tokenA, tokenB) {
-			err = writeAndReadNext(&tokenA, readerA)
-		} else {
-			err = writeAndReadNext(&tokenB, readerB)
+	m.resultSize = writer.Offset()
+	m.swapDescriptors()
+	m.updateStats(func(s *Stats) {
+		s.Passes++
+		s.RunsLeft = len(newBlocks)
+		s.BytesWritten += m.resultSize
+	})
+
+	return newBlocks, nil
+}
+
+// merge merges sorted sources into one block written by writer.
+// Equal tokens are combined if cfg.Combiner is set.
+func (m *mergeSortJob) merge(merger config.Merger, sources []buffer.TokenReader, writer buffer.TokenWriter) (mergeSortBlock, error) {
+	startRun(writer)
+	start := writer.Offset()
+
+	dst := writer
+	var combiner *combineWriter
+	if m.cfg.Combiner != nil {
+		combiner = &combineWriter{
+			TokenWriter: writer,
+			job:         m,
 		}
+		dst = combiner
+	}
 
-		if err != nil {
-			return err
+	err := merger.Merge(sources, dst, m.cfg.Less)
+	for _, source := range sources {
+		release(source)
+	}
+	if err == nil && combiner != nil {
+		err = combiner.flushPending()
+	}
+	if err != nil {
+		return mergeSortBlock{}, err
+	}
+
+	return mergeSortBlock{
+		start: start,
+		end:   writer.Offset(),
+	}, nil
+}
+
+func (m *mergeSortJob) runSorter() config.RunSorter {
+	if m.cfg.RunSorter != nil {
+		return m.cfg.RunSorter
+	}
+
+	return RadixSorter{}
+}
+
+func (m *mergeSortJob) merger() config.Merger {
+	if m.cfg.Merger != nil {
+		return m.cfg.Merger
+	}
+
+	return TwoWayMerger{}
+}
+
+// needsPass reports whether runs must be merged even if there is only one run.
+func (m *mergeSortJob) needsPass() bool {
+	return m.runFormat() != runFormatOutput || m.header != nil || m.cfg.Combiner != nil
+}
+
+// withKey prefixes the token with its key if cfg.KeyFunc is set.
+func (m *mergeSortJob) withKey(token []byte) ([]byte, error) {
+	if m.cfg.KeyFunc == nil {
+		return token, nil
+	}
+
+	return m.cfg.WithKey(token)
+}
+
+// runFormat defines how intermediate runs are stored.
+type runFormat int
+
+const (
+	// runFormatOutput means that runs are stored in the output format.
+	runFormatOutput runFormat = iota
+
+	// runFormatFrontCoded means that runs are prefix-compressed.
+	runFormatFrontCoded
+
+	// runFormatRecords means that runs are stored as varint length-prefixed records.
+	// Multiline records can't be stored in the output format, as a record without a start line
+	// would join the previous one when it's read back. Tokens with keys may contain any bytes.
+	runFormatRecords
+
+	// runFormatCRLF means that runs are stored as lines that end with "\r\n".
+	// Lines of the output don't keep "\r" with config.CRLFNormalize, so tokens that end with "\r"
+	// would be cut when a run is read back.
+	runFormatCRLF
+)
+
+func (m *mergeSortJob) runFormat() runFormat {
+	switch {
+	case m.cfg.FrontCoding:
+		return runFormatFrontCoded
+
+	case m.cfg.Framing == config.FramingMultiline || m.cfg.KeyFunc != nil:
+		return runFormatRecords
+
+	case m.cfg.ConvertsLineEndings() && m.cfg.CRLF == config.CRLFNormalize:
+		return runFormatCRLF
+
+	default:
+		return runFormatOutput
+	}
+}
+
+// newRunWriter creates a writer for intermediate runs.
+// The final pass always produces tokens in the output format (see config.Framing).
+// Writes fail when the sort context is canceled.
+func (m *mergeSortJob) newRunWriter(file *os.File, final bool) buffer.TokenWriter {
+	var w buffer.TokenWriter
+	switch format := m.runFormat(); {
+	case final:
+		w = m.cfg.NewWriter(file, 0)
+
+	case format == runFormatOutput:
+		w = m.cfg.NewWriter(file, 0)
+
+	case format == runFormatCRLF:
+		w = m.cfg.NewTempWriter(file, 0)
+
+	case format == runFormatFrontCoded:
+		w = buffer.NewFrontCodedWriter(file, 0, m.cfg.BlockSize)
+
+	default:
+		w = buffer.NewRecordWriter(file, 0, m.cfg.BlockSize, buffer.PrefixVarint)
+	}
+
+	// The header isn't a token: it has no key and isn't a partial result, so it bypasses the writers below.
+	output := w
+
+	if final && m.cfg.Combiner != nil && m.cfg.Combiner.Finish != nil {
+		w = &finishWriter{
+			TokenWriter: w,
+			finish:      m.cfg.Combiner.Finish,
 		}
 	}
 
-	for tokenA != nil {
-		err = writeAndReadNext(&tokenA, readerA)
-		if err != nil {
-			return err
+	if final && m.cfg.KeyFunc != nil {
+		w = keyStripWriter{
+			TokenWriter: w,
 		}
 	}
 
-	for tokenB != nil {
-		err = writeAndReadNext(&tokenB, readerB)
-		if err != nil {
-			return err
+	if final && m.header != nil {
+		w = &headerWriter{
+			TokenWriter: w,
+			output:      output,
+			header:      m.header,
 		}
 	}
 
-	return nil
+	return &contextWriter{
+		TokenWriter: w,
+		ctx:         m.ctx,
+	}
+}
+
+// newRunReader creates a reader of an intermediate run written by newRunWriter.
+func (m *mergeSortJob) newRunReader(file *os.File, block mergeSortBlock) buffer.TokenReader {
+	switch m.runFormat() {
+	case runFormatFrontCoded:
+		return buffer.NewFrontCodedReader(file, block.start, block.end, m.cfg.BlockSize)
+
+	case runFormatRecords:
+		return buffer.NewRecordReader(file, block.start, block.end, m.cfg.BlockSize, buffer.PrefixVarint)
+
+	default:
+		return m.cfg.NewReader(file, block.start, block.end)
+	}
+}
+
+// resetter is implemented by writers that keep state between tokens of one run.
+type resetter interface {
+	Reset()
+}
+
+// startRun tells w that the following tokens belong to a new run.
+func startRun(w buffer.TokenWriter) {
+	if r, ok := w.(resetter); ok {
+		r.Reset()
+	}
+}
+
+// releaser is implemented by readers and writers that take block buffers from a pool.
+type releaser interface {
+	Release()
+}
+
+// release gives the block buffer of a reader or a writer back. It must not be used after that.
+func release(v interface{}) {
+	if r, ok := v.(releaser); ok {
+		r.Release()
+	}
+}
+
+// headerWriter writes the header before the first token.
+// The header is written to output, so writers between headerWriter and output don't change it.
+type headerWriter struct {
+	buffer.TokenWriter
+
+	output  buffer.TokenWriter
+	header  []byte
+	written bool
+}
+
+func (w *headerWriter) Write(token []byte) error {
+	err := w.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	return w.TokenWriter.Write(token)
+}
+
+// Flush writes the header even if there are no tokens.
+func (w *headerWriter) Flush() error {
+	err := w.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	return w.TokenWriter.Flush()
+}
+
+func (w *headerWriter) writeHeader() error {
+	if w.written {
+		return nil
+	}
+	w.written = true
+
+	return w.output.Write(w.header)
+}
+
+func (w *headerWriter) Reset() {
+	startRun(w.TokenWriter)
+}
+
+func (w *headerWriter) Release() {
+	release(w.TokenWriter)
+}
+
+// keyStripWriter removes keys added by config.WithKey before tokens are written.
+type keyStripWriter struct {
+	buffer.TokenWriter
+}
+
+func (w keyStripWriter) Write(record []byte) error {
+	_, token := config.SplitKey(record)
+
+	return w.TokenWriter.Write(token)
+}
+
+func (w keyStripWriter) Reset() {
+	startRun(w.TokenWriter)
+}
+
+func (w keyStripWriter) Release() {
+	release(w.TokenWriter)
 }
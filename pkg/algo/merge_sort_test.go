@@ -2,18 +2,25 @@ package algo
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/lodthe/external-merge-sort/pkg/memory"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestMergeSort(t *testing.T) {
-	samples := []string{
+var samples = []string{
 `
 F68C3A0DA6
 DCD4BFDDA2
@@ -33,42 +40,516 @@ C1CD093AA9
 2488570953
 7396157502`,
 ``,
+}
+
+func TestMergeSort(t *testing.T) {
+	for _, sample := range samples {
+		checkSample(t, sample, nil)
 	}
+}
 
+func TestMergeSortStrategies(t *testing.T) {
 	for _, sample := range samples {
-		checkSample(t, sample)
+		checkSample(t, sample, func(cfg *config.Config) {
+			cfg.RunSorter = ComparisonSorter{}
+			cfg.Merger = KWayMerger{K: 3}
+		})
+
+		checkSample(t, sample, func(cfg *config.Config) {
+			cfg.Order = config.OrderASC
+			cfg.RunSorter = RadixSorter{}
+			cfg.Merger = KWayMerger{}
+		})
+
+		checkSample(t, sample, func(cfg *config.Config) {
+			cfg.Merger = PolyphaseMerger{Files: 3}
+		})
+
+		checkSample(t, sample, func(cfg *config.Config) {
+			cfg.FrontCoding = true
+			cfg.Merger = PolyphaseMerger{Files: 4}
+		})
 	}
 }
 
-func checkSample(t *testing.T, sample string) {
-	file, err := os.CreateTemp("", "test_merge_sort_")
-	assert.Nil(t, err, "create input file")
-	defer func() {
-		_ = os.Remove(file.Name())
-	}()
+func TestMergeSortFrontCoding(t *testing.T) {
+	samples := []string{
+`
+https://example.com/b
+https://example.com/a/2
+https://example.com/a/10
+https://example.com/a
+https://example.org
+https://example.com/a/1
+https://example.com/a/1
+h`,
+`single`,
+``,
+	}
 
-	err = ioutil.WriteFile(file.Name(), []byte(sample), 0644)
-	_ = file.Close()
-	assert.Nil(t, err, "write input")
+	for _, sample := range samples {
+		checkSample(t, sample, func(cfg *config.Config) {
+			cfg.FrontCoding = true
+		})
+	}
+}
+
+func TestMergeSortAdaptive(t *testing.T) {
+	adaptiveSamples := append([]string{
+`a
+b
+c
+c
+d
+e
+f
+g`,
+`g
+f
+e
+e
+d
+c
+b
+a`,
+`a
+b
+c
+z
+y
+x
+d
+e`,
+	}, samples...)
+
+	for _, sample := range adaptiveSamples {
+		checkSample(t, sample, func(cfg *config.Config) {
+			cfg.Adaptive = true
+		})
+
+		checkSample(t, sample, func(cfg *config.Config) {
+			cfg.Adaptive = true
+			cfg.FrontCoding = true
+			cfg.MemoryLimit = 100
+		})
+	}
+}
+
+// TestMergeSortFraming sorts length-prefixed records that contain the delimiter.
+func TestMergeSortFraming(t *testing.T) {
+	var records [][]byte
+	for i := 0; i < 300; i++ {
+		records = append(records, []byte(fmt.Sprintf("%d\n%d", (i*7919)%300, i%3)))
+	}
+	records = append(records, []byte{}, []byte("\n"))
+
+	expected := append([][]byte(nil), records...)
+	sort.Slice(expected, func(i, j int) bool {
+		return bytes.Compare(expected[i], expected[j]) < 0
+	})
+
+	for _, framing := range []config.Framing{config.FramingVarint, config.FramingFixed32} {
+		dir := t.TempDir()
+		cfg := &config.Config{
+			BlockSize:   8,
+			MemoryLimit: 200,
+			Delimiter:   []byte("\n"),
+			Framing:     framing,
+			Less:        config.LessASC,
+			Order:       config.OrderASC,
+			FrontCoding: true,
+		}
+
+		input, err := os.Create(filepath.Join(dir, "input"))
+		require.NoError(t, err)
+
+		w := cfg.NewWriter(input, 0)
+		for _, record := range records {
+			require.NoError(t, w.Write(record))
+		}
+		require.NoError(t, w.Flush())
+		require.NoError(t, input.Close())
+
+		output := filepath.Join(dir, "output")
+		require.NoError(t, NewExternalMergeSort(cfg).Sort(input.Name(), output, dir))
+
+		f, err := os.Open(output)
+		require.NoError(t, err)
+
+		var sorted [][]byte
+		r := cfg.NewReader(f, 0, MaxInt64)
+		for {
+			token, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			sorted = append(sorted, token)
+		}
+		_ = f.Close()
+
+		assert.Equal(t, expected, sorted)
+	}
+}
+
+func TestMergeSortCorruptedRecords(t *testing.T) {
+	inputs := []struct {
+		framing config.Framing
+		data    []byte
+	}{
+		// The varint overflows int.
+		{config.FramingVarint, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 'a'}},
+		{config.FramingVarint, []byte{0x01, 'a', 0xff, 0xff, 0x7f, 'b'}},
+		{config.FramingFixed32, []byte{0xff, 0xff, 0xff, 0xff, 'a'}},
+		{config.FramingFixed32, []byte{0x00, 0x00, 0x00, 0x02, 'a'}},
+	}
+
+	for _, input := range inputs {
+		dir := t.TempDir()
+		cfg := &config.Config{
+			BlockSize:   8,
+			MemoryLimit: 200,
+			Framing:     input.framing,
+			Less:        config.LessASC,
+			Order:       config.OrderASC,
+		}
+
+		inputPath := filepath.Join(dir, "input")
+		require.NoError(t, ioutil.WriteFile(inputPath, input.data, 0644))
+
+		err := NewExternalMergeSort(cfg).Sort(inputPath, filepath.Join(dir, "output"), dir)
+		assert.Error(t, err, "%x", input.data)
+	}
+}
+
+func TestMergeSortDelimiters(t *testing.T) {
+	for _, delimiter := range []string{"\r\n", "||", "\x1e\n"} {
+		for _, sample := range samples {
+			tokens := strings.Split(sample, "\n")
+			input := strings.Join(tokens, delimiter)
+
+			sort.Strings(tokens)
+			expected := strings.Join(tokens, delimiter)
+			if expected != "" {
+				expected += delimiter
+			}
+
+			output := sortString(t, input, func(cfg *config.Config) {
+				cfg.Delimiter = []byte(delimiter)
+			})
+			assert.Equal(t, expected, output, "delimiter %q", delimiter)
+		}
+	}
+}
+
+func TestMergeSortCRLF(t *testing.T) {
+	input := "b\r\na\nc\r\r\n\r\nab\r\n"
+
+	// Only one "\r" is a part of the line break, so "c\r\r\n" is the token "c\r".
+	output := sortString(t, input, func(cfg *config.Config) {
+		cfg.CRLF = config.CRLFNormalize
+	})
+	assert.Equal(t, "\na\nab\nb\nc\r\n", output)
+
+	// A single run is converted to the output format too.
+	output = sortString(t, input, func(cfg *config.Config) {
+		cfg.MemoryLimit = 1 << 20
+		cfg.CRLF = config.CRLFNormalize
+	})
+	assert.Equal(t, "\na\nab\nb\nc\r\n", output)
+
+	// So are sorted runs extended to a single run and reverse-sorted runs concatenated by the adaptive sort.
+	for _, sorted := range []string{"a\r\nb\r\nc\r\r\nd\n", "d\nc\r\r\nb\r\na\r\n"} {
+		output = sortString(t, sorted, func(cfg *config.Config) {
+			cfg.CRLF = config.CRLFNormalize
+			cfg.Adaptive = true
+		})
+		assert.Equal(t, "a\nb\nc\r\nd\n", output)
+	}
+
+	// A sorted input isn't left untouched when it's sorted in place.
+	output, err := runSorter(t, "a\r\nb\r\n", func(cfg *config.Config) {
+		cfg.CRLF = config.CRLFNormalize
+		cfg.Adaptive = true
+	}, func(cfg *config.Config) sorter {
+		return sorterFunc(func(inputPath, outputPath, tempDir string) error {
+			err := NewExternalMergeSort(cfg).Sort(inputPath, inputPath, tempDir)
+			if err != nil {
+				return err
+			}
+
+			return os.Rename(inputPath, outputPath)
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "a\nb\n", output)
+
+	// Lines that end with "\n" only are converted to "\r\n".
+	output = sortString(t, input, func(cfg *config.Config) {
+		cfg.CRLF = config.CRLFPreserve
+		cfg.FrontCoding = true
+	})
+	assert.Equal(t, "\r\na\r\nab\r\nb\r\nc\r\r\n", output)
+
+	output, err = runSorter(t, input, func(cfg *config.Config) {
+		cfg.MemoryLimit = 21
+		cfg.CRLF = config.CRLFNormalize
+	}, func(cfg *config.Config) sorter {
+		return NewSampleSort(cfg, 3)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "\na\nab\nb\nc\r\n", output)
+}
+
+func TestMergeSortMultiline(t *testing.T) {
+	input := "preamble\nERROR b\n  at x\n  at y\nINFO a\n\nERROR a\n  at z\n"
+
+	output := sortString(t, input, func(cfg *config.Config) {
+		cfg.Framing = config.FramingMultiline
+		cfg.RecordStart = regexp.MustCompile(`^[A-Z]+ `)
+		cfg.Less, cfg.Order = config.FirstLine(config.LessASC), config.OrderCustom
+	})
+	assert.Equal(t, "ERROR a\n  at z\nERROR b\n  at x\n  at y\nINFO a\n\npreamble\n", output)
+
+	input = "c\nc2\n\n\n\na\na2\r\n\r\nb\n"
+
+	output = sortString(t, input, func(cfg *config.Config) {
+		cfg.Framing = config.FramingMultiline
+		cfg.Less, cfg.Order = config.FirstLine(config.LessASC), config.OrderCustom
+		cfg.FrontCoding = true
+	})
+	assert.Equal(t, "a\na2\r\n\nb\n\nc\nc2\n\n", output)
+}
+
+func TestMergeSortCSV(t *testing.T) {
+	input := "name,qty,note\r\nb,2,\"x, y\"\r\na,10,\"two\nlines\"\r\nc,1,\r\n"
+	csv := func(cfg *config.Config) {
+		cfg.Framing = config.FramingCSV
+		cfg.Header = true
+		cfg.KeyFunc = config.CSVKeyFunc([]config.Column{{Index: 1, Type: config.ColumnNumber}}, ',', config.OrderASC)
+	}
+
+	expected := "name,qty,note\r\nc,1,\r\nb,2,\"x, y\"\r\na,10,\"two\nlines\"\r\n"
+	assert.Equal(t, expected, sortString(t, input, csv))
+
+	assert.Equal(t, expected, sortString(t, input, func(cfg *config.Config) {
+		csv(cfg)
+		cfg.Merger = PolyphaseMerger{}
+	}))
+
+	assert.Equal(t, "name,qty,note\n", sortString(t, "name,qty,note\n", csv))
+
+	// Records that can't be parsed fail the sort.
+	_, err := runSorter(t, "name,qty\nb,2\na\"b,1\n", csv, func(cfg *config.Config) sorter {
+		return NewExternalMergeSort(cfg)
+	})
+	assert.Error(t, err)
+}
+
+func TestMergeSortKeyFunc(t *testing.T) {
+	input := "{\"id\": 3}\n{\"id\": 1, \"ts\": 5}\n{}\n{\"id\": 20}\n{\"id\": 1, \"ts\": 7}\n{\"id\": \"2\"}\n"
+	expected := "{\"id\": 1, \"ts\": 7}\n{\"id\": 1, \"ts\": 5}\n{\"id\": 3}\n{\"id\": 20}\n{\"id\": \"2\"}\n{}\n"
+
+	keys, err := config.ParseJSONKeys(".id,.ts:desc")
+	require.NoError(t, err)
+
+	for _, configure := range []func(cfg *config.Config){
+		func(cfg *config.Config) {},
+		func(cfg *config.Config) {
+			cfg.FrontCoding = true
+		},
+		func(cfg *config.Config) {
+			cfg.Merger = PolyphaseMerger{}
+			cfg.Adaptive = true
+		},
+	} {
+		output := sortString(t, input, func(cfg *config.Config) {
+			cfg.MemoryLimit = 30
+			cfg.KeyFunc = config.JSONKeyFunc(keys, config.MissingLast)
+			configure(cfg)
+		})
+		assert.Equal(t, expected, output)
+	}
+
+	// The header has no key, so it's written as is. A header that starts with '#' looks like a keyed record
+	// with a 35-byte key to config.SplitKey.
+	header := "# exported events, one JSON object per line\n"
+	for _, frontCoding := range []bool{false, true} {
+		output := sortString(t, header+input, func(cfg *config.Config) {
+			cfg.MemoryLimit = 30
+			cfg.Header = true
+			cfg.KeyFunc = config.JSONKeyFunc(keys, config.MissingLast)
+			cfg.FrontCoding = frontCoding
+		})
+		assert.Equal(t, header+expected, output)
+	}
+}
+
+// sortString sorts the input with the default test configuration adjusted by configure.
+func sortString(t *testing.T, input string, configure func(cfg *config.Config)) string {
+	output, err := runSorter(t, input, configure, func(cfg *config.Config) sorter {
+		return NewExternalMergeSort(cfg)
+	})
+	require.NoError(t, err)
+
+	return output
+}
+
+func TestMergeSortCanceled(t *testing.T) {
+	dir := t.TempDir()
+
+	inputPath := filepath.Join(dir, "input")
+	require.NoError(t, ioutil.WriteFile(inputPath, []byte(strings.Repeat("b\na\n", 2*contextCheckPeriod)), 0644))
+
+	outputPath := filepath.Join(dir, "output")
+	require.NoError(t, ioutil.WriteFile(outputPath, []byte("PRECIOUS\n"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
 	msort := NewExternalMergeSort(&config.Config{
+		BlockSize:   16,
+		MemoryLimit: 64,
+		Delimiter:   []byte("\n"),
+		Less:        config.LessASC,
+		Order:       config.OrderASC,
+	})
+	err := msort.SortContext(ctx, inputPath, outputPath, dir)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// The output is left as is, and temp files are removed.
+	output, err := ioutil.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, "PRECIOUS\n", string(output))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+// TestMergeSortConcurrent runs all samples with one sorter at the same time.
+// The governor has memory only for a few of them, so the rest wait or get less memory.
+func TestMergeSortConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	msort := NewExternalMergeSort(&config.Config{
+		BlockSize:   2,
+		MemoryLimit: 7,
+		Delimiter:   []byte("\n"),
+		Less:        config.LessASC,
+		Order:       config.OrderASC,
+		FrontCoding: true,
+		Merger:      KWayMerger{},
+		Governor:    memory.NewGovernor(20),
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		for j, sample := range samples {
+			input := filepath.Join(dir, fmt.Sprintf("input_%d_%d", i, j))
+			assert.Nil(t, ioutil.WriteFile(input, []byte(sample), 0644), "write input")
+
+			wg.Add(1)
+			go func(sample, input string) {
+				defer wg.Done()
+
+				output := input + ".sorted"
+				assert.Nil(t, msort.Sort(input, output, dir), "run sort")
+
+				result, err := ioutil.ReadFile(output)
+				assert.Nil(t, err, "read output")
+
+				sorted := strings.Split(sample, "\n")
+				sort.Strings(sorted)
+
+				expected := strings.Join(sorted, "\n")
+				if expected != "" {
+					expected += "\n"
+				}
+
+				assert.Equal(t, expected, string(result), "valid output")
+			}(sample, input)
+		}
+	}
+
+	wg.Wait()
+}
+
+type sorter interface {
+	Sort(inputPath, outputPath, tempDir string) error
+}
+
+// sorterFunc makes a sorter of a function, so tools with other signatures can be run by runSorter.
+type sorterFunc func(inputPath, outputPath, tempDir string) error
+
+func (f sorterFunc) Sort(inputPath, outputPath, tempDir string) error {
+	return f(inputPath, outputPath, tempDir)
+}
+
+// runSorter writes the input to a temp file, runs the sorter created by newSorter and returns the output.
+// The default test configuration is adjusted by configure before the sorter is created.
+func runSorter(t *testing.T, input string, configure func(cfg *config.Config), newSorter func(cfg *config.Config) sorter) (string, error) {
+	dir := t.TempDir()
+	cfg := &config.Config{
 		BlockSize:   2,
 		MemoryLimit: 7,
-		Delimiter:   byte('\n'),
+		Delimiter:   []byte("\n"),
+		Less:        config.LessASC,
+		Order:       config.OrderASC,
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	inputPath := filepath.Join(dir, "input")
+	require.NoError(t, ioutil.WriteFile(inputPath, []byte(input), 0644))
+
+	outputPath := filepath.Join(dir, "output")
+	err := newSorter(cfg).Sort(inputPath, outputPath, dir)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := ioutil.ReadFile(outputPath)
+	require.NoError(t, err)
+
+	return string(output), nil
+}
+
+// checkSample sorts the sample and compares the result with sort.Strings.
+// configure can be used to adjust the default test configuration.
+func checkSample(t *testing.T, sample string, configure func(cfg *config.Config)) {
+	checkSorter(t, sample, func(cfg *config.Config) sorter {
+		if configure != nil {
+			configure(cfg)
+		}
+
+		return NewExternalMergeSort(cfg)
+	})
+}
+
+// checkSorter is like checkSample, but the sorter is created by newSorter.
+func checkSorter(t *testing.T, sample string, newSorter func(cfg *config.Config) sorter) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "input")
+	err := ioutil.WriteFile(inputPath, []byte(sample), 0644)
+	assert.Nil(t, err, "write input")
+
+	cfg := &config.Config{
+		BlockSize:   2,
+		MemoryLimit: 7,
+		Delimiter:   []byte("\n"),
 		Less:        func(a, b []byte) bool {
 			return bytes.Compare(a, b) < 0
 		},
-	})
-
-	const outputFilename = "test_merge_sort_output.txt"
-	defer func() {
-		_ = os.Remove(outputFilename)
-	}()
+	}
+	msort := newSorter(cfg)
 
-	err = msort.Sort(file.Name(), outputFilename, ".")
+	outputPath := filepath.Join(dir, "output")
+	err = msort.Sort(inputPath, outputPath, dir)
 	assert.Nil(t, err, "run sort")
 
-	output, err := ioutil.ReadFile(outputFilename)
+	output, err := ioutil.ReadFile(outputPath)
 	assert.Nil(t, err, "read output")
 
 	sorted := strings.Split(sample, "\n")
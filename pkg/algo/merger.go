@@ -0,0 +1,221 @@
+package algo
+
+import (
+	"container/heap"
+	"io"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// TwoWayMerger merges runs pairwise.
+type TwoWayMerger struct{}
+
+func (TwoWayMerger) FanIn(*config.Config) int {
+	return 2
+}
+
+func (TwoWayMerger) Merge(sources []buffer.TokenReader, dst buffer.TokenWriter, less func(a, b []byte) bool) error {
+	if len(sources) > 2 {
+		return errors.Errorf("2-way merge cannot merge %d runs", len(sources))
+	}
+
+	var readerA, readerB buffer.TokenReader = emptyReader{}, emptyReader{}
+	if len(sources) > 0 {
+		readerA = sources[0]
+	}
+	if len(sources) > 1 {
+		readerB = sources[1]
+	}
+
+	tokenA, err := readerA.Next()
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	tokenB, err := readerB.Next()
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	writeAndReadNext := func(token *[]byte, reader buffer.TokenReader) error {
+		err = dst.Write(*token)
+		if err != nil {
+			return errors.Wrap(err, "write failed")
+		}
+
+		*token, err = reader.Next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		return nil
+	}
+
+	for tokenA != nil && tokenB != nil {
+		if less(tokenA, tokenB) {
+			err = writeAndReadNext(&tokenA, readerA)
+		} else {
+			err = writeAndReadNext(&tokenB, readerB)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for tokenA != nil {
+		err = writeAndReadNext(&tokenA, readerA)
+		if err != nil {
+			return err
+		}
+	}
+
+	for tokenB != nil {
+		err = writeAndReadNext(&tokenB, readerB)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// KWayMerger merges K runs at once using a binary heap.
+// It needs K+1 blocks of main memory: one for each run and one for the output.
+type KWayMerger struct {
+	// K is the maximum number of runs merged at once.
+	// If K is less than 2, it's derived from the memory limit.
+	K int
+}
+
+func (k KWayMerger) FanIn(cfg *config.Config) int {
+	if k.K >= 2 {
+		return k.K
+	}
+
+	fanIn := cfg.MemoryLimit/cfg.BlockSize - 1
+	if fanIn < 2 {
+		return 2
+	}
+
+	return fanIn
+}
+
+func (KWayMerger) Merge(sources []buffer.TokenReader, dst buffer.TokenWriter, less func(a, b []byte) bool) error {
+	h, err := newMergeHeap(sources, less)
+	if err != nil {
+		return err
+	}
+
+	for h.Len() > 0 {
+		err = dst.Write(h.items[0].token)
+		if err != nil {
+			return errors.Wrap(err, "write failed")
+		}
+
+		err = h.advance()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type mergeHeapItem struct {
+	token  []byte
+	source int
+}
+
+// mergeHeap keeps the current token of every non-exhausted source.
+// The smallest token is stored at the top, ties are broken by the source index.
+type mergeHeap struct {
+	items   []mergeHeapItem
+	sources []buffer.TokenReader
+	less    func(a, b []byte) bool
+}
+
+func newMergeHeap(sources []buffer.TokenReader, less func(a, b []byte) bool) (*mergeHeap, error) {
+	h := &mergeHeap{
+		items:   make([]mergeHeapItem, 0, len(sources)),
+		sources: sources,
+		less:    less,
+	}
+
+	for i, source := range sources {
+		token, err := source.Next()
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		h.items = append(h.items, mergeHeapItem{
+			token:  token,
+			source: i,
+		})
+	}
+	heap.Init(h)
+
+	return h, nil
+}
+
+// advance replaces the top token with the next token of the same source.
+func (h *mergeHeap) advance() error {
+	top := &h.items[0]
+
+	token, err := h.sources[top.source].Next()
+	if errors.Is(err, io.EOF) {
+		heap.Pop(h)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	top.token = token
+	heap.Fix(h, 0)
+
+	return nil
+}
+
+func (h *mergeHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if h.less(a.token, b.token) {
+		return true
+	}
+	if h.less(b.token, a.token) {
+		return false
+	}
+
+	return a.source < b.source
+}
+
+func (h *mergeHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *mergeHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(mergeHeapItem))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+
+	return last
+}
+
+// emptyReader is a source without tokens.
+type emptyReader struct{}
+
+func (emptyReader) Next() ([]byte, error) {
+	return nil, io.EOF
+}
@@ -0,0 +1,255 @@
+package algo
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// PolyphaseMerger merges runs with the polyphase schedule over a few temp files (tapes).
+//
+// Runs are spread over Files-1 input tapes according to the generalized Fibonacci distribution.
+// Each phase merges one run from every input tape into the output tape until one of the input tapes
+// becomes empty. The empty tape is the output tape of the next phase.
+// Runs themselves are merged with KWayMerger.
+type PolyphaseMerger struct {
+	// Files is the number of tapes, at least 3.
+	// If Files is less than 3, it's derived from the memory limit.
+	Files int
+}
+
+func (p PolyphaseMerger) FanIn(cfg *config.Config) int {
+	return p.files(cfg) - 1
+}
+
+func (PolyphaseMerger) Merge(sources []buffer.TokenReader, dst buffer.TokenWriter, less func(a, b []byte) bool) error {
+	return KWayMerger{}.Merge(sources, dst, less)
+}
+
+func (p PolyphaseMerger) files(cfg *config.Config) int {
+	if p.Files >= 3 {
+		return p.Files
+	}
+
+	return KWayMerger{}.FanIn(cfg) + 1
+}
+
+// tape is a sequence of runs stored in one file.
+type tape struct {
+	file   *os.File
+	blocks []mergeSortBlock
+}
+
+// polyphaseSort merges blocks of m.input with the polyphase schedule.
+// After it returns, the sorted data is stored in m.input like after the balanced passes.
+func (m *mergeSortJob) polyphaseSort(merger PolyphaseMerger, blocks []mergeSortBlock) (err error) {
+	startedAt := time.Now()
+
+	files := merger.files(m.cfg)
+	log.Printf("polyphase merge started (%d files)...\n", files)
+
+	// Initially, all input tapes share the file with runs, and m.output is the first output tape.
+	owned := []*os.File{m.input, m.output}
+	tapes := distributeRuns(m.input, blocks, files-1)
+	output := len(tapes)
+	tapes = append(tapes, &tape{file: m.output})
+
+	defer func() {
+		m.releaseTapes(owned, tapes[output].file)
+	}()
+
+	var phases int
+	for {
+		phases++
+
+		steps := -1
+		final := true
+		for i, t := range tapes {
+			if i == output {
+				continue
+			}
+
+			if steps == -1 || len(t.blocks) < steps {
+				steps = len(t.blocks)
+			}
+			if len(t.blocks) != 1 {
+				final = false
+			}
+		}
+
+		out := tapes[output]
+		writer := m.newRunWriter(out.file, final)
+
+		for step := 0; step < steps; step++ {
+			sources := make([]buffer.TokenReader, 0, len(tapes)-1)
+			for i, t := range tapes {
+				if i == output {
+					continue
+				}
+
+				sources = append(sources, m.newRunReader(t.file, t.blocks[0]))
+				t.blocks = t.blocks[1:]
+			}
+
+			block, err := m.merge(merger, sources, writer)
+			if err != nil {
+				log.Printf("phase #%d failed: %v\n", phases, err)
+				return errors.Wrap(err, "merge failed")
+			}
+
+			out.blocks = append(out.blocks, block)
+		}
+
+		err = writer.Flush()
+		release(writer)
+		if err != nil {
+			return errors.Wrap(err, "flush failed")
+		}
+
+		// Dummy runs are counted too.
+		var runsLeft int
+		for _, t := range tapes {
+			runsLeft += len(t.blocks)
+		}
+
+		m.resultSize = writer.Offset()
+		m.updateStats(func(s *Stats) {
+			s.Passes++
+			s.RunsLeft = runsLeft
+			s.BytesWritten += m.resultSize
+		})
+
+		log.Printf("phase #%d finished, %d runs merged\n", phases, steps)
+
+		if final {
+			break
+		}
+
+		for i, t := range tapes {
+			if i != output && len(t.blocks) == 0 {
+				output = i
+				break
+			}
+		}
+
+		tapes[output].file, err = m.freeTapeFile(tapes, output, &owned)
+		if err != nil {
+			return errors.Wrap(err, "failed to find a file for the output tape")
+		}
+	}
+
+	log.Printf("polyphase merge finished in %d phases (%v)\n\n", phases, time.Since(startedAt))
+
+	return nil
+}
+
+// distributeRuns spreads blocks of file over n tapes and pads them with dummy (empty) runs,
+// so the number of runs on tapes forms a perfect polyphase distribution.
+// Dummy runs are placed first, so they are merged in the first phases.
+func distributeRuns(file *os.File, blocks []mergeSortBlock, n int) []*tape {
+	counts := make([]int, n)
+	total := n
+	for i := range counts {
+		counts[i] = 1
+	}
+
+	// Each level of the distribution is derived from the previous one: the first tape gets
+	// the largest count a, the i-th tape gets a plus the count of the (i+1)-th tape.
+	for total < len(blocks) {
+		a := counts[0]
+		total = 0
+		for i := range counts {
+			counts[i] = a
+			if i+1 < n {
+				counts[i] += counts[i+1]
+			}
+			total += counts[i]
+		}
+	}
+
+	dummies := make([]int, n)
+	for i, left := 0, total-len(blocks); left > 0; i = (i + 1) % n {
+		if dummies[i] < counts[i] {
+			dummies[i]++
+			left--
+		}
+	}
+
+	tapes := make([]*tape, n)
+	var next int
+	for i := range tapes {
+		t := &tape{file: file}
+		for j := 0; j < dummies[i]; j++ {
+			t.blocks = append(t.blocks, mergeSortBlock{})
+		}
+
+		realRuns := counts[i] - dummies[i]
+		t.blocks = append(t.blocks, blocks[next:next+realRuns]...)
+		next += realRuns
+
+		tapes[i] = t
+	}
+
+	return tapes
+}
+
+// freeTapeFile returns a file that no tape except output reads runs from.
+// A new temp file is created if all owned files are still in use.
+func (m *mergeSortJob) freeTapeFile(tapes []*tape, output int, owned *[]*os.File) (*os.File, error) {
+	used := make(map[*os.File]bool)
+	for i, t := range tapes {
+		if i != output && len(t.blocks) > 0 {
+			used[t.file] = true
+		}
+	}
+
+	if !used[tapes[output].file] {
+		return tapes[output].file, nil
+	}
+
+	for _, f := range *owned {
+		if !used[f] {
+			return f, nil
+		}
+	}
+
+	f, err := os.CreateTemp(m.tempDir, tempPattern)
+	if err != nil {
+		return nil, err
+	}
+	*owned = append(*owned, f)
+
+	return f, nil
+}
+
+// releaseTapes makes result the new m.input and removes extra temp files.
+func (m *mergeSortJob) releaseTapes(owned []*os.File, result *os.File) {
+	m.input = result
+	m.output = nil
+
+	for _, f := range owned {
+		if f == result {
+			continue
+		}
+
+		// finish removes m.output itself.
+		if m.output == nil {
+			m.output = f
+			continue
+		}
+
+		err := f.Close()
+		if err != nil {
+			log.Printf("failed to close temp file: %v\n", err)
+		}
+
+		err = os.Remove(f.Name())
+		if err != nil {
+			log.Printf("failed to remove temp file: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package algo
+
+import (
+	"bytes"
+)
+
+// Buckets smaller than this threshold are sorted with insertion sort.
+const radixInsertionSortThreshold = 32
+
+// radixSort sorts tokens in the byte-wise lexicographic order using MSD radix sort.
+func radixSort(tokens [][]byte) {
+	if len(tokens) < 2 {
+		return
+	}
+
+	msdRadixSort(tokens, make([][]byte, len(tokens)), 0)
+}
+
+// msdRadixSort sorts tokens that share the first depth bytes.
+// aux must have the same length as tokens.
+func msdRadixSort(tokens, aux [][]byte, depth int) {
+	for len(tokens) > radixInsertionSortThreshold {
+		// Bucket 0 is reserved for tokens of length depth, bucket c+1 is for tokens with byte c at depth.
+		var counts [257]int
+		for _, t := range tokens {
+			counts[radixBucket(t, depth)]++
+		}
+
+		// All tokens have the same byte at depth, so there is nothing to distribute.
+		if counts[radixBucket(tokens[0], depth)] == len(tokens) {
+			if len(tokens[0]) == depth {
+				return
+			}
+
+			depth++
+			continue
+		}
+
+		var starts [257]int
+		for c := 1; c < len(starts); c++ {
+			starts[c] = starts[c-1] + counts[c-1]
+		}
+
+		positions := starts
+		for _, t := range tokens {
+			c := radixBucket(t, depth)
+			aux[positions[c]] = t
+			positions[c]++
+		}
+		copy(tokens, aux)
+
+		// Tokens in bucket 0 are equal, other buckets are sorted by the next byte.
+		for c := 1; c < len(starts); c++ {
+			if counts[c] > 1 {
+				lo, hi := starts[c], starts[c]+counts[c]
+				msdRadixSort(tokens[lo:hi], aux[lo:hi], depth+1)
+			}
+		}
+
+		return
+	}
+
+	insertionSort(tokens, depth)
+}
+
+func radixBucket(token []byte, depth int) int {
+	if depth < len(token) {
+		return int(token[depth]) + 1
+	}
+
+	return 0
+}
+
+// insertionSort sorts tokens that share the first depth bytes.
+func insertionSort(tokens [][]byte, depth int) {
+	for i := 1; i < len(tokens); i++ {
+		for j := i; j > 0 && bytes.Compare(tokens[j][depth:], tokens[j-1][depth:]) < 0; j-- {
+			tokens[j], tokens[j-1] = tokens[j-1], tokens[j]
+		}
+	}
+}
+
+func reverse(tokens [][]byte) {
+	for i, j := 0, len(tokens)-1; i < j; i, j = i+1, j-1 {
+		tokens[i], tokens[j] = tokens[j], tokens[i]
+	}
+}
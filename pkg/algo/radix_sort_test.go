@@ -0,0 +1,73 @@
+package algo
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRadixSort(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+
+	for _, count := range []int{0, 1, 2, 31, 32, 33, 1000, 5000} {
+		tokens := make([][]byte, count)
+		for i := range tokens {
+			// A short alphabet and a common prefix produce many equal bytes and duplicates.
+			token := []byte("prefix")
+			for j := rnd.Intn(8); j > 0; j-- {
+				token = append(token, byte('a'+rnd.Intn(3)))
+			}
+			tokens[i] = token
+		}
+
+		expected := make([]string, count)
+		for i, token := range tokens {
+			expected[i] = string(token)
+		}
+		sort.Strings(expected)
+
+		radixSort(tokens)
+
+		actual := make([]string, count)
+		for i, token := range tokens {
+			actual[i] = string(token)
+		}
+
+		assert.Equal(t, expected, actual, "%d tokens", count)
+	}
+}
+
+func BenchmarkRunSorter(b *testing.B) {
+	rnd := rand.New(rand.NewSource(42))
+
+	source := make([][]byte, 100000)
+	for i := range source {
+		token := make([]byte, 16+rnd.Intn(16))
+		for j := range token {
+			token[j] = byte('a' + rnd.Intn(26))
+		}
+		source[i] = token
+	}
+
+	cfg := &config.Config{
+		Less:  config.LessASC,
+		Order: config.OrderASC,
+	}
+	sorters := map[string]config.RunSorter{
+		"comparison": ComparisonSorter{},
+		"radix":      RadixSorter{},
+	}
+
+	for name, sorter := range sorters {
+		b.Run(name, func(b *testing.B) {
+			tokens := make([][]byte, len(source))
+			for i := 0; i < b.N; i++ {
+				copy(tokens, source)
+				sorter.SortRun(tokens, cfg)
+			}
+		})
+	}
+}
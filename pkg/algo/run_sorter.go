@@ -0,0 +1,35 @@
+package algo
+
+import (
+	"sort"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+)
+
+// ComparisonSorter sorts runs with sort.Slice and cfg.Less.
+type ComparisonSorter struct{}
+
+func (ComparisonSorter) SortRun(tokens [][]byte, cfg *config.Config) {
+	// TODO: a parallel sort can be used here.
+	sort.Slice(tokens, func(i, j int) bool {
+		return cfg.Less(tokens[i], tokens[j])
+	})
+}
+
+// RadixSorter sorts runs with MSD radix sort if cfg.Order is one of the built-in orders.
+// Custom comparators fall back to ComparisonSorter.
+type RadixSorter struct{}
+
+func (RadixSorter) SortRun(tokens [][]byte, cfg *config.Config) {
+	switch cfg.Order {
+	case config.OrderASC:
+		radixSort(tokens)
+
+	case config.OrderDESC:
+		radixSort(tokens)
+		reverse(tokens)
+
+	default:
+		ComparisonSorter{}.SortRun(tokens, cfg)
+	}
+}
@@ -0,0 +1,488 @@
+package algo
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// How many samples are taken for each partition.
+const samplesPerPartition = 64
+
+// Samples are read with small buffers, as only one token is needed.
+const sampleBufferSize = 4 * 1024
+
+// SampleSort splits the input into range partitions using sampled splitters,
+// sorts partitions independently and in parallel with ExternalMergeSort
+// and concatenates them. No global merge is needed, as the partitions don't overlap.
+type SampleSort struct {
+	cfg        *config.Config
+	partitions int
+}
+
+// NewSampleSort creates a sorter that uses the given number of partitions.
+// Each partition is sorted with cfg.MemoryLimit/partitions bytes of main memory,
+// and partitioning itself needs one block per partition.
+func NewSampleSort(cfg *config.Config, partitions int) *SampleSort {
+	if partitions < 1 {
+		partitions = 1
+	}
+
+	return &SampleSort{
+		cfg:        cfg,
+		partitions: partitions,
+	}
+}
+
+// Sort loads data from the input file, sorts it and saves result to the output file.
+func (s *SampleSort) Sort(inputPath, outputPath, tempDir string) error {
+	startedAt := time.Now()
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open input file")
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+
+	splitters, err := SampleSplitters(input, s.cfg, s.partitions)
+	if err != nil {
+		return errors.Wrap(err, "failed to sample splitters")
+	}
+
+	buckets, header, err := s.partition(input, splitters, tempDir)
+	defer removeFiles(buckets)
+	if err != nil {
+		return errors.Wrap(err, "failed to partition input")
+	}
+
+	sorted, err := s.sortBuckets(buckets, tempDir)
+	defer removeFiles(sorted)
+	if err != nil {
+		return errors.Wrap(err, "failed to sort partitions")
+	}
+
+	// Buckets are concatenated as is if they are stored in the output format.
+	if bucketCfg := s.bucketConfig(); bucketCfg.Framing != s.cfg.Framing || bucketCfg.CRLF != s.cfg.CRLF || header != nil {
+		err = s.writeBuckets(sorted, header, outputPath)
+	} else {
+		err = concatFiles(sorted, outputPath)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to concatenate partitions")
+	}
+
+	log.Printf("sample sort with %d partitions finished in %v\n", s.partitions, time.Since(startedAt))
+
+	return nil
+}
+
+// SampleSplitters reads tokens at random offsets of the input and chooses up to partitions-1 splitters,
+// so tokens are split into ranges of approximately equal size.
+// Records of length-prefixed framings can't be found at random offsets, so the whole input is scanned for them.
+// Fixed-width records are read at random offsets aligned to the record size.
+func SampleSplitters(input *os.File, cfg *config.Config, partitions int) ([][]byte, error) {
+	if partitions <= 1 {
+		return nil, nil
+	}
+
+	info, err := input.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+
+	rnd := rand.New(rand.NewSource(size))
+	n := partitions * samplesPerPartition
+
+	var samples [][]byte
+	switch cfg.Framing {
+	case config.FramingDelimiter:
+		samples, err = sampleAtRandomOffsets(input, cfg, rnd, size, n)
+
+	case config.FramingFixed:
+		samples, err = sampleAlignedOffsets(input, cfg, rnd, size, n)
+
+	default:
+		samples, err = sampleSequentially(input, cfg, rnd, n)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(samples) == 0 {
+		return nil, nil
+	}
+
+	// Splitters are compared with partial results of a combiner.
+	for i := range samples {
+		samples[i], err = cfg.Prepare(samples[i], 0)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to prepare a sample")
+		}
+	}
+
+	// Keys of samples are extracted once, and samples are compared by keys.
+	less := cfg.Less
+	if cfg.KeyFunc != nil {
+		less = config.LessKeyed
+		samples, err = withKeys(cfg, samples)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to extract the key of a sample")
+		}
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return less(samples[i], samples[j])
+	})
+
+	splitters := make([][]byte, partitions-1)
+	for i := range splitters {
+		splitters[i] = samples[(i+1)*len(samples)/partitions]
+		if cfg.KeyFunc != nil {
+			_, splitters[i] = config.SplitKey(splitters[i])
+		}
+	}
+
+	return splitters, nil
+}
+
+// sampleAtRandomOffsets reads n tokens that follow random offsets of the input.
+func sampleAtRandomOffsets(input *os.File, cfg *config.Config, rnd *rand.Rand, size int64, n int) ([][]byte, error) {
+	samples := make([][]byte, 0, n)
+
+	for i := 0; i < n; i++ {
+		offset := rnd.Int63n(size)
+		r := buffer.NewReader(input, offset, size, sampleBufferSize, cfg.Delimiter)
+
+		// The token at offset is probably cut, so the next one is taken. The header isn't sampled either.
+		if offset > 0 || cfg.Header {
+			_, err := r.Next()
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+		}
+
+		token, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, token)
+	}
+
+	return samples, nil
+}
+
+// sampleAlignedOffsets reads n fixed-width records at random positions.
+func sampleAlignedOffsets(input *os.File, cfg *config.Config, rnd *rand.Rand, size int64, n int) ([][]byte, error) {
+	records := size / int64(cfg.RecordSize)
+	if records == 0 {
+		return nil, nil
+	}
+
+	samples := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		offset := rnd.Int63n(records) * int64(cfg.RecordSize)
+		r := buffer.NewFixedReader(input, offset, offset+int64(cfg.RecordSize), cfg.RecordSize, cfg.RecordSize)
+
+		record, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, record)
+	}
+
+	return samples, nil
+}
+
+// sampleSequentially reads all tokens of the input and chooses n random ones (reservoir sampling).
+func sampleSequentially(input *os.File, cfg *config.Config, rnd *rand.Rand, n int) ([][]byte, error) {
+	r := cfg.NewReader(input, 0, MaxInt64)
+	defer r.Release()
+
+	if cfg.Header {
+		_, err := r.Next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	}
+
+	samples := make([][]byte, 0, n)
+	for seen := 0; ; seen++ {
+		token, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(samples) < n {
+			samples = append(samples, token)
+		} else if j := rnd.Intn(seen + 1); j < n {
+			samples[j] = token
+		}
+	}
+}
+
+// partition writes each token to the bucket of its range.
+// If cfg.Header is set, the first token isn't written to buckets and is returned.
+func (s *SampleSort) partition(input *os.File, splitters [][]byte, tempDir string) (buckets []*os.File, header []byte, err error) {
+	startedAt := time.Now()
+
+	cfg := s.bucketConfig()
+	buckets = make([]*os.File, 0, len(splitters)+1)
+	writers := make([]buffer.TokenWriter, 0, len(splitters)+1)
+	for i := 0; i <= len(splitters); i++ {
+		f, err := os.CreateTemp(tempDir, tempPattern)
+		if err != nil {
+			return buckets, nil, err
+		}
+
+		buckets = append(buckets, f)
+		writers = append(writers, cfg.NewWriter(f, 0))
+	}
+
+	// Keys of splitters are extracted once, and tokens are compared by keys.
+	less := s.cfg.Less
+	if s.cfg.KeyFunc != nil {
+		less = config.LessKeyed
+		splitters, err = withKeys(s.cfg, splitters)
+		if err != nil {
+			return buckets, nil, errors.Wrap(err, "failed to extract the key of a splitter")
+		}
+	}
+
+	r := s.cfg.NewReader(input, 0, MaxInt64)
+	defer r.Release()
+
+	if s.cfg.Header {
+		header, err = r.Next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return buckets, nil, errors.Wrap(err, "failed to read the header")
+		}
+	}
+
+	for {
+		token, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return buckets, nil, errors.Wrap(err, "failed to read the next token")
+		}
+
+		keyed, err := s.cfg.Prepare(token, 0)
+		if err != nil {
+			return buckets, nil, errors.Wrap(err, "failed to prepare the token")
+		}
+
+		if s.cfg.KeyFunc != nil {
+			keyed, err = s.cfg.WithKey(keyed)
+			if err != nil {
+				return buckets, nil, errors.Wrap(err, "failed to extract the key")
+			}
+		}
+
+		bucket := Partition(keyed, splitters, less)
+
+		err = writers[bucket].Write(token)
+		if err != nil {
+			return buckets, nil, errors.Wrap(err, "write failed")
+		}
+	}
+
+	for _, w := range writers {
+		err := w.Flush()
+		if err != nil {
+			return buckets, nil, errors.Wrap(err, "flush failed")
+		}
+	}
+
+	log.Printf("input partitioned into %d buckets in %v\n\n", len(buckets), time.Since(startedAt))
+
+	return buckets, header, nil
+}
+
+// withKeys prefixes tokens with their keys, see config.WithKey.
+func withKeys(cfg *config.Config, tokens [][]byte) ([][]byte, error) {
+	keyed := make([][]byte, len(tokens))
+	for i, token := range tokens {
+		var err error
+		keyed[i], err = cfg.WithKey(token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return keyed, nil
+}
+
+// Partition returns the index of the range the token belongs to.
+// The i-th range contains tokens t such that splitters[i-1] <= t < splitters[i].
+func Partition(token []byte, splitters [][]byte, less func(a, b []byte) bool) int {
+	return sort.Search(len(splitters), func(i int) bool {
+		return less(token, splitters[i])
+	})
+}
+
+// sortBuckets sorts buckets in parallel and returns sorted files in the same order.
+func (s *SampleSort) sortBuckets(buckets []*os.File, tempDir string) ([]*os.File, error) {
+	sorted := make([]*os.File, len(buckets))
+	errs := make([]error, len(buckets))
+
+	cfg := s.bucketConfig()
+	cfg.MemoryLimit /= len(buckets)
+
+	var wg sync.WaitGroup
+	for i := range buckets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			path := buckets[i].Name() + ".sorted"
+			errs[i] = NewExternalMergeSort(&cfg).Sort(buckets[i].Name(), path, tempDir)
+			if errs[i] != nil {
+				_ = os.Remove(path)
+				return
+			}
+
+			sorted[i], errs[i] = os.Open(path)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return sorted, errors.Wrapf(err, "partition #%d", i)
+		}
+	}
+
+	return sorted, nil
+}
+
+// bucketConfig returns the config buckets are stored and sorted with. Buckets have no header.
+// Multiline records are stored as length-prefixed records, as a record without a start line
+// would join the previous one when a bucket is read back.
+// Normalized lines keep "\r" in buckets, so tokens that end with "\r" aren't cut.
+func (s *SampleSort) bucketConfig() config.Config {
+	cfg := *s.cfg
+	cfg.Header = false
+	if cfg.Framing == config.FramingMultiline {
+		cfg.Framing = config.FramingVarint
+	}
+	if cfg.CRLF == config.CRLFNormalize {
+		cfg.CRLF = config.CRLFPreserve
+	}
+
+	return cfg
+}
+
+// writeBuckets writes the header and tokens of sorted buckets one after another to the output file
+// in the output format.
+func (s *SampleSort) writeBuckets(files []*os.File, header []byte, outputPath string) error {
+	output, err := os.OpenFile(outputPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = output.Close()
+	}()
+
+	cfg := s.bucketConfig()
+	w := s.cfg.NewWriter(output, 0)
+	defer release(w)
+
+	if header != nil {
+		err = w.Write(header)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range files {
+		r := cfg.NewReader(f, 0, MaxInt64)
+		for {
+			token, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				r.Release()
+				return err
+			}
+
+			err = w.Write(token)
+			if err != nil {
+				r.Release()
+				return err
+			}
+		}
+		r.Release()
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	return output.Sync()
+}
+
+// concatFiles writes content of files one after another to the output file.
+func concatFiles(files []*os.File, outputPath string) error {
+	output, err := os.OpenFile(outputPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		_, err = io.Copy(output, f)
+		if err != nil {
+			_ = output.Close()
+			return err
+		}
+	}
+
+	err = output.Sync()
+	if err != nil {
+		_ = output.Close()
+		return err
+	}
+
+	return output.Close()
+}
+
+// removeFiles closes and removes files, nil entries are skipped.
+func removeFiles(files []*os.File) {
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+
+		_ = f.Close()
+
+		err := os.Remove(f.Name())
+		if err != nil {
+			log.Printf("failed to remove temp file: %v\n", err)
+		}
+	}
+}
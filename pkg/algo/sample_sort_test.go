@@ -0,0 +1,182 @@
+package algo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleSort(t *testing.T) {
+	for _, sample := range samples {
+		for _, partitions := range []int{1, 2, 3} {
+			checkSorter(t, sample, func(cfg *config.Config) sorter {
+				cfg.MemoryLimit *= partitions
+				return NewSampleSort(cfg, partitions)
+			})
+		}
+	}
+}
+
+func TestSampleSortHeader(t *testing.T) {
+	output, err := runSorter(t, "z header\nd\nb\ne\na\nc\n", func(cfg *config.Config) {
+		cfg.MemoryLimit = 21
+		cfg.Header = true
+	}, func(cfg *config.Config) sorter {
+		return NewSampleSort(cfg, 3)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "z header\na\nb\nc\nd\ne\n", output)
+}
+
+func TestSampleSortKeyFunc(t *testing.T) {
+	keys, err := config.ParseJSONKeys(".n:desc")
+	require.NoError(t, err)
+
+	var input, expected string
+	for i := 0; i < 30; i++ {
+		input += fmt.Sprintf("{\"n\": %d}\n", (i*7)%30)
+		expected += fmt.Sprintf("{\"n\": %d}\n", 29-i)
+	}
+
+	output, err := runSorter(t, input, func(cfg *config.Config) {
+		cfg.MemoryLimit = 90
+		cfg.KeyFunc = config.JSONKeyFunc(keys, config.MissingFirst)
+	}, func(cfg *config.Config) sorter {
+		return NewSampleSort(cfg, 3)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, expected, output)
+}
+
+func TestSampleSplittersKeyFunc(t *testing.T) {
+	keys, err := config.ParseJSONKeys(".n:desc")
+	require.NoError(t, err)
+
+	var input string
+	for i := 0; i < 100; i++ {
+		input += fmt.Sprintf("{\"n\": %d}\n", (i*37)%100)
+	}
+
+	splitters := func(input string) ([][]byte, error) {
+		path := filepath.Join(t.TempDir(), "input")
+		require.NoError(t, ioutil.WriteFile(path, []byte(input), 0644))
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer func() {
+			_ = f.Close()
+		}()
+
+		return SampleSplitters(f, &config.Config{
+			BlockSize: 16,
+			Delimiter: []byte("\n"),
+			KeyFunc:   config.JSONKeyFunc(keys, config.MissingError),
+		}, 4)
+	}
+
+	// Splitters are raw tokens in the order of their keys.
+	found, err := splitters(input)
+	require.NoError(t, err)
+	require.Len(t, found, 3)
+	assert.True(t, sort.SliceIsSorted(found, func(i, j int) bool {
+		var a, b struct{ N int }
+		require.NoError(t, json.Unmarshal(found[i], &a))
+		require.NoError(t, json.Unmarshal(found[j], &b))
+		return a.N > b.N
+	}), "%q", found)
+
+	// A sample whose key can't be extracted fails the sampling instead of being equal to any other token.
+	_, err = splitters(strings.Repeat("{\"n\": 1\n", 100))
+	assert.Error(t, err)
+}
+
+func TestSampleSortFixed(t *testing.T) {
+	const recordSize = 12
+
+	// Records are made of their index, a little-endian int32 key and a filler.
+	var input []byte
+	for i := 0; i < 300; i++ {
+		record := make([]byte, recordSize)
+		binary.BigEndian.PutUint32(record, uint32(i))
+		binary.LittleEndian.PutUint32(record[4:], uint32(int32((i*7919)%300-150)))
+		copy(record[8:], "abcd")
+
+		input = append(input, record...)
+	}
+
+	key := config.Key{Offset: 4, Length: 4, Type: config.KeyIntLE}
+	keyOf := func(record []byte) int32 {
+		return int32(binary.LittleEndian.Uint32(record[4:]))
+	}
+
+	configs := []struct {
+		configure func(cfg *config.Config)
+		less      func(a, b []byte) bool
+	}{
+		{
+			configure: func(cfg *config.Config) {
+				cfg.Less, cfg.Order = key.Comparator(recordSize, config.OrderASC)
+			},
+			less: func(a, b []byte) bool {
+				return keyOf(a) < keyOf(b)
+			},
+		},
+		{
+			configure: func(cfg *config.Config) {
+				cfg.KeyFunc = key.KeyFunc(config.OrderDESC)
+			},
+			less: func(a, b []byte) bool {
+				return keyOf(a) > keyOf(b)
+			},
+		},
+		{
+			configure: func(cfg *config.Config) {
+				cfg.Less, cfg.Order = config.LessASC, config.OrderASC
+			},
+			less: func(a, b []byte) bool {
+				return bytes.Compare(a, b) < 0
+			},
+		},
+	}
+
+	for i, c := range configs {
+		expected := append([]byte(nil), input...)
+		records := make([][]byte, 0, len(expected)/recordSize)
+		for j := 0; j < len(expected); j += recordSize {
+			records = append(records, expected[j:j+recordSize])
+		}
+		sort.Slice(records, func(a, b int) bool {
+			return c.less(records[a], records[b])
+		})
+		expected = bytes.Join(records, nil)
+
+		for _, partitions := range []int{1, 2, 3} {
+			output, err := runSorter(t, string(input), func(cfg *config.Config) {
+				cfg.BlockSize = 2 * recordSize
+				cfg.MemoryLimit = 600 * partitions
+				cfg.Framing = config.FramingFixed
+				cfg.RecordSize = recordSize
+				c.configure(cfg)
+			}, func(cfg *config.Config) sorter {
+				if partitions == 1 {
+					return NewExternalMergeSort(cfg)
+				}
+
+				return NewSampleSort(cfg, partitions)
+			})
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), output, "config %d, %d partitions", i, partitions)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package algo
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// ErrUnsorted is returned by MergeSets when an input isn't sorted by the configured comparator.
+var ErrUnsorted = errors.New("input isn't sorted")
+
+// SetOp is a set operation over sorted files.
+type SetOp int
+
+const (
+	// SetUnion keeps tokens found in any input.
+	SetUnion SetOp = iota
+
+	// SetIntersection keeps tokens found in all inputs.
+	SetIntersection
+
+	// SetDifference keeps tokens of the first input that aren't found in the others.
+	SetDifference
+
+	// SetSymmetricDifference keeps tokens found in exactly one input.
+	SetSymmetricDifference
+
+	// SetComm works like comm(1) for two inputs: tokens found in the first input only are written as is,
+	// tokens found in the second input only are prefixed with a tab, and tokens found in both are prefixed with two tabs.
+	SetComm
+)
+
+// ParseSetOp converts union, intersection, difference, symdiff and comm (case-insensitive) to the corresponding operation.
+func ParseSetOp(s string) (SetOp, error) {
+	switch strings.ToLower(s) {
+	case "union":
+		return SetUnion, nil
+
+	case "intersection":
+		return SetIntersection, nil
+
+	case "difference":
+		return SetDifference, nil
+
+	case "symdiff":
+		return SetSymmetricDifference, nil
+
+	case "comm":
+		return SetComm, nil
+
+	default:
+		return SetUnion, errors.Errorf("unknown set operation %s", s)
+	}
+}
+
+// MergeSets applies the set operation to the sorted input files in one streaming pass and writes the result to the output file.
+// Tokens are compared by keys extracted by cfg.KeyFunc or by cfg.Less, and equal tokens of one input are treated as one element.
+// When equal tokens are found in several inputs, the token of the first of them is written.
+// If cfg.Header is set, the first token of each input is skipped, and the header of the first input is written.
+func MergeSets(cfg *config.Config, op SetOp, inputPaths []string, outputPath string) error {
+	startedAt := time.Now()
+
+	if len(inputPaths) < 2 {
+		return errors.Errorf("at least two inputs are required, but %d were given", len(inputPaths))
+	}
+	if op == SetComm && len(inputPaths) != 2 {
+		return errors.Errorf("comm requires exactly two inputs, but %d were given", len(inputPaths))
+	}
+
+	// Cursors extract keys once per token, and keys are compared byte-wise.
+	less := cfg.Less
+	if cfg.KeyFunc != nil {
+		less = func(a, b []byte) bool {
+			return bytes.Compare(a, b) < 0
+		}
+	}
+
+	cursors := make([]*setCursor, 0, len(inputPaths))
+	defer func() {
+		for _, c := range cursors {
+			c.close()
+		}
+	}()
+
+	var header []byte
+	for i, path := range inputPaths {
+		c, err := newSetCursor(cfg, path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open input %s", path)
+		}
+		cursors = append(cursors, c)
+
+		if cfg.Header {
+			token, err := c.r.Next()
+			if err != nil && !errors.Is(err, io.EOF) {
+				return errors.Wrapf(err, "failed to read the header of %s", path)
+			}
+			if i == 0 && err == nil {
+				header = append([]byte(nil), token...)
+			}
+		}
+
+		err = c.advance(less)
+		if err != nil {
+			return err
+		}
+	}
+
+	output, err := os.OpenFile(outputPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create output file")
+	}
+	defer func() {
+		_ = output.Close()
+	}()
+
+	w := cfg.NewWriter(output, 0)
+	defer release(w)
+
+	if header != nil {
+		err = w.Write(header)
+		if err != nil {
+			return errors.Wrap(err, "write failed")
+		}
+	}
+
+	found := make([]bool, len(cursors))
+	var scratch []byte
+	for {
+		// The least current token is found. Ties are resolved in favor of the first input.
+		min := -1
+		for i, c := range cursors {
+			if !c.done && (min < 0 || less(c.key, cursors[min].key)) {
+				min = i
+			}
+		}
+		if min < 0 {
+			break
+		}
+
+		count := 0
+		for i, c := range cursors {
+			found[i] = !c.done && !less(cursors[min].key, c.key)
+			if found[i] {
+				count++
+			}
+		}
+
+		token := cursors[min].token
+		keep := false
+		switch op {
+		case SetUnion:
+			keep = true
+
+		case SetIntersection:
+			keep = count == len(cursors)
+
+		case SetDifference:
+			keep = found[0] && count == 1
+
+		case SetSymmetricDifference:
+			keep = count == 1
+
+		case SetComm:
+			keep = true
+			if found[1] {
+				scratch = append(scratch[:0], '\t')
+				if found[0] {
+					scratch = append(scratch, '\t')
+				}
+				token = append(scratch, token...)
+			}
+		}
+
+		if keep {
+			err = w.Write(token)
+			if err != nil {
+				return errors.Wrap(err, "write failed")
+			}
+		}
+
+		for i, c := range cursors {
+			if !found[i] {
+				continue
+			}
+
+			err = c.advance(less)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return errors.Wrap(err, "flush failed")
+	}
+
+	err = output.Sync()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("set operation finished in %v\n", time.Since(startedAt))
+
+	return nil
+}
+
+// setCursor reads distinct tokens of a sorted input.
+type setCursor struct {
+	file    *os.File
+	r       buffer.SectionReader
+	keyFunc config.KeyFunc
+
+	// token is a copy of the current token, key is its key or the token itself if there is no KeyFunc.
+	token []byte
+	key   []byte
+	count int64
+	done  bool
+
+	// next is the key of the token being read.
+	next []byte
+}
+
+func newSetCursor(cfg *config.Config, path string) (*setCursor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &setCursor{
+		file:    f,
+		r:       cfg.NewReader(f, 0, MaxInt64),
+		keyFunc: cfg.KeyFunc,
+	}, nil
+}
+
+// advance moves to the next token that isn't equal to the current one.
+// ErrUnsorted is returned if the next token is less than the current one.
+// less compares keys, see setCursor.key.
+func (c *setCursor) advance(less func(a, b []byte) bool) error {
+	for {
+		token, err := c.r.Next()
+		if errors.Is(err, io.EOF) {
+			c.done = true
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to read the next token of %s", c.file.Name())
+		}
+
+		c.count++
+
+		key := token
+		if c.keyFunc != nil {
+			c.next, err = c.keyFunc(c.next[:0], token)
+			if err != nil {
+				return errors.Wrapf(err, "%s: failed to extract the key of token %d", c.file.Name(), c.count)
+			}
+			key = c.next
+		}
+
+		if c.count > 1 {
+			if less(key, c.key) {
+				return errors.Wrapf(ErrUnsorted, "%s: token %d goes before the previous one", c.file.Name(), c.count)
+			}
+			if !less(c.key, key) {
+				continue
+			}
+		}
+
+		c.token = append(c.token[:0], token...)
+		if c.keyFunc != nil {
+			c.key, c.next = c.next, c.key
+		} else {
+			c.key = c.token
+		}
+
+		return nil
+	}
+}
+
+func (c *setCursor) close() {
+	c.r.Release()
+	_ = c.file.Close()
+}
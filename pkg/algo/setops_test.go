@@ -0,0 +1,94 @@
+package algo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeSets(t *testing.T) {
+	inputs := []string{"a\nb\nb\nc\ne\n", "b\nc\nc\nd\n"}
+
+	for op, expected := range map[SetOp]string{
+		SetUnion:               "a\nb\nc\nd\ne\n",
+		SetIntersection:        "b\nc\n",
+		SetDifference:          "a\ne\n",
+		SetSymmetricDifference: "a\nd\ne\n",
+		SetComm:                "a\n\t\tb\n\t\tc\n\td\ne\n",
+	} {
+		output, err := mergeSetsString(t, op, inputs, nil)
+		require.NoError(t, err)
+		assert.Equal(t, expected, output, "operation %d", op)
+	}
+
+	output, err := mergeSetsString(t, SetIntersection, []string{"a\nb\nc\n", "b\nc\n", "a\nc\n"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "c\n", output)
+
+	output, err = mergeSetsString(t, SetSymmetricDifference, []string{"a\nb\n", "b\nc\n", "c\nd\n"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "a\nd\n", output)
+
+	_, err = mergeSetsString(t, SetComm, []string{"a\n", "b\n", "c\n"}, nil)
+	assert.Error(t, err)
+}
+
+func TestMergeSetsUnsorted(t *testing.T) {
+	_, err := mergeSetsString(t, SetUnion, []string{"a\nc\n", "a\nc\nb\n"}, nil)
+	assert.True(t, errors.Is(err, ErrUnsorted), "unexpected error %v", err)
+
+	output, err := mergeSetsString(t, SetUnion, []string{"c\na\n", "d\nb\n"}, func(cfg *config.Config) {
+		cfg.Less = config.LessDESC
+		cfg.Order = config.OrderDESC
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "d\nc\nb\na\n", output)
+}
+
+func TestMergeSetsKeys(t *testing.T) {
+	keys, err := config.ParseJSONKeys(".id")
+	require.NoError(t, err)
+
+	inputs := []string{
+		"# a\n{\"id\": 1, \"v\": \"a\"}\n{\"id\": 2, \"v\": \"a\"}\n",
+		"# b\n{\"id\": 2, \"v\": \"b\"}\n{\"id\": 3, \"v\": \"b\"}\n",
+	}
+
+	output, err := mergeSetsString(t, SetUnion, inputs, func(cfg *config.Config) {
+		cfg.Header = true
+		cfg.KeyFunc = config.JSONKeyFunc(keys, config.MissingFirst)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "# a\n{\"id\": 1, \"v\": \"a\"}\n{\"id\": 2, \"v\": \"a\"}\n{\"id\": 3, \"v\": \"b\"}\n", output)
+
+	// Keys that can't be extracted fail the operation.
+	inputs[1] += "{\"v\": \"c\"}\n"
+	_, err = mergeSetsString(t, SetUnion, inputs, func(cfg *config.Config) {
+		cfg.Header = true
+		cfg.KeyFunc = config.JSONKeyFunc(keys, config.MissingError)
+	})
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrUnsorted))
+}
+
+func mergeSetsString(t *testing.T, op SetOp, inputs []string, configure func(cfg *config.Config)) (string, error) {
+	// The first input is written by runSorter, the others are written next to it.
+	return runSorter(t, inputs[0], configure, func(cfg *config.Config) sorter {
+		return sorterFunc(func(inputPath, outputPath, tempDir string) error {
+			inputPaths := []string{inputPath}
+			for i, input := range inputs[1:] {
+				path := filepath.Join(tempDir, "input"+strconv.Itoa(i+1))
+				require.NoError(t, ioutil.WriteFile(path, []byte(input), 0644))
+				inputPaths = append(inputPaths, path)
+			}
+
+			return MergeSets(cfg, op, inputPaths, outputPath)
+		})
+	})
+}
@@ -0,0 +1,119 @@
+package algo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+)
+
+// Phases of the sort reported by Stats.
+const (
+	PhaseWaiting       = "waiting for memory"
+	PhaseRunGeneration = "run generation"
+	PhaseMerge         = "merge"
+	PhaseGrouping      = "grouping"
+	PhasePartition     = "partition"
+	PhaseDone          = "done"
+)
+
+// How many tokens are processed between cancellation checks.
+const contextCheckPeriod = 1024
+
+// Stats describes the progress of a sort.
+type Stats struct {
+	Phase string
+
+	// InputSize is the number of bytes to sort, InputRead is how many of them have been read so far.
+	InputSize int64
+	InputRead int64
+
+	Tokens int64
+
+	// Runs is the number of runs produced by run generation, RunsLeft is the number of runs left to merge.
+	Runs     int
+	RunsLeft int
+
+	// Passes is the number of finished merge passes (or polyphase phases).
+	Passes int
+
+	// BytesWritten is the number of bytes written to temp files and the output.
+	BytesWritten int64
+
+	StartedAt time.Time
+	Elapsed   time.Duration
+}
+
+// Progress collects stats of one sort. It's safe to read them while the sort is running.
+type Progress struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx that makes a sort started with it report its stats to p.
+func WithProgress(ctx context.Context, p *Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+func progressFrom(ctx context.Context) *Progress {
+	if p, ok := ctx.Value(progressKey{}).(*Progress); ok {
+		return p
+	}
+
+	return new(Progress)
+}
+
+// Stats returns a snapshot of the sort progress.
+func (p *Progress) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.stats
+	if stats.Phase != PhaseDone && !stats.StartedAt.IsZero() {
+		stats.Elapsed = time.Since(stats.StartedAt)
+	}
+
+	return stats
+}
+
+func (p *Progress) update(update func(s *Stats)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	update(&p.stats)
+}
+
+func (m *mergeSortJob) updateStats(update func(s *Stats)) {
+	m.progress.update(update)
+}
+
+// contextWriter stops writing when the context is canceled.
+type contextWriter struct {
+	buffer.TokenWriter
+
+	ctx     context.Context
+	written int
+}
+
+func (w *contextWriter) Write(token []byte) error {
+	w.written++
+	if w.written%contextCheckPeriod == 0 {
+		err := w.ctx.Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.TokenWriter.Write(token)
+}
+
+func (w *contextWriter) Reset() {
+	startRun(w.TokenWriter)
+}
+
+func (w *contextWriter) Release() {
+	release(w.TokenWriter)
+}
@@ -0,0 +1,295 @@
+package algo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// TagSort sorts tags of tokens instead of the tokens themselves. A tag is the key of a token
+// and the position of the token in the input. Tags are sorted with ExternalMergeSort,
+// and then tokens are gathered from the input in the sorted order in one final pass.
+//
+// Merge passes move short tags instead of wide tokens, which saves a lot of disk writes
+// at the cost of random reads of the input during the gather pass.
+// Tokens are compared by cfg.KeyFunc, which must be set (see config.TokenKeyFunc and config.Key.KeyFunc).
+// Tokens with equal keys keep their input order.
+type TagSort struct {
+	cfg *config.Config
+}
+
+// Size of the position of a token in a tag: the offset, the length and the ordinal as big-endian uint64s.
+const tagPositionSize = 24
+
+func NewTagSort(cfg *config.Config) *TagSort {
+	return &TagSort{
+		cfg: cfg,
+	}
+}
+
+// Sort loads data from the input file, sorts it and saves result to the output file.
+func (s *TagSort) Sort(inputPath, outputPath, tempDir string) error {
+	return s.SortContext(context.Background(), inputPath, outputPath, tempDir)
+}
+
+// SortContext is like Sort, but the sort is stopped with an error when ctx is canceled.
+func (s *TagSort) SortContext(ctx context.Context, inputPath, outputPath, tempDir string) error {
+	startedAt := time.Now()
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open input file")
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+
+	sortedPath, header, err := s.sortTags(ctx, input, tempDir)
+	defer func() {
+		_ = os.Remove(sortedPath)
+	}()
+	if err != nil {
+		return err
+	}
+
+	err = s.gather(ctx, input, header, sortedPath, outputPath, tempDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to gather tokens")
+	}
+
+	log.Printf("tag sort finished in %v\n", time.Since(startedAt))
+
+	return nil
+}
+
+// sortTags writes tags of the input tokens to a temp file and sorts them.
+// The path of the file with sorted tags is returned, and the caller must remove it.
+func (s *TagSort) sortTags(ctx context.Context, input *os.File, tempDir string) (sortedPath string, header []byte, err error) {
+	if s.cfg.KeyFunc == nil {
+		return "", nil, errors.New("tag sort requires a key function")
+	}
+	if s.cfg.Combiner != nil {
+		return "", nil, errors.New("tag sort doesn't support combiners")
+	}
+
+	tags, err := os.CreateTemp(tempDir, tempPattern)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to create temp file")
+	}
+	defer removeFiles([]*os.File{tags})
+
+	header, err = s.writeTags(ctx, input, tags)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to extract tags")
+	}
+
+	sortedPath = tags.Name() + ".sorted"
+
+	err = NewExternalMergeSort(s.tagConfig()).SortContext(ctx, tags.Name(), sortedPath, tempDir)
+	if err != nil {
+		return sortedPath, nil, errors.Wrap(err, "failed to sort tags")
+	}
+
+	return sortedPath, header, nil
+}
+
+// tagConfig returns the config tags are sorted with.
+func (s *TagSort) tagConfig() *config.Config {
+	return &config.Config{
+		BlockSize:   s.cfg.BlockSize,
+		MemoryLimit: s.cfg.MemoryLimit,
+		Framing:     config.FramingVarint,
+		Less:        lessTags,
+		Order:       config.OrderCustom,
+		FrontCoding: s.cfg.FrontCoding,
+		Adaptive:    s.cfg.Adaptive,
+		RunSorter:   s.cfg.RunSorter,
+		Merger:      s.cfg.Merger,
+		Governor:    s.cfg.Governor,
+	}
+}
+
+// writeTags reads tokens of the input and writes their tags.
+// If cfg.Header is set, the first token is returned instead.
+func (s *TagSort) writeTags(ctx context.Context, input, tags *os.File) (header []byte, err error) {
+	startedAt := time.Now()
+
+	r := s.cfg.NewReader(input, 0, MaxInt64)
+	defer r.Release()
+
+	w := buffer.NewRecordWriter(tags, 0, s.cfg.BlockSize, buffer.PrefixVarint)
+	defer w.Release()
+
+	if s.cfg.Header {
+		header, err = r.Next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, errors.Wrap(err, "failed to read the header")
+		}
+	}
+
+	var key []byte
+	var count int64
+	for {
+		token, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the next token")
+		}
+
+		count++
+		if count%contextCheckPeriod == 0 {
+			err = ctx.Err()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		key, err = s.cfg.KeyFunc(key[:0], token)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to extract the key")
+		}
+
+		err = w.Write(newTag(key, r.TokenOffset(), len(token), count-1))
+		if err != nil {
+			return nil, errors.Wrap(err, "write failed")
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return nil, errors.Wrap(err, "flush failed")
+	}
+
+	log.Printf("%d tags extracted in %v\n\n", count, time.Since(startedAt))
+
+	return header, nil
+}
+
+// gather reads tokens from the input in the order of sorted tags and writes them to the output file.
+// The output is written to a temp file first, so the input file may be the output file.
+func (s *TagSort) gather(ctx context.Context, input *os.File, header []byte, tagsPath, outputPath, tempDir string) error {
+	startedAt := time.Now()
+
+	tags, err := os.Open(tagsPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tags.Close()
+	}()
+
+	output, err := os.CreateTemp(tempDir, tempPattern)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = output.Close()
+		_ = os.Remove(output.Name())
+	}()
+
+	r := buffer.NewRecordReader(tags, 0, MaxInt64, s.cfg.BlockSize, buffer.PrefixVarint)
+	defer r.Release()
+
+	w := &contextWriter{
+		TokenWriter: s.cfg.NewWriter(output, 0),
+		ctx:         ctx,
+	}
+	defer w.Release()
+
+	if header != nil {
+		err = w.Write(header)
+		if err != nil {
+			return errors.Wrap(err, "write failed")
+		}
+	}
+
+	var token []byte
+	for {
+		tag, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read the next tag")
+		}
+
+		offset, length, _ := tagPosition(tag)
+		if cap(token) < length {
+			token = make([]byte, length)
+		}
+		token = token[:length]
+
+		_, err = input.ReadAt(token, offset)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read the token at offset %d", offset)
+		}
+
+		err = w.Write(token)
+		if err != nil {
+			return errors.Wrap(err, "write failed")
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return errors.Wrap(err, "flush failed")
+	}
+
+	err = output.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(output.Name(), outputPath)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("tokens gathered in %v\n\n", time.Since(startedAt))
+
+	return nil
+}
+
+// newTag encodes the key and the position of a token: uvarint(len(key)) + key + offset + length + ordinal.
+func newTag(key []byte, offset int64, length int, ordinal int64) []byte {
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(key)))
+
+	tag := make([]byte, n+len(key)+tagPositionSize)
+	copy(tag, prefix[:n])
+	copy(tag[n:], key)
+	binary.BigEndian.PutUint64(tag[n+len(key):], uint64(offset))
+	binary.BigEndian.PutUint64(tag[n+len(key)+8:], uint64(length))
+	binary.BigEndian.PutUint64(tag[n+len(key)+16:], uint64(ordinal))
+
+	return tag
+}
+
+// tagPosition returns the offset, the length and the ordinal of the token the tag was made for.
+func tagPosition(tag []byte) (offset int64, length int, ordinal int64) {
+	position := tag[len(tag)-tagPositionSize:]
+
+	return int64(binary.BigEndian.Uint64(position)), int(binary.BigEndian.Uint64(position[8:])), int64(binary.BigEndian.Uint64(position[16:]))
+}
+
+// lessTags compares tags by keys. Tokens with equal keys are ordered by their offsets.
+func lessTags(a, b []byte) bool {
+	keyA, positionA := config.SplitKey(a)
+	keyB, positionB := config.SplitKey(b)
+
+	if c := bytes.Compare(keyA, keyB); c != 0 {
+		return c < 0
+	}
+
+	return bytes.Compare(positionA, positionB) < 0
+}
@@ -0,0 +1,106 @@
+package algo
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagSort(t *testing.T) {
+	for _, sample := range samples {
+		checkSorter(t, sample, func(cfg *config.Config) sorter {
+			cfg.MemoryLimit = 60
+			cfg.KeyFunc = config.TokenKeyFunc(config.OrderASC)
+			return NewTagSort(cfg)
+		})
+	}
+}
+
+func TestTagSortKeys(t *testing.T) {
+	keys, err := config.ParseJSONKeys(".k")
+	require.NoError(t, err)
+
+	output := tagSortString(t, "# events\n{\"k\": 2, \"v\": 1}\n{\"k\": 1}\n{\"k\": 2, \"v\": 2}\n{\"k\": 0}\n{\"k\": 2, \"v\": 3}", func(cfg *config.Config) {
+		cfg.Header = true
+		cfg.KeyFunc = config.JSONKeyFunc(keys, config.MissingFirst)
+	})
+	assert.Equal(t, "# events\n{\"k\": 0}\n{\"k\": 1}\n{\"k\": 2, \"v\": 1}\n{\"k\": 2, \"v\": 2}\n{\"k\": 2, \"v\": 3}\n", output)
+
+	output = tagSortString(t, "b\r\nab\na\r\n", func(cfg *config.Config) {
+		cfg.CRLF = config.CRLFPreserve
+		cfg.KeyFunc = config.TokenKeyFunc(config.OrderDESC)
+	})
+	assert.Equal(t, "b\r\nab\r\na\r\n", output)
+
+	var records []byte
+	for _, v := range []int32{7, -3, 100, 0} {
+		record := make([]byte, 6)
+		binary.LittleEndian.PutUint32(record[2:], uint32(v))
+		records = append(records, record...)
+	}
+
+	key := config.Key{Offset: 2, Length: 4, Type: config.KeyIntLE}
+	output = tagSortString(t, string(records), func(cfg *config.Config) {
+		cfg.Framing = config.FramingFixed
+		cfg.RecordSize = 6
+		cfg.KeyFunc = key.KeyFunc(config.OrderDESC)
+	})
+
+	var sorted []int32
+	for i := 0; i < len(output); i += 6 {
+		sorted = append(sorted, int32(binary.LittleEndian.Uint32([]byte(output[i+2:i+6]))))
+	}
+	assert.Equal(t, []int32{100, 7, 0, -3}, sorted)
+}
+
+// tagSortString sorts the input with TagSort and the default test configuration adjusted by configure.
+func tagSortString(t *testing.T, input string, configure func(cfg *config.Config)) string {
+	output, err := runSorter(t, input, func(cfg *config.Config) {
+		cfg.MemoryLimit = 60
+		configure(cfg)
+	}, func(cfg *config.Config) sorter {
+		return NewTagSort(cfg)
+	})
+	require.NoError(t, err)
+
+	return output
+}
+
+func TestArgsort(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		BlockSize:   2,
+		MemoryLimit: 60,
+		Delimiter:   []byte("\n"),
+		Header:      true,
+		KeyFunc:     config.TokenKeyFunc(config.OrderASC),
+	}
+
+	inputPath := filepath.Join(dir, "input")
+	require.NoError(t, ioutil.WriteFile(inputPath, []byte("header\nc\na\nbb\na\n"), 0644))
+
+	cases := []struct {
+		index    Index
+		format   IndexFormat
+		expected []byte
+	}{
+		{IndexOrdinal, IndexText, []byte("1\n3\n2\n0\n")},
+		{IndexOffset, IndexText, []byte("9\n14\n11\n7\n")},
+		{IndexOrdinal, IndexBinary, []byte{1, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, c := range cases {
+		outputPath := filepath.Join(dir, "output")
+		require.NoError(t, NewTagSort(cfg).Argsort(context.Background(), inputPath, outputPath, dir, c.index, c.format))
+
+		output, err := ioutil.ReadFile(outputPath)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, output)
+	}
+}
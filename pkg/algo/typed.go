@@ -0,0 +1,264 @@
+package algo
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// Codec converts values to bytes and back.
+// Decode must accept everything produced by Encode.
+type Codec[T any] interface {
+	// Encode appends the encoded value to dst and returns the extended slice.
+	Encode(dst []byte, v T) []byte
+
+	Decode(data []byte) (T, error)
+}
+
+// ExternalSorter sorts values of type T that don't fit into main memory.
+//
+// Values are encoded by the codec and stored in temp files as length-prefixed records,
+// so the run and merge machinery of ExternalMergeSort is reused as is.
+// Runs are sorted by decoded values, merges decode each record once when it becomes the head of its run.
+type ExternalSorter[T any] struct {
+	cfg   config.Config
+	codec Codec[T]
+	less  func(a, b T) bool
+}
+
+// NewExternalSorter creates a sorter. Only BlockSize, MemoryLimit, FrontCoding, Merger and Governor of cfg are used.
+// The other fields, such as KeyFunc, Combiner, Header and Adaptive, are ignored,
+// as values are stored as length-prefixed records and compared by less only.
+func NewExternalSorter[T any](cfg *config.Config, codec Codec[T], less func(a, b T) bool) *ExternalSorter[T] {
+	return &ExternalSorter[T]{
+		cfg: config.Config{
+			BlockSize:   cfg.BlockSize,
+			MemoryLimit: cfg.MemoryLimit,
+			Framing:     config.FramingVarint,
+			Order:       config.OrderCustom,
+			FrontCoding: cfg.FrontCoding,
+			Merger:      cfg.Merger,
+			Governor:    cfg.Governor,
+		},
+		codec: codec,
+		less:  less,
+	}
+}
+
+// Sort sorts values returned by next until it returns io.EOF.
+// The sorted values are returned by the iterator, which must be closed.
+// If the codec fails to decode a record, the error is returned by Sort or by the iterator.
+func (s *ExternalSorter[T]) Sort(ctx context.Context, next func() (T, error), tempDir string) (*TypedIterator[T], error) {
+	inputPath, err := s.writeInput(next, tempDir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := os.Remove(inputPath)
+		if err != nil {
+			log.Printf("failed to remove temp input file: %v\n", err)
+		}
+	}()
+
+	// Comparisons of one sort share the cache of decoded records.
+	d := &typedSort[T]{
+		s:     s,
+		cache: make(map[*byte]decodedRecord[T]),
+	}
+
+	cfg := s.cfg
+	cfg.Less = d.less
+	cfg.RunSorter = typedRunSorter[T]{d}
+
+	// Iterate reads the whole input before it returns, so the input can be removed right after.
+	it, err := NewExternalMergeSort(&cfg).Iterate(ctx, inputPath, tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.err != nil {
+		_ = it.Close()
+		return nil, d.err
+	}
+
+	return &TypedIterator[T]{
+		it:   it,
+		sort: d,
+	}, nil
+}
+
+// writeInput encodes values to a temp file.
+func (s *ExternalSorter[T]) writeInput(next func() (T, error), tempDir string) (path string, err error) {
+	file, err := os.CreateTemp(tempDir, tempPattern)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temp file")
+	}
+	defer func() {
+		_ = file.Close()
+		if err != nil {
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	w := s.cfg.NewWriter(file, 0)
+	defer release(w)
+
+	var data []byte
+	for {
+		v, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "failed to get the next value")
+		}
+
+		data = s.codec.Encode(data[:0], v)
+
+		err = w.Write(data)
+		if err != nil {
+			return "", errors.Wrap(err, "write failed")
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return "", errors.Wrap(err, "flush failed")
+	}
+
+	return file.Name(), nil
+}
+
+// typedSort is the state of one sort. Decoded values of recently compared records are cached,
+// so a merge decodes the head of each run once instead of on every comparison.
+type typedSort[T any] struct {
+	s     *ExternalSorter[T]
+	cache map[*byte]decodedRecord[T]
+
+	// err is the first decoding error. Comparisons can't fail, so it's checked after them.
+	err error
+}
+
+type decodedRecord[T any] struct {
+	token []byte
+	value T
+}
+
+// maxDecodeCache limits the number of cached records. The cache is cleared when it's full.
+const maxDecodeCache = 1024
+
+// less compares encoded values.
+func (d *typedSort[T]) less(a, b []byte) bool {
+	return d.s.less(d.decode(a), d.decode(b))
+}
+
+// decode returns the decoded value of the record.
+// Readers allocate each record, so a record is identified by its first byte while it's cached.
+func (d *typedSort[T]) decode(token []byte) T {
+	if len(token) > 0 {
+		if r, exists := d.cache[&token[0]]; exists && len(r.token) == len(token) {
+			return r.value
+		}
+	}
+
+	v, err := d.s.codec.Decode(token)
+	if err != nil {
+		if d.err == nil {
+			d.err = errors.Wrap(err, "codec failed to decode its own record")
+		}
+
+		return v
+	}
+
+	if len(token) > 0 {
+		if len(d.cache) >= maxDecodeCache {
+			d.cache = make(map[*byte]decodedRecord[T])
+		}
+		d.cache[&token[0]] = decodedRecord[T]{
+			token: token,
+			value: v,
+		}
+	}
+
+	return v
+}
+
+// typedRunSorter decodes every record of a run once and sorts them by decoded values.
+type typedRunSorter[T any] struct {
+	d *typedSort[T]
+}
+
+func (r typedRunSorter[T]) SortRun(tokens [][]byte, _ *config.Config) {
+	type record struct {
+		value T
+		token []byte
+	}
+
+	records := make([]record, len(tokens))
+	for i, token := range tokens {
+		v, err := r.d.s.codec.Decode(token)
+		if err != nil && r.d.err == nil {
+			r.d.err = errors.Wrap(err, "codec failed to decode its own record")
+		}
+
+		records[i] = record{
+			value: v,
+			token: token,
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return r.d.s.less(records[i].value, records[j].value)
+	})
+
+	for i := range records {
+		tokens[i] = records[i].token
+	}
+}
+
+// TypedIterator returns values sorted by ExternalSorter one by one.
+type TypedIterator[T any] struct {
+	it   *Iterator
+	sort *typedSort[T]
+}
+
+// Next returns the next value in the sorted order.
+// If no values are left, io.EOF is returned.
+func (t *TypedIterator[T]) Next() (T, error) {
+	token, err := t.it.Next()
+	if err == nil {
+		err = t.sort.err
+	}
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return t.sort.s.codec.Decode(token)
+}
+
+// Close removes temp files and gives the memory back.
+func (t *TypedIterator[T]) Close() error {
+	return t.it.Close()
+}
+
+// SliceSource returns a function that returns values one by one and io.EOF after the last one.
+// It can be passed to ExternalSorter.Sort.
+func SliceSource[T any](values []T) func() (T, error) {
+	return func() (T, error) {
+		if len(values) == 0 {
+			var zero T
+			return zero, io.EOF
+		}
+
+		v := values[0]
+		values = values[1:]
+
+		return v, nil
+	}
+}
@@ -0,0 +1,209 @@
+package algo
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type event struct {
+	user string
+	ts   int64
+}
+
+// eventCodec stores ts as a varint followed by user.
+type eventCodec struct{}
+
+func (eventCodec) Encode(dst []byte, e event) []byte {
+	var ts [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(ts[:], e.ts)
+
+	return append(append(dst, ts[:n]...), e.user...)
+}
+
+func (eventCodec) Decode(data []byte) (event, error) {
+	ts, n := binary.Varint(data)
+	if n <= 0 {
+		return event{}, errors.New("invalid ts")
+	}
+
+	return event{
+		user: string(data[n:]),
+		ts:   ts,
+	}, nil
+}
+
+func lessEvent(a, b event) bool {
+	if a.user != b.user {
+		return a.user < b.user
+	}
+
+	return a.ts < b.ts
+}
+
+func TestExternalSorter(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+
+	events := make([]event, 2000)
+	for i := range events {
+		// Users contain the newline to make sure that records aren't split by the delimiter.
+		events[i] = event{
+			user: string([]byte{'a' + byte(rnd.Intn(5)), '\n', byte(rnd.Intn(256))}),
+			ts:   rnd.Int63n(1000) - 500,
+		}
+	}
+
+	configs := []func(cfg *config.Config){
+		func(cfg *config.Config) {},
+		func(cfg *config.Config) {
+			cfg.Merger = KWayMerger{}
+			cfg.FrontCoding = true
+		},
+		func(cfg *config.Config) {
+			cfg.Merger = PolyphaseMerger{}
+			cfg.Adaptive = true
+		},
+		func(cfg *config.Config) {
+			// Settings of tokens don't apply to encoded values.
+			cfg.Header = true
+			cfg.KeyFunc = config.TokenKeyFunc(config.OrderDESC)
+			cfg.Combiner = &config.Combiner{
+				Combine: func(dst, a, b []byte) ([]byte, error) {
+					return append(dst, a...), nil
+				},
+			}
+		},
+	}
+
+	expected := append([]event(nil), events...)
+	sort.SliceStable(expected, func(i, j int) bool {
+		return lessEvent(expected[i], expected[j])
+	})
+
+	for _, configure := range configs {
+		cfg := &config.Config{
+			BlockSize:   16,
+			MemoryLimit: 1000,
+			Delimiter:   []byte("\n"),
+		}
+		configure(cfg)
+
+		it, err := NewExternalSorter[event](cfg, eventCodec{}, lessEvent).Sort(context.Background(), SliceSource(events), t.TempDir())
+		require.NoError(t, err)
+
+		var sorted []event
+		for {
+			e, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			sorted = append(sorted, e)
+		}
+		require.NoError(t, it.Close())
+
+		// Equal events are indistinguishable, so the order is checked by values.
+		assert.Equal(t, expected, sorted)
+	}
+}
+
+// countingCodec counts decoded records.
+type countingCodec struct {
+	eventCodec
+	decodes *int
+}
+
+func (c countingCodec) Decode(data []byte) (event, error) {
+	*c.decodes++
+
+	return c.eventCodec.Decode(data)
+}
+
+func TestExternalSorterDecodes(t *testing.T) {
+	events := make([]event, 2000)
+	for i := range events {
+		events[i] = event{
+			user: string([]byte{'a' + byte(i%7)}),
+			ts:   int64(len(events) - i),
+		}
+	}
+
+	cfg := &config.Config{
+		BlockSize:   16,
+		MemoryLimit: 1000,
+		Delimiter:   []byte("\n"),
+		Merger:      KWayMerger{},
+	}
+
+	var decodes int
+	it, err := NewExternalSorter[event](cfg, countingCodec{decodes: &decodes}, lessEvent).Sort(context.Background(), SliceSource(events), t.TempDir())
+	require.NoError(t, err)
+
+	var sorted int
+	for {
+		_, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		sorted++
+	}
+	require.NoError(t, it.Close())
+	assert.Equal(t, len(events), sorted)
+
+	// Records are decoded once by the run sorter, once per merge and once by the iterator.
+	assert.LessOrEqual(t, decodes, 6*len(events))
+}
+
+// brokenCodec fails to decode events of the user "broken".
+type brokenCodec struct {
+	eventCodec
+}
+
+func (c brokenCodec) Decode(data []byte) (event, error) {
+	e, err := c.eventCodec.Decode(data)
+	if err == nil && e.user == "broken" {
+		return event{}, errors.New("broken record")
+	}
+
+	return e, err
+}
+
+func TestExternalSorterDecodeError(t *testing.T) {
+	events := make([]event, 500)
+	for i := range events {
+		events[i] = event{
+			user: string([]byte{'a' + byte(i%5)}),
+			ts:   int64(i),
+		}
+	}
+	events[250].user = "broken"
+
+	cfg := &config.Config{
+		BlockSize:   16,
+		MemoryLimit: 1000,
+		Delimiter:   []byte("\n"),
+	}
+
+	it, err := NewExternalSorter[event](cfg, brokenCodec{}, lessEvent).Sort(context.Background(), SliceSource(events), t.TempDir())
+	if err == nil {
+		for err == nil {
+			_, err = it.Next()
+		}
+		require.NoError(t, it.Close())
+	}
+
+	assert.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+	assert.Contains(t, err.Error(), "broken record")
+}
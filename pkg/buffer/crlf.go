@@ -0,0 +1,21 @@
+package buffer
+
+import (
+	"bytes"
+)
+
+// CRTrimReader removes one "\r" at the end of tokens read by Reader,
+// so lines ended with "\r\n" are read like lines ended with "\n".
+// Other "\r" bytes are a part of the token, e.g. "c\r\r\n" is read as "c\r".
+type CRTrimReader struct {
+	*Reader
+}
+
+func (r CRTrimReader) Next() ([]byte, error) {
+	token, err := r.Reader.Next()
+	if token != nil {
+		token = bytes.TrimSuffix(token, []byte("\r"))
+	}
+
+	return token, err
+}
@@ -0,0 +1,65 @@
+package buffer
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// CSVReader reads CSV records (RFC 4180). A line break inside a quoted field doesn't end the record,
+// so a record may span several lines. Records are returned as is, without the trailing line break.
+type CSVReader struct {
+	r *Reader
+
+	tokenOffset int64
+}
+
+func NewCSVReader(f *os.File, offset, endOffset int64, capacity int) *CSVReader {
+	return &CSVReader{
+		r: NewReader(f, offset, endOffset, capacity, []byte("\n")),
+	}
+}
+
+// Next returns the next record.
+// If no records are left, (nil, io.EOF) is returned.
+// If the input ends inside a quoted field, io.ErrUnexpectedEOF is returned.
+func (r *CSVReader) Next() ([]byte, error) {
+	record, err := r.r.Next()
+	if err != nil {
+		return nil, err
+	}
+	r.tokenOffset = r.r.TokenOffset()
+
+	// Escaped quotes are doubled, so a quoted field is open while the number of quotes is odd.
+	quotes := bytes.Count(record, []byte{'"'})
+	for quotes%2 != 0 {
+		line, err := r.r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		record = append(append(record, '\n'), line...)
+		quotes += bytes.Count(line, []byte{'"'})
+	}
+
+	return record, nil
+}
+
+// Offset returns the file offset the next record begins at.
+func (r *CSVReader) Offset() int64 {
+	return r.r.Offset()
+}
+
+func (r *CSVReader) TokenOffset() int64 {
+	return r.tokenOffset
+}
+
+// Release returns the block buffer to the pool. The reader must not be used after that.
+func (r *CSVReader) Release() {
+	r.r.Release()
+}
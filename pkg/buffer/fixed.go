@@ -0,0 +1,95 @@
+package buffer
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// FixedWriter writes records of the same size one after another, without delimiters or length prefixes.
+type FixedWriter struct {
+	w          *Writer
+	recordSize int
+}
+
+func NewFixedWriter(file *os.File, offset int64, capacity, recordSize int) *FixedWriter {
+	return &FixedWriter{
+		w:          NewWriter(file, offset, capacity, nil),
+		recordSize: recordSize,
+	}
+}
+
+func (w *FixedWriter) Write(token []byte) error {
+	if len(token) != w.recordSize {
+		return errors.Errorf("record of %d bytes doesn't match the record size %d", len(token), w.recordSize)
+	}
+
+	return w.w.writeBytes(token)
+}
+
+func (w *FixedWriter) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *FixedWriter) Offset() int64 {
+	return w.w.Offset()
+}
+
+// Release returns the block buffer to the pool. The writer must not be used after that.
+func (w *FixedWriter) Release() {
+	w.w.Release()
+}
+
+// FixedReader reads records written by FixedWriter.
+type FixedReader struct {
+	r          *Reader
+	recordSize int
+
+	tokenOffset int64
+}
+
+func NewFixedReader(f *os.File, offset, endOffset int64, capacity, recordSize int) *FixedReader {
+	return &FixedReader{
+		r:          NewReader(f, offset, endOffset, capacity, nil),
+		recordSize: recordSize,
+	}
+}
+
+// Next returns the next record.
+// If no records are left, (nil, io.EOF) is returned.
+// If the section ends in the middle of a record, io.ErrUnexpectedEOF is returned.
+func (r *FixedReader) Next() ([]byte, error) {
+	r.tokenOffset = r.r.Offset()
+	first, err := r.r.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, r.recordSize)
+	token[0] = first
+
+	err = r.r.readFull(token[1:])
+	if errors.Is(err, io.EOF) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Offset returns the file offset of the next unread byte.
+func (r *FixedReader) Offset() int64 {
+	return r.r.Offset()
+}
+
+func (r *FixedReader) TokenOffset() int64 {
+	return r.tokenOffset
+}
+
+// Release returns the block buffer to the pool. The reader must not be used after that.
+func (r *FixedReader) Release() {
+	r.r.Release()
+}
@@ -0,0 +1,143 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// FrontCodedWriter writes sorted tokens in the front-coded (prefix-compressed) format.
+// Each token is stored as the length of the prefix shared with the previous token,
+// the length of the remaining suffix and the suffix itself (both lengths are uvarints).
+type FrontCodedWriter struct {
+	w *Writer
+
+	prev    []byte
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func NewFrontCodedWriter(file *os.File, offset int64, capacity int) *FrontCodedWriter {
+	return &FrontCodedWriter{
+		w: NewWriter(file, offset, capacity, nil),
+	}
+}
+
+func (w *FrontCodedWriter) Write(token []byte) error {
+	shared := commonPrefixLength(w.prev, token)
+
+	err := w.writeUvarint(uint64(shared))
+	if err != nil {
+		return err
+	}
+
+	err = w.writeUvarint(uint64(len(token) - shared))
+	if err != nil {
+		return err
+	}
+
+	err = w.w.writeBytes(token[shared:])
+	if err != nil {
+		return err
+	}
+
+	w.prev = append(w.prev[:0], token...)
+
+	return nil
+}
+
+// Reset forgets the previous token, so the next token is the first token of a new run.
+func (w *FrontCodedWriter) Reset() {
+	w.prev = w.prev[:0]
+}
+
+func (w *FrontCodedWriter) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *FrontCodedWriter) Offset() int64 {
+	return w.w.Offset()
+}
+
+// Release returns the block buffer to the pool. The writer must not be used after that.
+func (w *FrontCodedWriter) Release() {
+	w.w.Release()
+}
+
+func (w *FrontCodedWriter) writeUvarint(x uint64) error {
+	n := binary.PutUvarint(w.scratch[:], x)
+
+	return w.w.writeBytes(w.scratch[:n])
+}
+
+// FrontCodedReader reads a run written by FrontCodedWriter and restores full tokens.
+type FrontCodedReader struct {
+	r *Reader
+
+	prev []byte
+}
+
+func NewFrontCodedReader(f *os.File, offset, endOffset int64, capacity int) *FrontCodedReader {
+	return &FrontCodedReader{
+		r: NewReader(f, offset, endOffset, capacity, nil),
+	}
+}
+
+// Next returns the next restored token.
+// If no tokens are left, (nil, io.EOF) is returned.
+func (r *FrontCodedReader) Next() ([]byte, error) {
+	shared, err := r.r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	suffixLength, err := r.r.readUvarint()
+	if errors.Is(err, io.EOF) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if shared > uint64(len(r.prev)) {
+		return nil, errors.Errorf("corrupted run: shared prefix %d is longer than the previous token", shared)
+	}
+
+	token := make([]byte, shared+suffixLength)
+	copy(token, r.prev[:shared])
+
+	for i := shared; i < uint64(len(token)); i++ {
+		token[i], err = r.r.readByte()
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.prev = token
+
+	return token, nil
+}
+
+// Release returns the block buffer to the pool. The reader must not be used after that.
+func (r *FrontCodedReader) Release() {
+	r.r.Release()
+}
+
+func commonPrefixLength(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	return n
+}
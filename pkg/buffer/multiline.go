@@ -0,0 +1,109 @@
+package buffer
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// MultilineReader reads records that span several lines.
+// If start is set, a record begins at a line for which start returns true and lasts until the next such line.
+// Lines before the first start line form a record of their own.
+// If start is nil, records are paragraphs separated by one or more blank lines.
+// Records are returned without the trailing line break.
+type MultilineReader struct {
+	r     *Reader
+	start func(line []byte) bool
+
+	// The first line of the next record, read ahead while the previous record was collected.
+	pending       []byte
+	pendingOffset int64
+	hasPending    bool
+
+	tokenOffset int64
+}
+
+func NewMultilineReader(f *os.File, offset, endOffset int64, capacity int, start func(line []byte) bool) *MultilineReader {
+	return &MultilineReader{
+		r:     NewReader(f, offset, endOffset, capacity, []byte("\n")),
+		start: start,
+	}
+}
+
+// Next returns the next record.
+// If no records are left, (nil, io.EOF) is returned.
+func (r *MultilineReader) Next() ([]byte, error) {
+	first, err := r.firstLine()
+	if err != nil {
+		return nil, err
+	}
+
+	record := first
+	for {
+		line, err := r.r.Next()
+		if errors.Is(err, io.EOF) {
+			return record, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if r.start == nil && isBlank(line) {
+			return record, nil
+		}
+
+		if r.start != nil && r.start(line) {
+			r.pending, r.pendingOffset, r.hasPending = line, r.r.TokenOffset(), true
+			return record, nil
+		}
+
+		record = append(append(record, '\n'), line...)
+	}
+}
+
+// firstLine returns the line the next record begins with.
+// Blank lines between paragraphs are skipped.
+func (r *MultilineReader) firstLine() ([]byte, error) {
+	if r.hasPending {
+		r.hasPending = false
+		r.tokenOffset = r.pendingOffset
+		return r.pending, nil
+	}
+
+	for {
+		line, err := r.r.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if r.start != nil || !isBlank(line) {
+			r.tokenOffset = r.r.TokenOffset()
+			return line, nil
+		}
+	}
+}
+
+// Offset returns the file offset the next record begins at.
+func (r *MultilineReader) Offset() int64 {
+	if r.hasPending {
+		return r.pendingOffset
+	}
+
+	return r.r.Offset()
+}
+
+func (r *MultilineReader) TokenOffset() int64 {
+	return r.tokenOffset
+}
+
+// Release returns the block buffer to the pool. The reader must not be used after that.
+func (r *MultilineReader) Release() {
+	r.r.Release()
+}
+
+// isBlank reports whether the line is empty or consists of "\r" only.
+func isBlank(line []byte) bool {
+	return len(bytes.TrimRight(line, "\r")) == 0
+}
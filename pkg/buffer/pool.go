@@ -0,0 +1,32 @@
+package buffer
+
+import (
+	"sync"
+)
+
+// pools keeps free block buffers by their size, so readers and writers of short merge steps
+// and concurrent sorts reuse blocks instead of allocating new ones.
+var pools sync.Map
+
+func getBlock(size int) []byte {
+	p, ok := pools.Load(size)
+	if !ok {
+		p, _ = pools.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				return make([]byte, size)
+			},
+		})
+	}
+
+	return p.(*sync.Pool).Get().([]byte)
+}
+
+func putBlock(buf []byte) {
+	if buf == nil {
+		return
+	}
+
+	if p, ok := pools.Load(cap(buf)); ok {
+		p.(*sync.Pool).Put(buf[:cap(buf)])
+	}
+}
@@ -1,12 +1,16 @@
 package buffer
 
 import (
+	"bytes"
+	"encoding/binary"
 	"io"
 	"os"
+
+	"github.com/pkg/errors"
 )
 
 type Reader struct {
-	file      *os.File
+	file      io.ReaderAt
 	metEOF    bool
 	offset    int64
 	endOffset int64
@@ -15,16 +19,24 @@ type Reader struct {
 	bufLen   int
 	buf      []byte
 
-	delimiter byte
+	delimiter []byte
+
+	tokenOffset int64
+}
+
+// NewReader creates a reader of tokens separated by the delimiter, which may be several bytes long.
+func NewReader(f *os.File, offset, endOffset int64, capacity int, delimiter []byte) *Reader {
+	return NewReaderAt(f, offset, endOffset, capacity, delimiter)
 }
 
-func NewReader(f *os.File, offset, endOffset int64, capacity int, delimiter byte) *Reader {
+// NewReaderAt is like NewReader, but bytes are read from any io.ReaderAt, e.g. a file on another machine.
+func NewReaderAt(f io.ReaderAt, offset, endOffset int64, capacity int, delimiter []byte) *Reader {
 	return &Reader{
 		file:      f,
 		metEOF:    false,
 		offset:    offset,
 		endOffset: endOffset,
-		buf:       make([]byte, capacity), // TODO: sync.Pool
+		buf:       getBlock(capacity),
 		delimiter: delimiter,
 	}
 }
@@ -37,6 +49,7 @@ func (r *Reader) Next() (token []byte, err error) {
 		return nil, io.EOF
 	}
 
+	r.tokenOffset = r.Offset()
 	data := make([]byte, 0, 8)
 
 	for {
@@ -58,20 +71,108 @@ func (r *Reader) Next() (token []byte, err error) {
 		c := r.buf[r.bufIndex]
 		r.bufIndex++
 
-		if c == r.delimiter {
+		data = append(data, c)
+
+		last := len(r.delimiter) - 1
+		if last >= 0 && c == r.delimiter[last] && bytes.HasSuffix(data, r.delimiter) {
+			data = data[:len(data)-len(r.delimiter)]
 			break
 		}
-
-		data = append(data, c)
 	}
 
 	return data, nil
 }
 
+// Offset returns the file offset of the next unread byte.
+func (r *Reader) Offset() int64 {
+	return r.offset - int64(r.bufLen-r.bufIndex)
+}
+
+// TokenOffset returns the file offset of the token returned by the latest Next call.
+func (r *Reader) TokenOffset() int64 {
+	return r.tokenOffset
+}
+
 func (r *Reader) EOF() bool {
 	return r.metEOF && r.bufIndex == r.bufLen
 }
 
+// readByte returns the next byte of the section.
+// If no bytes are left, io.EOF is returned.
+func (r *Reader) readByte() (byte, error) {
+	if r.bufIndex == r.bufLen {
+		if r.metEOF {
+			return 0, io.EOF
+		}
+
+		err := r.read()
+		if err != nil {
+			return 0, err
+		}
+
+		if r.bufIndex == r.bufLen {
+			return 0, io.EOF
+		}
+	}
+
+	c := r.buf[r.bufIndex]
+	r.bufIndex++
+
+	return c, nil
+}
+
+// readFull fills dst with the next bytes of the section.
+// io.EOF is returned if the section ends earlier.
+func (r *Reader) readFull(dst []byte) error {
+	for len(dst) > 0 {
+		if r.bufIndex == r.bufLen {
+			if r.metEOF {
+				return io.EOF
+			}
+
+			err := r.read()
+			if err != nil {
+				return err
+			}
+
+			if r.bufIndex == r.bufLen {
+				return io.EOF
+			}
+		}
+
+		n := copy(dst, r.buf[r.bufIndex:r.bufLen])
+		r.bufIndex += n
+		dst = dst[n:]
+	}
+
+	return nil
+}
+
+// readUvarint reads an unsigned varint encoded by binary.PutUvarint.
+// io.EOF is returned only if no bytes of the number have been read.
+func (r *Reader) readUvarint() (uint64, error) {
+	var x uint64
+	var shift uint
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		b, err := r.readByte()
+		if errors.Is(err, io.EOF) && i > 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if b < 0x80 {
+			return x | uint64(b)<<shift, nil
+		}
+
+		x |= uint64(b&0x7f) << shift
+		shift += 7
+	}
+
+	return 0, errors.New("varint overflows uint64")
+}
+
 func (r *Reader) read() error {
 	r.bufIndex = 0
 	r.bufLen = 0
@@ -101,3 +202,9 @@ func (r *Reader) read() error {
 
 	return nil
 }
+
+// Release returns the block buffer to the pool. The reader must not be used after that.
+func (r *Reader) Release() {
+	putBlock(r.buf)
+	r.buf = nil
+}
@@ -0,0 +1,178 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// LengthPrefix defines how the length of a record is stored.
+type LengthPrefix int
+
+const (
+	// PrefixVarint stores the length as an uvarint.
+	PrefixVarint LengthPrefix = iota
+
+	// PrefixFixed32 stores the length as a big-endian uint32.
+	PrefixFixed32
+)
+
+// MaxRecordSize limits the length of a record read by RecordReader.
+// Longer length prefixes are treated as corrupted input.
+const MaxRecordSize = 1 << 30
+
+// recordChunk limits the memory allocated for a record before its bytes are read,
+// so a corrupted length prefix can't allocate much more than the input contains.
+const recordChunk = 64 * 1024
+
+// RecordWriter writes length-prefixed records: each token is stored as its length
+// followed by the token itself, so tokens may contain any bytes.
+type RecordWriter struct {
+	w      *Writer
+	prefix LengthPrefix
+
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func NewRecordWriter(file *os.File, offset int64, capacity int, prefix LengthPrefix) *RecordWriter {
+	return &RecordWriter{
+		w:      NewWriter(file, offset, capacity, nil),
+		prefix: prefix,
+	}
+}
+
+func (w *RecordWriter) Write(token []byte) error {
+	var n int
+	switch w.prefix {
+	case PrefixFixed32:
+		if uint64(len(token)) > math.MaxUint32 {
+			return errors.Errorf("record of %d bytes doesn't fit into a 32-bit length prefix", len(token))
+		}
+
+		binary.BigEndian.PutUint32(w.scratch[:], uint32(len(token)))
+		n = 4
+
+	default:
+		n = binary.PutUvarint(w.scratch[:], uint64(len(token)))
+	}
+
+	err := w.w.writeBytes(w.scratch[:n])
+	if err != nil {
+		return err
+	}
+
+	return w.w.writeBytes(token)
+}
+
+func (w *RecordWriter) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *RecordWriter) Offset() int64 {
+	return w.w.Offset()
+}
+
+// Release returns the block buffer to the pool. The writer must not be used after that.
+func (w *RecordWriter) Release() {
+	w.w.Release()
+}
+
+// RecordReader reads records written by RecordWriter.
+type RecordReader struct {
+	r      *Reader
+	prefix LengthPrefix
+
+	tokenOffset int64
+}
+
+func NewRecordReader(f *os.File, offset, endOffset int64, capacity int, prefix LengthPrefix) *RecordReader {
+	return &RecordReader{
+		r:      NewReader(f, offset, endOffset, capacity, nil),
+		prefix: prefix,
+	}
+}
+
+// Next returns the next record.
+// If no records are left, (nil, io.EOF) is returned.
+func (r *RecordReader) Next() ([]byte, error) {
+	length, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	r.tokenOffset = r.r.Offset()
+
+	if length > MaxRecordSize {
+		return nil, errors.Errorf("record at offset %d is %d bytes long, the limit is %d", r.tokenOffset, length, MaxRecordSize)
+	}
+	if remaining := r.r.endOffset - r.tokenOffset; length > uint64(remaining) {
+		return nil, errors.Errorf("record at offset %d is %d bytes long, but only %d bytes are left", r.tokenOffset, length, remaining)
+	}
+
+	// The end of the section may be unknown, so the record grows as its bytes are read.
+	token := make([]byte, 0, minUint64(length, recordChunk))
+	for uint64(len(token)) < length {
+		n := int(minUint64(length-uint64(len(token)), recordChunk))
+		token = append(token, make([]byte, n)...)
+
+		err = r.r.readFull(token[len(token)-n:])
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return token, nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// readLength reads the length prefix.
+// io.EOF is returned only if no bytes of the prefix have been read.
+func (r *RecordReader) readLength() (uint64, error) {
+	if r.prefix != PrefixFixed32 {
+		return r.r.readUvarint()
+	}
+
+	var prefix [4]byte
+
+	first, err := r.r.readByte()
+	if err != nil {
+		return 0, err
+	}
+	prefix[0] = first
+
+	err = r.r.readFull(prefix[1:])
+	if errors.Is(err, io.EOF) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(binary.BigEndian.Uint32(prefix[:])), nil
+}
+
+// Offset returns the file offset of the next unread byte.
+func (r *RecordReader) Offset() int64 {
+	return r.r.Offset()
+}
+
+func (r *RecordReader) TokenOffset() int64 {
+	return r.tokenOffset
+}
+
+// Release returns the block buffer to the pool. The reader must not be used after that.
+func (r *RecordReader) Release() {
+	r.r.Release()
+}
@@ -0,0 +1,32 @@
+package buffer
+
+// TokenReader reads tokens one by one.
+type TokenReader interface {
+	// Next returns the next token.
+	// If no tokens are left, (nil, io.EOF) is returned.
+	Next() (token []byte, err error)
+}
+
+// SectionReader reads tokens stored in a section of a file.
+type SectionReader interface {
+	TokenReader
+
+	// Offset returns the file offset of the next unread byte.
+	Offset() int64
+
+	// TokenOffset returns the file offset of the token returned by the latest Next call.
+	// The bytes of the token are stored there as is, one after another.
+	TokenOffset() int64
+
+	// Release returns the block buffer to the pool. The reader must not be used after that.
+	Release()
+}
+
+// TokenWriter writes tokens one by one.
+type TokenWriter interface {
+	Write(token []byte) error
+	Flush() error
+
+	// Offset returns the file offset the next written byte will be placed at.
+	Offset() int64
+}
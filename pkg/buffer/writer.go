@@ -11,19 +11,35 @@ type Writer struct {
 	bufIndex int
 	buf      []byte
 
-	delimiter byte
+	delimiter []byte
 }
 
-func NewWriter(file *os.File, offset int64, capacity int, delimiter byte) *Writer {
+// NewWriter creates a writer that appends the delimiter to every token.
+func NewWriter(file *os.File, offset int64, capacity int, delimiter []byte) *Writer {
 	return &Writer{
 		file:      file,
 		offset:    offset,
-		buf:       make([]byte, capacity), // TODO: sync.Pool
+		buf:       getBlock(capacity),
 		delimiter: delimiter,
 	}
 }
 
-func (w *Writer) Write(data []byte) (err error) {
+func (w *Writer) Write(data []byte) error {
+	err := w.writeBytes(data)
+	if err != nil {
+		return err
+	}
+
+	return w.writeBytes(w.delimiter)
+}
+
+// Offset returns the file offset the next written byte will be placed at.
+func (w *Writer) Offset() int64 {
+	return w.offset + int64(w.bufIndex)
+}
+
+// writeBytes writes data as is, without the delimiter.
+func (w *Writer) writeBytes(data []byte) (err error) {
 	for _, b := range data {
 		err = w.write(b)
 		if err != nil {
@@ -31,7 +47,7 @@ func (w *Writer) Write(data []byte) (err error) {
 		}
 	}
 
-	return w.write(w.delimiter)
+	return nil
 }
 
 func (w *Writer) write(b byte) error {
@@ -64,3 +80,10 @@ func (w *Writer) Flush() error {
 
 	return nil
 }
+
+// Release returns the block buffer to the pool. The writer must not be used after that,
+// so call Flush before.
+func (w *Writer) Release() {
+	putBlock(w.buf)
+	w.buf = nil
+}
@@ -0,0 +1,109 @@
+package cluster
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startWorker serves a worker with the given directory on a random local port and returns the address.
+func startWorker(t *testing.T, dir string) string {
+	require.NoError(t, os.MkdirAll(dir, 0755))
+
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName(ServiceName, NewWorker(dir)))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go server.Accept(listener)
+
+	return listener.Addr().String()
+}
+
+func TestCoordinator(t *testing.T) {
+	dir := t.TempDir()
+
+	var addresses []string
+	for i := 0; i < 3; i++ {
+		addresses = append(addresses, startWorker(t, filepath.Join(dir, "worker", string(rune('a'+i)))))
+	}
+
+	tokens := strings.Fields("pear apple fig kiwi banana cherry apple date grape lemon mango nectarine orange plum quince")
+	inputPath := filepath.Join(dir, "input.txt")
+	outputPath := filepath.Join(dir, "output.txt")
+	require.NoError(t, ioutil.WriteFile(inputPath, []byte(strings.Join(tokens, "\n")), 0644))
+
+	coordinator, err := NewCoordinator(Settings{
+		BlockSize:   4,
+		MemoryLimit: 40,
+		Delimiter:   []byte("\n"),
+		Order:       config.OrderASC,
+	}, addresses)
+	require.NoError(t, err)
+	defer func() {
+		_ = coordinator.Close()
+	}()
+
+	require.NoError(t, coordinator.Sort(inputPath, outputPath))
+
+	output, err := ioutil.ReadFile(outputPath)
+	require.NoError(t, err)
+
+	sort.Strings(tokens)
+	assert.Equal(t, strings.Join(tokens, "\n")+"\n", string(output))
+
+	// Workers must remove their files after the job is done.
+	leftovers, err := filepath.Glob(filepath.Join(dir, "worker", "*", "*"))
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
+}
+
+func TestRemoteRange(t *testing.T) {
+	dir := t.TempDir()
+	worker := NewWorker(dir)
+
+	data := strings.Repeat("0123456789", 10)
+	require.NoError(t, ioutil.WriteFile(worker.path("job", "range_2"), []byte(data), 0644))
+
+	client, err := rpc.Dial("tcp", startWorker(t, dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+
+	r := &remoteRange{client: client, job: "job", index: 2}
+
+	p := make([]byte, 30)
+	n, err := r.ReadAt(p, 5)
+	require.NoError(t, err)
+	assert.Equal(t, data[5:35], string(p[:n]))
+
+	// The end of the range.
+	n, err = r.ReadAt(p, 90)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, data[90:], string(p[:n]))
+
+	// Files are found by the job and the range index only.
+	err = client.Call(ServiceName+".Fetch", &FetchArgs{Job: "../job", Index: 2, Size: 10}, &FetchReply{})
+	assert.NoError(t, err)
+
+	err = client.Call(ServiceName+".Fetch", &FetchArgs{Job: "job", Index: 3, Size: 10}, &FetchReply{})
+	assert.Error(t, err)
+
+	err = client.Call(ServiceName+".Fetch", &FetchArgs{Job: "job", Index: 2, Size: maxFetchSize + 1}, &FetchReply{})
+	assert.Error(t, err)
+}
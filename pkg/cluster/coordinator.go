@@ -0,0 +1,233 @@
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/rpc"
+	"os"
+	"time"
+
+	"github.com/lodthe/external-merge-sort/pkg/algo"
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// Coordinator splits the input into byte ranges, asks workers to sort them
+// and merges the sorted shards into the output file.
+//
+// Workers must have access to the input file (e.g., a shared filesystem). Sorted shards are stored
+// on the worker side and streamed to the coordinator over the rpc connection.
+type Coordinator struct {
+	settings Settings
+	workers  []*rpc.Client
+}
+
+// NewCoordinator connects to workers listening on the given TCP addresses.
+func NewCoordinator(settings Settings, addresses []string) (*Coordinator, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("no workers")
+	}
+
+	c := &Coordinator{
+		settings: settings,
+	}
+
+	for _, addr := range addresses {
+		client, err := rpc.Dial("tcp", addr)
+		if err != nil {
+			_ = c.Close()
+			return nil, errors.Wrapf(err, "failed to connect to %s", addr)
+		}
+
+		c.workers = append(c.workers, client)
+	}
+
+	return c, nil
+}
+
+// Close closes connections to workers.
+func (c *Coordinator) Close() error {
+	var result error
+	for _, w := range c.workers {
+		err := w.Close()
+		if err != nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+// Sort sorts the input file with the help of workers and saves the result to the output file.
+func (c *Coordinator) Sort(inputPath, outputPath string) error {
+	startedAt := time.Now()
+	cfg := c.settings.config()
+	job := fmt.Sprintf("%d", startedAt.UnixNano())
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open input file")
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+
+	info, err := input.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat input file")
+	}
+
+	splitters, err := algo.SampleSplitters(input, cfg, len(c.workers))
+	if err != nil {
+		return errors.Wrap(err, "failed to sample splitters")
+	}
+
+	defer c.cleanup(job)
+
+	replies, err := c.sortRanges(job, inputPath, info.Size(), splitters)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("ranges sorted in %v\n", time.Since(startedAt))
+
+	err = c.mergeShards(job, replies, len(splitters)+1, outputPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to merge shards")
+	}
+
+	log.Printf("distributed sort finished in %v\n", time.Since(startedAt))
+
+	return nil
+}
+
+// sortRanges splits the input into equal byte ranges, one for each worker, and waits until they are sorted.
+func (c *Coordinator) sortRanges(job, inputPath string, size int64, splitters [][]byte) ([]*SortRangeReply, error) {
+	calls := make([]*rpc.Call, len(c.workers))
+	replies := make([]*SortRangeReply, len(c.workers))
+
+	for i, w := range c.workers {
+		args := &SortRangeArgs{
+			Job:       job,
+			Index:     i,
+			InputPath: inputPath,
+			Start:     size * int64(i) / int64(len(c.workers)),
+			End:       size * int64(i+1) / int64(len(c.workers)),
+			Splitters: splitters,
+			Settings:  c.settings,
+		}
+
+		replies[i] = new(SortRangeReply)
+		calls[i] = w.Go(ServiceName+".SortRange", args, replies[i], nil)
+	}
+
+	var result error
+	for i, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			result = errors.Wrapf(call.Error, "worker #%d failed", i)
+		}
+	}
+
+	return replies, result
+}
+
+// mergeShards merges shards of each range from all workers with K-way merge.
+// Ranges don't overlap, so merged ranges are written one after another.
+func (c *Coordinator) mergeShards(job string, replies []*SortRangeReply, ranges int, outputPath string) error {
+	cfg := c.settings.config()
+
+	output, err := os.OpenFile(outputPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = output.Close()
+	}()
+
+	w := buffer.NewWriter(output, 0, cfg.BlockSize, cfg.Delimiter)
+
+	for p := 0; p < ranges; p++ {
+		var readers []*buffer.Reader
+		var sources []buffer.TokenReader
+		for i, reply := range replies {
+			shard := reply.Shards[p]
+			if shard.Start == shard.End {
+				continue
+			}
+
+			remote := &remoteRange{
+				client: c.workers[i],
+				job:    job,
+				index:  i,
+			}
+
+			r := buffer.NewReaderAt(remote, shard.Start, shard.End, cfg.BlockSize, cfg.Delimiter)
+			readers = append(readers, r)
+			sources = append(sources, r)
+		}
+
+		err = algo.KWayMerger{}.Merge(sources, w, cfg.Less)
+		for _, r := range readers {
+			r.Release()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "range #%d", p)
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	return output.Sync()
+}
+
+// cleanup asks workers to remove files of the job.
+func (c *Coordinator) cleanup(job string) {
+	for i, w := range c.workers {
+		err := w.Call(ServiceName+".Cleanup", &CleanupArgs{Job: job}, &CleanupReply{})
+		if err != nil {
+			log.Printf("worker #%d cleanup failed: %v\n", i, err)
+		}
+	}
+}
+
+// remoteRange reads a sorted range stored on a worker with Fetch calls.
+type remoteRange struct {
+	client *rpc.Client
+	job    string
+	index  int
+}
+
+func (r *remoteRange) ReadAt(p []byte, offset int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		size := len(p) - n
+		if size > maxFetchSize {
+			size = maxFetchSize
+		}
+
+		args := &FetchArgs{
+			Job:    r.job,
+			Index:  r.index,
+			Offset: offset + int64(n),
+			Size:   size,
+		}
+
+		var reply FetchReply
+		err := r.client.Call(ServiceName+".Fetch", args, &reply)
+		if err != nil {
+			return n, errors.Wrapf(err, "failed to fetch range #%d", r.index)
+		}
+
+		n += copy(p[n:], reply.Data)
+		if len(reply.Data) < size {
+			return n, io.EOF
+		}
+	}
+
+	return n, nil
+}
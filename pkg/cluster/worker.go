@@ -0,0 +1,266 @@
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/lodthe/external-merge-sort/pkg/algo"
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// ServiceName is the name the worker is registered with in the rpc server.
+const ServiceName = "Worker"
+
+// Settings is the part of config.Config that can be sent over the network.
+// Only the built-in orders are supported, as comparators can't be transferred.
+type Settings struct {
+	BlockSize   int
+	MemoryLimit int
+	Delimiter   []byte
+	Order       config.Order
+	FrontCoding bool
+}
+
+func (s Settings) config() *config.Config {
+	return &config.Config{
+		BlockSize:   s.BlockSize,
+		MemoryLimit: s.MemoryLimit,
+		Delimiter:   s.Delimiter,
+		Less:        s.Order.Less(),
+		Order:       s.Order,
+		FrontCoding: s.FrontCoding,
+		Merger:      algo.KWayMerger{},
+	}
+}
+
+type SortRangeArgs struct {
+	// Job identifies files of one sort on the worker.
+	Job   string
+	Index int
+
+	InputPath string
+
+	// [Start, End) bytes of the input. The worker sorts tokens that begin in this range.
+	Start int64
+	End   int64
+
+	// Splitters are used to split the sorted range into shards.
+	Splitters [][]byte
+
+	Settings Settings
+}
+
+// Shard is a sorted section of the range file on the worker side. Its bytes are read with Fetch.
+type Shard struct {
+	Start int64
+	End   int64
+}
+
+type SortRangeReply struct {
+	// Shards[i] contains sorted tokens of the i-th range defined by splitters.
+	Shards []Shard
+}
+
+type FetchArgs struct {
+	Job string
+
+	// Index is the index of the sorted range, see SortRangeArgs.
+	Index int
+
+	// Size bytes starting at Offset of the sorted range are read.
+	Offset int64
+	Size   int
+}
+
+type FetchReply struct {
+	// Data is shorter than the requested size only at the end of the range.
+	Data []byte
+}
+
+// maxFetchSize limits the size of one Fetch reply.
+const maxFetchSize = 16 * 1024 * 1024
+
+type CleanupArgs struct {
+	Job string
+}
+
+type CleanupReply struct{}
+
+// Worker sorts byte ranges of an input file on request.
+// Sorted files are stored in the worker directory and sent to the coordinator with Fetch
+// until the coordinator asks to clean them up.
+type Worker struct {
+	dir string
+}
+
+func NewWorker(dir string) *Worker {
+	return &Worker{
+		dir: dir,
+	}
+}
+
+// SortRange sorts tokens of the given byte range and splits the result into shards.
+func (w *Worker) SortRange(args *SortRangeArgs, reply *SortRangeReply) error {
+	cfg := args.Settings.config()
+	if cfg.Less == nil {
+		return errors.New("only the built-in orders are supported")
+	}
+
+	input, err := os.Open(args.InputPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open input file")
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+
+	start, err := alignOffset(input, args.Start, cfg.Delimiter)
+	if err != nil {
+		return errors.Wrap(err, "failed to align start")
+	}
+
+	end, err := alignOffset(input, args.End, cfg.Delimiter)
+	if err != nil {
+		return errors.Wrap(err, "failed to align end")
+	}
+
+	log.Printf("job %s: sorting range #%d [%d; %d)\n", args.Job, args.Index, start, end)
+
+	sortedPath := w.path(args.Job, fmt.Sprintf("range_%d", args.Index))
+	err = algo.NewExternalMergeSort(cfg).SortRange(args.InputPath, start, end, sortedPath, w.dir)
+	if err != nil {
+		return errors.Wrap(err, "sort failed")
+	}
+
+	reply.Shards, err = splitShards(sortedPath, args.Splitters, cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to split shards")
+	}
+
+	return nil
+}
+
+// Fetch reads bytes of a sorted range.
+func (w *Worker) Fetch(args *FetchArgs, reply *FetchReply) error {
+	if args.Offset < 0 || args.Size < 0 || args.Size > maxFetchSize {
+		return errors.Errorf("invalid chunk of %d bytes at %d", args.Size, args.Offset)
+	}
+
+	file, err := os.Open(w.path(args.Job, fmt.Sprintf("range_%d", args.Index)))
+	if err != nil {
+		return errors.Wrap(err, "failed to open the sorted range")
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reply.Data = make([]byte, args.Size)
+	n, err := file.ReadAt(reply.Data, args.Offset)
+	reply.Data = reply.Data[:n]
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+// Cleanup removes files of the job.
+func (w *Worker) Cleanup(args *CleanupArgs, _ *CleanupReply) error {
+	paths, err := filepath.Glob(w.path(args.Job, "*"))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		err = os.Remove(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *Worker) path(job, name string) string {
+	return filepath.Join(w.dir, fmt.Sprintf("distsort_%s_%s", filepath.Base(job), name))
+}
+
+// splitShards finds boundaries of the splitter ranges in the sorted file.
+func splitShards(path string, splitters [][]byte, cfg *config.Config) ([]Shard, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	shards := make([]Shard, len(splitters)+1)
+
+	r := buffer.NewReader(file, 0, algo.MaxInt64, cfg.BlockSize, cfg.Delimiter)
+	var current int
+	for {
+		offset := r.Offset()
+
+		token, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for p := algo.Partition(token, splitters, cfg.Less); current < p; current++ {
+			shards[current].End = offset
+			shards[current+1].Start = offset
+		}
+	}
+
+	end := r.Offset()
+	for ; current < len(shards); current++ {
+		shards[current].End = end
+		if current+1 < len(shards) {
+			shards[current+1].Start = end
+		}
+	}
+
+	return shards, nil
+}
+
+// alignOffset returns the offset of the first token that begins at or after offset.
+// A token begins at the start of the file or right after a delimiter.
+// Delimiters that overlap with themselves (like "||") are searched from len(delimiter) bytes before offset,
+// so the result is the same for all workers, but it may differ from the boundaries seen by a sequential reader.
+func alignOffset(file *os.File, offset int64, delimiter []byte) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if offset <= 0 {
+		return 0, nil
+	}
+	if offset >= info.Size() {
+		return info.Size(), nil
+	}
+
+	const bufferSize = 4 * 1024
+	from := offset - int64(len(delimiter))
+	if from < 0 {
+		from = 0
+	}
+	r := buffer.NewReader(file, from, info.Size(), bufferSize, delimiter)
+
+	// The token that contains the bytes before offset (it may be just the delimiter) is skipped.
+	_, err = r.Next()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	return r.Offset(), nil
+}
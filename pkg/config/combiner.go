@@ -0,0 +1,267 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Combiner merges tokens with equal keys while they are sorted, so the output has one token per key.
+// Input tokens are converted to partial results by Prepare during run generation, partial results with equal keys
+// are merged by Combine within every run and at every merge, and final results are converted by Finish.
+// Partial results are compared like tokens (by KeyFunc or Less), so Prepare and Combine must keep keys.
+type Combiner struct {
+	// Prepare converts an input token to a partial result. position grows with the position of the token
+	// in the input, so order-dependent combiners (e.g. first and last) can keep it in the result.
+	// If it's nil, tokens are partial results.
+	Prepare func(dst, token []byte, position int64) ([]byte, error)
+
+	// Combine appends the merge of partial results a and b with equal keys to dst.
+	Combine func(dst, a, b []byte) ([]byte, error)
+
+	// Finish appends the output token made of a final result to dst. If it's nil, results are written as is.
+	Finish func(dst, result []byte) ([]byte, error)
+}
+
+// Prepare converts an input token with Combiner.Prepare if it's set. Otherwise, the token is returned as is.
+func (c *Config) Prepare(token []byte, position int64) ([]byte, error) {
+	if c.Combiner == nil || c.Combiner.Prepare == nil {
+		return token, nil
+	}
+
+	return c.Combiner.Prepare(nil, token, position)
+}
+
+// AggregateFunc is a built-in aggregate of GroupBy.
+type AggregateFunc int
+
+const (
+	// AggregateCount counts tokens.
+	AggregateCount AggregateFunc = iota
+
+	// AggregateSum sums values of a numeric field.
+	AggregateSum
+
+	// AggregateMin keeps the least value of a field.
+	AggregateMin
+
+	// AggregateMax keeps the greatest value of a field.
+	AggregateMax
+
+	// AggregateFirst keeps the value of a field of the first token in the input.
+	AggregateFirst
+
+	// AggregateLast keeps the value of a field of the last token in the input.
+	AggregateLast
+)
+
+var aggregateFuncs = map[string]AggregateFunc{
+	"count": AggregateCount,
+	"sum":   AggregateSum,
+	"min":   AggregateMin,
+	"max":   AggregateMax,
+	"first": AggregateFirst,
+	"last":  AggregateLast,
+}
+
+// Aggregate is an aggregate function applied to a field of grouped tokens.
+type Aggregate struct {
+	Func AggregateFunc
+
+	// Field is the zero-based position of the aggregated field. It's ignored by AggregateCount.
+	Field int
+
+	// Number makes AggregateMin and AggregateMax compare values as numbers.
+	Number bool
+}
+
+// ParseAggregate parses an aggregate: count, or a function (sum, min, max, first, last) followed by a 1-based field number,
+// e.g. "sum:3". min and max compare values as strings unless ":number" follows, e.g. "max:2:number".
+func ParseAggregate(s string) (Aggregate, error) {
+	parts := strings.Split(s, ":")
+
+	f, exists := aggregateFuncs[strings.ToLower(parts[0])]
+	if !exists {
+		return Aggregate{}, errors.Errorf("unknown aggregate %s", parts[0])
+	}
+
+	aggregate := Aggregate{Func: f}
+	if f == AggregateCount {
+		if len(parts) > 1 {
+			return Aggregate{}, errors.Errorf("count takes no field, but %q was given", s)
+		}
+
+		return aggregate, nil
+	}
+
+	if len(parts) < 2 {
+		return Aggregate{}, errors.Errorf("%s requires a field number, e.g. %s:2", parts[0], parts[0])
+	}
+
+	n, err := strconv.Atoi(parts[1])
+	if err != nil || n < 1 {
+		return Aggregate{}, errors.Errorf("field numbers start with 1, but %q was given", parts[1])
+	}
+	aggregate.Field = n - 1
+
+	for _, option := range parts[2:] {
+		if strings.ToLower(option) != "number" || (f != AggregateMin && f != AggregateMax) {
+			return Aggregate{}, errors.Errorf("unknown option %s of %s", option, parts[0])
+		}
+		aggregate.Number = true
+	}
+
+	return aggregate, nil
+}
+
+// GroupBy returns a combiner and a KeyFunc for tokens made of fields separated by sep.
+// Tokens are grouped by the key fields (zero-based positions, the whole token if there are none),
+// and each group is replaced with the key fields and the aggregate joined by sep. Groups go in the given order.
+func GroupBy(aggregate Aggregate, keyFields []int, sep []byte, order Order) (*Combiner, KeyFunc) {
+	g := groupBy{
+		aggregate: aggregate,
+		keyFields: keyFields,
+		sep:       sep,
+	}
+
+	combiner := &Combiner{
+		Prepare: g.prepare,
+		Combine: g.combine,
+	}
+	if aggregate.Func == AggregateFirst || aggregate.Func == AggregateLast {
+		combiner.Finish = g.finish
+	}
+
+	tokenKey := TokenKeyFunc(order)
+	keyFunc := func(dst, result []byte) ([]byte, error) {
+		key, _ := g.split(result)
+		return tokenKey(dst, key)
+	}
+
+	return combiner, keyFunc
+}
+
+// groupBy implements built-in aggregates. A partial result is the key, sep and the state of the aggregate.
+// The state of first and last is the position of the token as 16 hex digits followed by the value.
+type groupBy struct {
+	aggregate Aggregate
+	keyFields []int
+	sep       []byte
+}
+
+// positionSize is the length of a position in the state of first and last.
+const positionSize = 16
+
+func (g groupBy) prepare(dst, token []byte, position int64) ([]byte, error) {
+	fields := bytes.Split(token, g.sep)
+
+	if len(g.keyFields) == 0 {
+		dst = append(dst, token...)
+	}
+	for i, f := range g.keyFields {
+		if i > 0 {
+			dst = append(dst, g.sep...)
+		}
+		if f < len(fields) {
+			dst = append(dst, fields[f]...)
+		}
+	}
+	dst = append(dst, g.sep...)
+
+	var value []byte
+	if g.aggregate.Field < len(fields) {
+		value = fields[g.aggregate.Field]
+	}
+
+	switch g.aggregate.Func {
+	case AggregateCount:
+		return append(dst, '1'), nil
+
+	case AggregateSum:
+		x, ok := number(string(value))
+		if !ok {
+			return nil, errors.Errorf("field %d of %q isn't a number", g.aggregate.Field+1, token)
+		}
+
+		return strconv.AppendFloat(dst, x, 'f', -1, 64), nil
+
+	case AggregateFirst, AggregateLast:
+		return append(append(dst, fmt.Sprintf("%0*x", positionSize, position)...), value...), nil
+
+	default:
+		return append(dst, value...), nil
+	}
+}
+
+func (g groupBy) combine(dst, a, b []byte) ([]byte, error) {
+	key, stateA := g.split(a)
+	_, stateB := g.split(b)
+
+	dst = append(append(dst, key...), g.sep...)
+
+	switch g.aggregate.Func {
+	case AggregateCount:
+		x, errA := strconv.ParseInt(string(stateA), 10, 64)
+		y, errB := strconv.ParseInt(string(stateB), 10, 64)
+		if errA != nil || errB != nil {
+			return nil, errors.Errorf("invalid counts %q and %q", stateA, stateB)
+		}
+
+		return strconv.AppendInt(dst, x+y, 10), nil
+
+	case AggregateSum:
+		x, okA := number(string(stateA))
+		y, okB := number(string(stateB))
+		if !okA || !okB {
+			return nil, errors.Errorf("invalid sums %q and %q", stateA, stateB)
+		}
+
+		return strconv.AppendFloat(dst, x+y, 'f', -1, 64), nil
+
+	case AggregateMin, AggregateMax:
+		column := Column{Type: ColumnString}
+		if g.aggregate.Number {
+			column.Type = ColumnNumber
+		}
+
+		c := column.compare(string(stateA), string(stateB))
+		if (g.aggregate.Func == AggregateMin) == (c <= 0) {
+			return append(dst, stateA...), nil
+		}
+
+		return append(dst, stateB...), nil
+
+	default:
+		// Positions are fixed-width hex numbers, so they are compared byte-wise.
+		c := bytes.Compare(stateA[:positionSize], stateB[:positionSize])
+		if (g.aggregate.Func == AggregateFirst) == (c <= 0) {
+			return append(dst, stateA...), nil
+		}
+
+		return append(dst, stateB...), nil
+	}
+}
+
+func (g groupBy) finish(dst, result []byte) ([]byte, error) {
+	key, state := g.split(result)
+	if len(state) < positionSize {
+		return nil, errors.Errorf("invalid state %q", state)
+	}
+
+	dst = append(append(dst, key...), g.sep...)
+
+	return append(dst, state[positionSize:]...), nil
+}
+
+// split divides a partial result into the key and the state. The state never contains sep, but the key may.
+func (g groupBy) split(result []byte) (key, state []byte) {
+	i := bytes.LastIndex(result, g.sep)
+	if i < 0 {
+		return result, nil
+	}
+
+	return result[:i], result[i+len(g.sep):]
+}
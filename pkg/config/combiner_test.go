@@ -0,0 +1,58 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAggregate(t *testing.T) {
+	for s, expected := range map[string]Aggregate{
+		"count":        {Func: AggregateCount},
+		"SUM:3":        {Func: AggregateSum, Field: 2},
+		"max:2:number": {Func: AggregateMax, Field: 1, Number: true},
+		"last:1":       {Func: AggregateLast},
+	} {
+		aggregate, err := ParseAggregate(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, aggregate, s)
+	}
+
+	for _, s := range []string{"", "avg:1", "sum", "sum:0", "count:1", "first:1:number"} {
+		_, err := ParseAggregate(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	aggregate, err := ParseAggregate("min:3:number")
+	require.NoError(t, err)
+
+	combiner, keyFunc := GroupBy(aggregate, []int{1, 0}, []byte(","), OrderASC)
+
+	a, err := combiner.Prepare(nil, []byte("x,y,10"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "y,x,10", string(a))
+
+	b, err := combiner.Prepare(nil, []byte("x,y,9.5,extra"), 1)
+	require.NoError(t, err)
+
+	keyA, err := keyFunc(nil, a)
+	require.NoError(t, err)
+	keyB, err := keyFunc(nil, b)
+	require.NoError(t, err)
+	assert.Equal(t, keyA, keyB)
+
+	combined, err := combiner.Combine(nil, a, b)
+	require.NoError(t, err)
+	assert.Equal(t, "y,x,9.5", string(combined))
+	assert.Nil(t, combiner.Finish)
+
+	sum, err := ParseAggregate("sum:2")
+	require.NoError(t, err)
+
+	combiner, _ = GroupBy(sum, []int{0}, []byte(","), OrderASC)
+	_, err = combiner.Prepare(nil, []byte("x,n/a"), 0)
+	assert.Error(t, err)
+}
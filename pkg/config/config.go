@@ -1,5 +1,11 @@
 package config
 
+import (
+	"regexp"
+
+	"github.com/lodthe/external-merge-sort/pkg/memory"
+)
+
 type Config struct {
 	// Size of one block is bytes.
 	BlockSize int
@@ -7,9 +13,57 @@ type Config struct {
 	// How much memory the program can waste.
 	MemoryLimit int
 
-	// Delimiter separates one token from another.
-	Delimiter byte
+	// Delimiter separates one token from another. It may be several bytes long, e.g. "\r\n".
+	Delimiter []byte
+
+	// CRLF defines how lines ended with "\r\n" are handled if Delimiter is "\n".
+	CRLF CRLF
+
+	// Framing defines how tokens are stored in the input and output files. By default, they are separated by Delimiter.
+	Framing Framing
+
+	// RecordSize is the size of every token if Framing is FramingFixed.
+	RecordSize int
+
+	// RecordStart matches the first line of a record if Framing is FramingMultiline.
+	// If it's nil, records are paragraphs separated by blank lines.
+	RecordStart *regexp.Regexp
+
+	// Header marks the first token of the input as a header (e.g. CSV column names).
+	// It isn't sorted and is written at the top of the output.
+	Header bool
+
+	// KeyFunc extracts sort keys of tokens. If it's set, keys are extracted once when tokens are read
+	// and stored next to tokens in intermediate runs, and Less and Order are ignored: keys are compared byte-wise.
+	KeyFunc KeyFunc
+
+	// Combiner merges tokens with equal keys, so the output has one token per key. If it's nil, all tokens are kept.
+	Combiner *Combiner
 
 	// Less determines whether the first token must be presented earlier than the second one.
 	Less func(a, b []byte) bool
+
+	// Order marks Less as one of the built-in byte-wise orders, so radix sort can be used in main memory.
+	// Leave it zero (OrderCustom) if Less is a custom comparator.
+	Order Order
+
+	// FrontCoding enables prefix compression of intermediate runs.
+	// Each token is stored as the length of the prefix shared with the previous token plus the suffix.
+	// The output file is always written in the Framing format.
+	FrontCoding bool
+
+	// Adaptive enables detection of sorted and reverse-sorted input.
+	// Sorted portions extend the previous run instead of starting a new one, reverse-sorted portions are reversed.
+	// If the input file is the output file and it's already sorted, nothing is written.
+	Adaptive bool
+
+	// RunSorter sorts runs in main memory. If nil, algo.RadixSorter is used.
+	RunSorter RunSorter
+
+	// Merger merges runs during external passes. If nil, algo.TwoWayMerger is used.
+	Merger Merger
+
+	// Governor is a memory budget shared with other sorts. If it's set, a sort waits for memory before it starts
+	// and uses the granted amount (at most MemoryLimit and at least three blocks) instead of MemoryLimit.
+	Governor *memory.Governor
 }
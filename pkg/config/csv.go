@@ -0,0 +1,282 @@
+package config
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ColumnType defines how values of a CSV column are compared.
+type ColumnType int
+
+const (
+	// ColumnString compares values byte-wise.
+	ColumnString ColumnType = iota
+
+	// ColumnNumber compares values as floating-point numbers.
+	// Values that aren't numbers go after the numbers and are compared byte-wise.
+	ColumnNumber
+)
+
+var columnTypes = map[string]ColumnType{
+	"string": ColumnString,
+	"number": ColumnNumber,
+}
+
+// Column is a CSV column records are sorted by.
+type Column struct {
+	// Name is the name of the column in the header. If it's empty, Index is used.
+	Name string
+
+	// Index is the zero-based position of the column.
+	Index int
+
+	Type ColumnType
+	Desc bool
+}
+
+// ParseColumns parses a comma-separated list of columns. Each column is a name or a 1-based number
+// optionally followed by a type (string, number) and a direction (asc, desc), e.g. "price:number:desc,2".
+func ParseColumns(s string) ([]Column, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var columns []Column
+	for _, spec := range strings.Split(s, ",") {
+		parts := strings.Split(spec, ":")
+		if parts[0] == "" {
+			return nil, errors.Errorf("empty column in %q", s)
+		}
+
+		var column Column
+		if n, err := strconv.Atoi(parts[0]); err == nil {
+			if n < 1 {
+				return nil, errors.Errorf("column numbers start with 1, but %d was given", n)
+			}
+			column.Index = n - 1
+		} else {
+			column.Name = parts[0]
+		}
+
+		for _, option := range parts[1:] {
+			if t, exists := columnTypes[strings.ToLower(option)]; exists {
+				column.Type = t
+				continue
+			}
+
+			switch strings.ToLower(option) {
+			case "asc":
+				column.Desc = false
+
+			case "desc":
+				column.Desc = true
+
+			default:
+				return nil, errors.Errorf("unknown option %s of column %s", option, parts[0])
+			}
+		}
+
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+// ResolveColumns sets Index of named columns according to the header fields.
+func ResolveColumns(columns []Column, header []string) error {
+	for i := range columns {
+		if columns[i].Name == "" {
+			continue
+		}
+
+		index := -1
+		for j, name := range header {
+			if name == columns[i].Name {
+				index = j
+				break
+			}
+		}
+		if index == -1 {
+			return errors.Errorf("column %s isn't found in the header", columns[i].Name)
+		}
+
+		columns[i].Index = index
+	}
+
+	return nil
+}
+
+// ParseComma converts a command line argument to a CSV field separator.
+// Escape sequences are interpreted, so a tab can be passed as \t.
+func ParseComma(s string) (rune, error) {
+	if unquoted, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		s = unquoted
+	}
+
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == utf8.RuneError {
+		return 0, errors.Errorf("field separator must be a single character, but %q was given", s)
+	}
+
+	return r, nil
+}
+
+// CSVFields splits a CSV record into fields.
+func CSVFields(record []byte, comma rune) ([]string, error) {
+	r := csv.NewReader(bytes.NewReader(record))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+
+	fields, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+
+	return fields, err
+}
+
+// CSVKeyFunc returns a KeyFunc of CSV records that compares the given columns one by one in the given order.
+// If no columns are given, all fields are compared as strings, and records with fewer fields go first on ties.
+// Missing fields are treated as empty. Records that can't be parsed fail key extraction.
+func CSVKeyFunc(columns []Column, comma rune, order Order) KeyFunc {
+	split := func(record []byte) ([]string, error) {
+		return CSVFields(record, comma)
+	}
+
+	if len(columns) > 0 {
+		if order == OrderDESC {
+			columns = append([]Column(nil), columns...)
+			for i := range columns {
+				columns[i].Desc = !columns[i].Desc
+			}
+		}
+
+		return ColumnKeyFunc(columns, split)
+	}
+
+	return func(dst, record []byte) ([]byte, error) {
+		fields, err := split(record)
+		if err != nil {
+			return dst, err
+		}
+
+		start := len(dst)
+		for _, f := range fields {
+			dst = appendEscaped(dst, []byte(f))
+		}
+
+		// The end of fields goes before any escaped field, so a record that is a prefix of another one goes first.
+		dst = append(dst, 0, 0)
+
+		if order == OrderDESC {
+			invert(dst[start:])
+		}
+
+		return dst, nil
+	}
+}
+
+// ColumnKeyFunc returns a KeyFunc of records that are split into fields by split. The key is made of the given columns,
+// so records with keys in different columns can be compared by keys. Numbers go before other values of number columns.
+func ColumnKeyFunc(columns []Column, split func(record []byte) ([]string, error)) KeyFunc {
+	return func(dst, record []byte) ([]byte, error) {
+		fields, err := split(record)
+		if err != nil {
+			return dst, err
+		}
+
+		for _, column := range columns {
+			start := len(dst)
+			value := field(fields, column.Index)
+
+			if x, ok := number(value); ok && column.Type == ColumnNumber {
+				var buf [8]byte
+				binary.BigEndian.PutUint64(buf[:], orderedFloat(x))
+				dst = append(append(dst, 1), buf[:]...)
+			} else {
+				if column.Type == ColumnNumber {
+					dst = append(dst, 2)
+				}
+				dst = appendEscaped(dst, []byte(value))
+			}
+
+			if column.Desc {
+				invert(dst[start:])
+			}
+		}
+
+		return dst, nil
+	}
+}
+
+func field(fields []string, i int) string {
+	if i < len(fields) {
+		return fields[i]
+	}
+
+	return ""
+}
+
+// compare returns -1, 0 or 1 if a is less than, equal to or greater than b.
+func (c Column) compare(a, b string) int {
+	if c.Type == ColumnNumber {
+		x, okA := number(a)
+		y, okB := number(b)
+
+		switch {
+		case okA && okB && x < y:
+			return -1
+
+		case okA && okB && x > y:
+			return 1
+
+		case okA && !okB:
+			return -1
+
+		case !okA && okB:
+			return 1
+		}
+	}
+
+	return strings.Compare(a, b)
+}
+
+func number(s string) (float64, bool) {
+	x, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(x) {
+		return 0, false
+	}
+
+	return x, true
+}
+
+// ReadHeader returns the first token of the file.
+// nil is returned if the file is empty.
+func (c *Config) ReadHeader(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	r := c.NewReader(f, 0, math.MaxInt64)
+	defer r.Release()
+
+	header, err := r.Next()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+
+	return header, err
+}
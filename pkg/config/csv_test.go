@@ -0,0 +1,106 @@
+package config
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseColumns(t *testing.T) {
+	columns, err := ParseColumns("price:number:desc,2,name:asc")
+	require.NoError(t, err)
+	assert.Equal(t, []Column{
+		{Name: "price", Type: ColumnNumber, Desc: true},
+		{Index: 1},
+		{Name: "name"},
+	}, columns)
+
+	require.NoError(t, ResolveColumns(columns, []string{"name", "id", "price"}))
+	assert.Equal(t, 2, columns[0].Index)
+	assert.Equal(t, 0, columns[2].Index)
+
+	assert.Error(t, ResolveColumns([]Column{{Name: "size"}}, []string{"name"}))
+
+	for _, s := range []string{"0", "price:weight", ",name"} {
+		_, err = ParseColumns(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestCSVKeyFunc(t *testing.T) {
+	records := []string{
+		`"Smith, J",10.5,x`,
+		`Doe,9,y`,
+		`"Multi` + "\n" + `line",n/a,z`,
+		`Adams,10.5,"w"`,
+		`Brown,-1`,
+	}
+
+	sortRecords := func(records []string, keyFunc KeyFunc) []string {
+		less := LessByKey(keyFunc)
+		sorted := append([]string(nil), records...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return less([]byte(sorted[i]), []byte(sorted[j]))
+		})
+
+		return sorted
+	}
+
+	columns := []Column{{Index: 1, Type: ColumnNumber, Desc: true}, {Index: 0}}
+	assert.Equal(t, []string{
+		`"Multi` + "\n" + `line",n/a,z`,
+		`Adams,10.5,"w"`,
+		`"Smith, J",10.5,x`,
+		`Doe,9,y`,
+		`Brown,-1`,
+	}, sortRecords(records, CSVKeyFunc(columns, ',', OrderASC)))
+
+	assert.Equal(t, []string{
+		`Brown,-1`,
+		`Doe,9,y`,
+		`"Smith, J",10.5,x`,
+		`Adams,10.5,"w"`,
+		`"Multi` + "\n" + `line",n/a,z`,
+	}, sortRecords(records, CSVKeyFunc(columns, ',', OrderDESC)))
+
+	assert.Equal(t, []string{
+		`Adams,10.5,"w"`,
+		`Brown,-1`,
+		`Doe,9,y`,
+		`"Multi` + "\n" + `line",n/a,z`,
+		`"Smith, J",10.5,x`,
+	}, sortRecords(records, CSVKeyFunc(nil, ',', OrderASC)))
+
+	// Records that are prefixes of others go first, or last in the descending order.
+	assert.Equal(t, []string{"a,b", "a", ""}, sortRecords([]string{"a", "", "a,b"}, CSVKeyFunc(nil, ',', OrderDESC)))
+
+	_, err := CSVKeyFunc(nil, ',', OrderASC)(nil, []byte(`a"b,c`))
+	assert.Error(t, err)
+
+	_, err = CSVKeyFunc(columns, ',', OrderASC)(nil, []byte(`"a`))
+	assert.Error(t, err)
+}
+
+func TestColumnKeyFunc(t *testing.T) {
+	split := func(record []byte) ([]string, error) {
+		return CSVFields(record, ',')
+	}
+
+	records := []string{"x,10", "y,9.5", "z,n/a", "w,-1", "v,9.5"}
+	columns := []Column{{Index: 1, Type: ColumnNumber, Desc: true}, {Index: 0}}
+
+	less := LessByKey(ColumnKeyFunc(columns, split))
+	sort.SliceStable(records, func(i, j int) bool {
+		return less([]byte(records[i]), []byte(records[j]))
+	})
+	assert.Equal(t, []string{"z,n/a", "x,10", "v,9.5", "y,9.5", "w,-1"}, records)
+
+	left, err := ColumnKeyFunc([]Column{{Index: 0, Type: ColumnNumber}}, split)(nil, []byte("1.0,a"))
+	require.NoError(t, err)
+
+	right, err := ColumnKeyFunc([]Column{{Index: 2, Type: ColumnNumber}}, split)(nil, []byte("b,c,1"))
+	require.NoError(t, err)
+	assert.Equal(t, left, right)
+}
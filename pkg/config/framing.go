@@ -0,0 +1,208 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// Framing defines how tokens are stored in the input and output files.
+type Framing int
+
+const (
+	// FramingDelimiter means that tokens are separated by Delimiter.
+	FramingDelimiter Framing = iota
+
+	// FramingVarint means that each token is prefixed with its length encoded as an uvarint.
+	// Tokens may contain any bytes, including the delimiter.
+	FramingVarint
+
+	// FramingFixed32 means that each token is prefixed with its length encoded as a big-endian uint32.
+	FramingFixed32
+
+	// FramingFixed means that all tokens are RecordSize bytes long and are stored one after another.
+	FramingFixed
+
+	// FramingMultiline means that tokens are records of several lines.
+	// A record begins at a line matching RecordStart, or records are paragraphs separated by blank lines
+	// if RecordStart is nil.
+	FramingMultiline
+
+	// FramingCSV means that tokens are CSV records. Line breaks inside quoted fields don't end a record.
+	FramingCSV
+)
+
+// ParseFraming converts delimiter, varint, fixed32, fixed, multiline and csv (case-insensitive) to the corresponding framing.
+func ParseFraming(s string) (Framing, error) {
+	switch strings.ToLower(s) {
+	case "delimiter":
+		return FramingDelimiter, nil
+
+	case "varint":
+		return FramingVarint, nil
+
+	case "fixed32":
+		return FramingFixed32, nil
+
+	case "fixed":
+		return FramingFixed, nil
+
+	case "multiline":
+		return FramingMultiline, nil
+
+	case "csv":
+		return FramingCSV, nil
+
+	default:
+		return FramingDelimiter, errors.Errorf("unknown framing %s", s)
+	}
+}
+
+// ParseDelimiter converts a command line argument to a delimiter.
+// Escape sequences like \r, \n, \t and \x1e are interpreted, so "\r\n" can be passed without shell quoting tricks.
+func ParseDelimiter(s string) ([]byte, error) {
+	if unquoted, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		s = unquoted
+	}
+
+	if s == "" {
+		return nil, errors.New("empty delimiter")
+	}
+
+	return []byte(s), nil
+}
+
+// CRLF defines how "\r" before the "\n" delimiter is handled.
+type CRLF int
+
+const (
+	// CRLFKeep means that "\r" is a part of the token.
+	CRLFKeep CRLF = iota
+
+	// CRLFNormalize means that "\r" bytes at the end of lines are removed, so the output has "\n" line endings only.
+	CRLFNormalize
+
+	// CRLFPreserve means that "\r" at the end of lines is ignored while sorting,
+	// and all output lines end with "\r\n". Lines that end with "\n" only are converted to "\r\n" too.
+	CRLFPreserve
+)
+
+// ParseCRLF converts keep, normalize and preserve (case-insensitive) to the corresponding mode.
+func ParseCRLF(s string) (CRLF, error) {
+	switch strings.ToLower(s) {
+	case "keep":
+		return CRLFKeep, nil
+
+	case "normalize":
+		return CRLFNormalize, nil
+
+	case "preserve":
+		return CRLFPreserve, nil
+
+	default:
+		return CRLFKeep, errors.Errorf("unknown CRLF mode %s", s)
+	}
+}
+
+// trimCR reports whether "\r" must be removed from the end of tokens.
+func (c *Config) trimCR() bool {
+	return c.CRLF != CRLFKeep && bytes.Equal(c.Delimiter, []byte("\n"))
+}
+
+// ConvertsLineEndings reports whether lines of the output may end differently than lines of the input.
+func (c *Config) ConvertsLineEndings() bool {
+	return c.Framing == FramingDelimiter && c.trimCR()
+}
+
+// NewTempWriter is like NewWriter, but tokens written by it are read back by NewReader unchanged.
+// Unlike the output of CRLFNormalize, lines keep "\r", so tokens that end with "\r" aren't cut.
+func (c *Config) NewTempWriter(file *os.File, offset int64) buffer.TokenWriter {
+	if c.ConvertsLineEndings() {
+		return buffer.NewWriter(file, offset, c.BlockSize, []byte("\r\n"))
+	}
+
+	return c.NewWriter(file, offset)
+}
+
+// NewReader creates a reader of tokens stored in [offset, endOffset) bytes of f according to c.Framing.
+func (c *Config) NewReader(f *os.File, offset, endOffset int64) buffer.SectionReader {
+	switch c.Framing {
+	case FramingVarint:
+		return buffer.NewRecordReader(f, offset, endOffset, c.BlockSize, buffer.PrefixVarint)
+
+	case FramingFixed32:
+		return buffer.NewRecordReader(f, offset, endOffset, c.BlockSize, buffer.PrefixFixed32)
+
+	case FramingFixed:
+		return buffer.NewFixedReader(f, offset, endOffset, c.BlockSize, c.RecordSize)
+
+	case FramingMultiline:
+		var start func(line []byte) bool
+		if c.RecordStart != nil {
+			start = c.RecordStart.Match
+		}
+
+		return buffer.NewMultilineReader(f, offset, endOffset, c.BlockSize, start)
+
+	case FramingCSV:
+		return buffer.NewCSVReader(f, offset, endOffset, c.BlockSize)
+
+	default:
+		r := buffer.NewReader(f, offset, endOffset, c.BlockSize, c.Delimiter)
+		if c.trimCR() {
+			return buffer.CRTrimReader{Reader: r}
+		}
+
+		return r
+	}
+}
+
+// NewWriter creates a writer of tokens in the c.Framing format, starting at offset of file.
+func (c *Config) NewWriter(file *os.File, offset int64) buffer.TokenWriter {
+	switch c.Framing {
+	case FramingVarint:
+		return buffer.NewRecordWriter(file, offset, c.BlockSize, buffer.PrefixVarint)
+
+	case FramingFixed32:
+		return buffer.NewRecordWriter(file, offset, c.BlockSize, buffer.PrefixFixed32)
+
+	case FramingFixed:
+		return buffer.NewFixedWriter(file, offset, c.BlockSize, c.RecordSize)
+
+	case FramingMultiline:
+		if c.RecordStart == nil {
+			return buffer.NewWriter(file, offset, c.BlockSize, []byte("\n\n"))
+		}
+
+		return buffer.NewWriter(file, offset, c.BlockSize, []byte("\n"))
+
+	case FramingCSV:
+		return buffer.NewWriter(file, offset, c.BlockSize, []byte("\n"))
+
+	default:
+		if c.trimCR() && c.CRLF == CRLFPreserve {
+			return buffer.NewWriter(file, offset, c.BlockSize, []byte("\r\n"))
+		}
+
+		return buffer.NewWriter(file, offset, c.BlockSize, c.Delimiter)
+	}
+}
+
+// FirstLine makes a comparator of multiline records that compares their first lines with less.
+func FirstLine(less func(a, b []byte) bool) func(a, b []byte) bool {
+	return func(a, b []byte) bool {
+		return less(firstLine(a), firstLine(b))
+	}
+}
+
+func firstLine(record []byte) []byte {
+	if i := bytes.IndexByte(record, '\n'); i >= 0 {
+		return bytes.TrimRight(record[:i], "\r")
+	}
+
+	return record
+}
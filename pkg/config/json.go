@@ -0,0 +1,277 @@
+package config
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MissingKey defines what happens to records that don't have a key or can't be parsed.
+type MissingKey int
+
+const (
+	// MissingFirst puts such records before the others.
+	MissingFirst MissingKey = iota
+
+	// MissingLast puts such records after the others.
+	MissingLast
+
+	// MissingError stops the sort with an error.
+	MissingError
+)
+
+// ParseMissingKey converts first, last and error (case-insensitive) to the corresponding policy.
+func ParseMissingKey(s string) (MissingKey, error) {
+	switch strings.ToLower(s) {
+	case "first":
+		return MissingFirst, nil
+
+	case "last":
+		return MissingLast, nil
+
+	case "error":
+		return MissingError, nil
+
+	default:
+		return MissingFirst, errors.Errorf("unknown missing key policy %s", s)
+	}
+}
+
+// JSONKey is a value of a JSON record the records are sorted by.
+type JSONKey struct {
+	// Path is a list of object fields and array indices, e.g. .items[0].id is ["items", 0, "id"].
+	Path []interface{}
+
+	Desc bool
+}
+
+// ParseJSONKeys parses a comma-separated list of JSON paths, each optionally followed by :asc or :desc,
+// e.g. ".user.id,.ts:desc".
+func ParseJSONKeys(s string) ([]JSONKey, error) {
+	var keys []JSONKey
+	for _, spec := range strings.Split(s, ",") {
+		path, direction := spec, ""
+		if i := strings.LastIndexByte(spec, ':'); i >= 0 {
+			path, direction = spec[:i], spec[i+1:]
+		}
+
+		var key JSONKey
+		switch strings.ToLower(direction) {
+		case "", "asc":
+
+		case "desc":
+			key.Desc = true
+
+		default:
+			return nil, errors.Errorf("unknown direction %s of key %s", direction, path)
+		}
+
+		var err error
+		key.Path, err = parseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// parseJSONPath converts .a.b[1] to ["a", "b", 1]. The path "." means the whole record.
+func parseJSONPath(s string) ([]interface{}, error) {
+	if s == "." {
+		return nil, nil
+	}
+	if s == "" {
+		return nil, errors.New("empty JSON path")
+	}
+
+	var path []interface{}
+	for _, field := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+		name := field
+		if i := strings.IndexByte(field, '['); i >= 0 {
+			name = field[:i]
+		}
+		if name != "" {
+			path = append(path, name)
+		}
+
+		rest := field[len(name):]
+		if rest == "" && name == "" {
+			return nil, errors.Errorf("empty field in JSON path %s", s)
+		}
+
+		for rest != "" {
+			end := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || end == -1 {
+				return nil, errors.Errorf("invalid JSON path %s", s)
+			}
+
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, errors.Errorf("invalid array index in JSON path %s", s)
+			}
+
+			path = append(path, index)
+			rest = rest[end+1:]
+		}
+	}
+
+	return path, nil
+}
+
+// Tags of JSON values in keys. They define the order of values of different types.
+const (
+	tagMissingFirst byte = 0x00
+	tagNull         byte = 0x01
+	tagFalse        byte = 0x02
+	tagTrue         byte = 0x03
+	tagNumber       byte = 0x04
+	tagString       byte = 0x05
+	tagComposite    byte = 0x06
+	tagMissingLast  byte = 0xff
+)
+
+// JSONKeyFunc returns a KeyFunc for JSON records sorted by the given keys.
+// Values are ordered by type first: null, false, true, numbers, strings, objects and arrays.
+// Numbers are compared as float64, strings byte-wise, objects and arrays by their compact JSON text.
+// Records that aren't valid JSON are treated as records without keys.
+func JSONKeyFunc(keys []JSONKey, missing MissingKey) KeyFunc {
+	return func(dst, token []byte) ([]byte, error) {
+		var record interface{}
+		decoder := json.NewDecoder(bytes.NewReader(token))
+		decoder.UseNumber()
+
+		err := decoder.Decode(&record)
+		if err != nil && missing == MissingError {
+			return nil, errors.Wrap(err, "invalid JSON record")
+		}
+		valid := err == nil
+
+		for _, key := range keys {
+			value, found := lookupJSON(record, key.Path)
+			if !valid || !found {
+				switch missing {
+				case MissingError:
+					return nil, errors.Errorf("key %v is missing", key.Path)
+
+				case MissingLast:
+					dst = append(dst, tagMissingLast)
+
+				default:
+					dst = append(dst, tagMissingFirst)
+				}
+
+				continue
+			}
+
+			start := len(dst)
+
+			dst, err = appendJSONValue(dst, value)
+			if err != nil {
+				return nil, err
+			}
+
+			// Encodings are prefix-free, so inverted bytes are ordered backwards.
+			if key.Desc {
+				invert(dst[start:])
+			}
+		}
+
+		return dst, nil
+	}
+}
+
+func lookupJSON(value interface{}, path []interface{}) (interface{}, bool) {
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+
+			value, ok = object[step]
+			if !ok {
+				return nil, false
+			}
+
+		case int:
+			array, ok := value.([]interface{})
+			if !ok || step >= len(array) {
+				return nil, false
+			}
+
+			value = array[step]
+		}
+	}
+
+	return value, true
+}
+
+// appendJSONValue appends the order-preserving encoding of the value.
+func appendJSONValue(dst []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(dst, tagNull), nil
+
+	case bool:
+		if v {
+			return append(dst, tagTrue), nil
+		}
+
+		return append(dst, tagFalse), nil
+
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, errors.Wrapf(err, "invalid number %s", v)
+		}
+
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], orderedFloat(f))
+
+		return append(append(dst, tagNumber), buf[:]...), nil
+
+	case string:
+		return appendEscaped(append(dst, tagString), []byte(v)), nil
+
+	default:
+		text, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		return appendEscaped(append(dst, tagComposite), text), nil
+	}
+}
+
+// orderedFloat converts a float to an unsigned integer with the same order.
+func orderedFloat(f float64) uint64 {
+	u := math.Float64bits(f)
+	if u&(1<<63) != 0 {
+		return ^u
+	}
+
+	return u | 1<<63
+}
+
+// appendEscaped appends the bytes terminated by 0x00 0x01. Zero bytes are escaped as 0x00 0xff,
+// so the encoding is prefix-free and keeps the byte-wise order.
+func appendEscaped(dst, b []byte) []byte {
+	for _, c := range b {
+		if c == 0 {
+			dst = append(dst, 0, 0xff)
+		} else {
+			dst = append(dst, c)
+		}
+	}
+
+	return append(dst, 0, 1)
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJSONKeys(t *testing.T) {
+	keys, err := ParseJSONKeys(".user.id,.items[0][2].ts:desc,.")
+	require.NoError(t, err)
+	assert.Equal(t, []JSONKey{
+		{Path: []interface{}{"user", "id"}},
+		{Path: []interface{}{"items", 0, 2, "ts"}, Desc: true},
+		{},
+	}, keys)
+
+	for _, s := range []string{"", ".a..b", ".a[x]", ".a[1", ".a:up"} {
+		_, err = ParseJSONKeys(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestJSONKeyFunc(t *testing.T) {
+	records := []string{
+		`{"user": {"id": 2}, "ts": "b"}`,
+		`{"user": {"id": "x\u0000y"}, "ts": "a"}`,
+		`{"user": {"id": 10}, "ts": "a"}`,
+		`{"user": {"id": -1.5}}`,
+		`not json`,
+		`{"user": {"id": null}, "ts": "z"}`,
+		`{"user": {"id": 2}, "ts": "ba"}`,
+		`{"user": {"id": "x"}, "ts": "a"}`,
+		`{"user": {"id": true}, "ts": "a"}`,
+		`{"user": {"id": {"a": 1}}, "ts": "a"}`,
+	}
+
+	sortRecords := func(spec string, missing MissingKey) []string {
+		keys, err := ParseJSONKeys(spec)
+		require.NoError(t, err)
+
+		less := LessByKey(JSONKeyFunc(keys, missing))
+
+		sorted := append([]string(nil), records...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return less([]byte(sorted[i]), []byte(sorted[j]))
+		})
+
+		return sorted
+	}
+
+	assert.Equal(t, []string{
+		`not json`,
+		`{"user": {"id": null}, "ts": "z"}`,
+		`{"user": {"id": true}, "ts": "a"}`,
+		`{"user": {"id": -1.5}}`,
+		`{"user": {"id": 2}, "ts": "ba"}`,
+		`{"user": {"id": 2}, "ts": "b"}`,
+		`{"user": {"id": 10}, "ts": "a"}`,
+		`{"user": {"id": "x"}, "ts": "a"}`,
+		`{"user": {"id": "x\u0000y"}, "ts": "a"}`,
+		`{"user": {"id": {"a": 1}}, "ts": "a"}`,
+	}, sortRecords(".user.id,.ts:desc", MissingFirst))
+
+	assert.Equal(t, []string{
+		`{"user": {"id": {"a": 1}}, "ts": "a"}`,
+		`{"user": {"id": "x\u0000y"}, "ts": "a"}`,
+		`{"user": {"id": "x"}, "ts": "a"}`,
+		`{"user": {"id": 10}, "ts": "a"}`,
+		`{"user": {"id": 2}, "ts": "b"}`,
+		`{"user": {"id": 2}, "ts": "ba"}`,
+		`{"user": {"id": -1.5}}`,
+		`{"user": {"id": true}, "ts": "a"}`,
+		`{"user": {"id": null}, "ts": "z"}`,
+		`not json`,
+	}, sortRecords(".user.id:desc,.ts", MissingLast))
+
+	keyFunc := JSONKeyFunc([]JSONKey{{Path: []interface{}{"ts"}}}, MissingError)
+	_, err := keyFunc(nil, []byte(`{"user": {"id": -1.5}}`))
+	assert.Error(t, err)
+	_, err = keyFunc(nil, []byte(`not json`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeyType defines how the key bytes of a record are compared.
+type KeyType int
+
+const (
+	// KeyBytes compares keys byte-wise.
+	KeyBytes KeyType = iota
+
+	// KeyUintBE and KeyUintLE compare keys as big- and little-endian unsigned integers of 1, 2, 4 or 8 bytes.
+	KeyUintBE
+	KeyUintLE
+
+	// KeyIntBE and KeyIntLE compare keys as big- and little-endian two's complement integers of 1, 2, 4 or 8 bytes.
+	KeyIntBE
+	KeyIntLE
+
+	// KeyFloatBE and KeyFloatLE compare keys as big- and little-endian IEEE 754 numbers of 4 or 8 bytes.
+	// NaNs with the sign bit unset go after +Inf, the ones with the sign bit set go before -Inf.
+	KeyFloatBE
+	KeyFloatLE
+)
+
+var keyTypes = map[string]KeyType{
+	"bytes":    KeyBytes,
+	"uint-be":  KeyUintBE,
+	"uint-le":  KeyUintLE,
+	"int-be":   KeyIntBE,
+	"int-le":   KeyIntLE,
+	"float-be": KeyFloatBE,
+	"float-le": KeyFloatLE,
+}
+
+// ParseKeyType converts bytes, uint-be, uint-le, int-be, int-le, float-be and float-le (case-insensitive)
+// to the corresponding key type.
+func ParseKeyType(s string) (KeyType, error) {
+	t, exists := keyTypes[strings.ToLower(s)]
+	if !exists {
+		return KeyBytes, errors.Errorf("unknown key type %s", s)
+	}
+
+	return t, nil
+}
+
+// Key is a part of a fixed-width record the records are sorted by.
+type Key struct {
+	// Offset and Length define the position of the key in a record.
+	Offset int
+	Length int
+
+	Type KeyType
+}
+
+// ParseKey creates a key of records of recordSize bytes from command line arguments.
+// If length is zero, the key lasts until the end of the record.
+func ParseKey(offset, length int, keyType string, recordSize int) (Key, error) {
+	t, err := ParseKeyType(keyType)
+	if err != nil {
+		return Key{}, err
+	}
+
+	if length == 0 {
+		length = recordSize - offset
+	}
+
+	k := Key{
+		Offset: offset,
+		Length: length,
+		Type:   t,
+	}
+
+	return k, k.Validate(recordSize)
+}
+
+// Validate checks that the key fits into records of the given size and that its length suits its type.
+func (k Key) Validate(recordSize int) error {
+	if k.Offset < 0 || k.Length <= 0 || k.Offset+k.Length > recordSize {
+		return errors.Errorf("key [%d, %d) doesn't fit into a record of %d bytes", k.Offset, k.Offset+k.Length, recordSize)
+	}
+
+	switch k.Type {
+	case KeyBytes:
+		return nil
+
+	case KeyFloatBE, KeyFloatLE:
+		if k.Length != 4 && k.Length != 8 {
+			return errors.Errorf("float keys must be 4 or 8 bytes long, but %d was given", k.Length)
+		}
+
+	default:
+		if k.Length != 1 && k.Length != 2 && k.Length != 4 && k.Length != 8 {
+			return errors.Errorf("integer keys must be 1, 2, 4 or 8 bytes long, but %d was given", k.Length)
+		}
+	}
+
+	return nil
+}
+
+// Less returns a comparator of records by the key in the given order. A valid key is expected.
+// Records with equal keys are considered equal.
+func (k Key) Less(order Order) func(a, b []byte) bool {
+	var less func(a, b []byte) bool
+	if k.Type == KeyBytes {
+		less = func(a, b []byte) bool {
+			return bytes.Compare(k.bytes(a), k.bytes(b)) < 0
+		}
+	} else {
+		less = func(a, b []byte) bool {
+			return k.ordered(a) < k.ordered(b)
+		}
+	}
+
+	if order == OrderDESC {
+		return func(a, b []byte) bool {
+			return less(b, a)
+		}
+	}
+
+	return less
+}
+
+func (k Key) bytes(record []byte) []byte {
+	return record[k.Offset : k.Offset+k.Length]
+}
+
+// ordered converts a numeric key to an unsigned integer with the same order.
+func (k Key) ordered(record []byte) uint64 {
+	key := k.bytes(record)
+
+	var u uint64
+	switch k.Type {
+	case KeyUintBE, KeyIntBE, KeyFloatBE:
+		for _, b := range key {
+			u = u<<8 | uint64(b)
+		}
+
+	default:
+		for i := len(key) - 1; i >= 0; i-- {
+			u = u<<8 | uint64(key[i])
+		}
+	}
+
+	bits := uint(8 * k.Length)
+	sign := uint64(1) << (bits - 1)
+
+	switch k.Type {
+	case KeyIntBE, KeyIntLE:
+		// Shifting the range moves negative numbers before positive ones.
+		return u ^ sign
+
+	case KeyFloatBE, KeyFloatLE:
+		// Negative numbers are ordered backwards, so all their bits are flipped.
+		if u&sign != 0 {
+			return ^u & (sign | (sign - 1))
+		}
+
+		return u | sign
+
+	default:
+		return u
+	}
+}
+
+// Comparator returns Less and Order for records of recordSize bytes sorted by the key.
+// A byte-wise key that covers the whole record keeps the built-in order, so radix sort can still be used.
+func (k Key) Comparator(recordSize int, order Order) (func(a, b []byte) bool, Order) {
+	if k.Type == KeyBytes && k.Offset == 0 && k.Length == recordSize {
+		return order.Less(), order
+	}
+
+	return k.Less(order), OrderCustom
+}
+
+// KeyFunc returns a KeyFunc that encodes the key so it can be compared byte-wise in the given order.
+func (k Key) KeyFunc(order Order) KeyFunc {
+	return func(dst, record []byte) ([]byte, error) {
+		if len(record) < k.Offset+k.Length {
+			return nil, errors.Errorf("record of %d bytes is too short for key [%d, %d)", len(record), k.Offset, k.Offset+k.Length)
+		}
+
+		start := len(dst)
+		if k.Type == KeyBytes {
+			dst = append(dst, k.bytes(record)...)
+		} else {
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], k.ordered(record))
+			dst = append(dst, buf[:]...)
+		}
+
+		// All keys have the same length, so inverted keys are ordered backwards.
+		if order == OrderDESC {
+			invert(dst[start:])
+		}
+
+		return dst, nil
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyLess(t *testing.T) {
+	ints := []int64{5, -3, math.MinInt64, 0, math.MaxInt64, -1, 42}
+	floats := []float64{1.5, -2, math.Inf(1), 0, math.Inf(-1), -0.25, 1e300}
+
+	encode := func(key Key, n int, put func(record []byte, i int)) [][]byte {
+		records := make([][]byte, n)
+		for i := range records {
+			records[i] = make([]byte, key.Offset+key.Length+1)
+			put(records[i][key.Offset:], i)
+		}
+
+		return records
+	}
+
+	cases := []struct {
+		key     Key
+		records [][]byte
+		value   func(record []byte) float64
+	}{
+		{
+			key: Key{Offset: 2, Length: 8, Type: KeyIntLE},
+			records: encode(Key{Offset: 2, Length: 8}, len(ints), func(b []byte, i int) {
+				binary.LittleEndian.PutUint64(b, uint64(ints[i]))
+			}),
+			value: func(record []byte) float64 {
+				return float64(int64(binary.LittleEndian.Uint64(record[2:])))
+			},
+		},
+		{
+			key: Key{Offset: 1, Length: 8, Type: KeyFloatBE},
+			records: encode(Key{Offset: 1, Length: 8}, len(floats), func(b []byte, i int) {
+				binary.BigEndian.PutUint64(b, math.Float64bits(floats[i]))
+			}),
+			value: func(record []byte) float64 {
+				return math.Float64frombits(binary.BigEndian.Uint64(record[1:]))
+			},
+		},
+		{
+			key: Key{Offset: 0, Length: 2, Type: KeyIntBE},
+			records: encode(Key{Offset: 0, Length: 2}, 5, func(b []byte, i int) {
+				binary.BigEndian.PutUint16(b, uint16(int16(i*1000-2500)))
+			}),
+			value: func(record []byte) float64 {
+				return float64(int16(binary.BigEndian.Uint16(record)))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		require.NoError(t, c.key.Validate(c.key.Offset+c.key.Length+1))
+
+		for _, order := range []Order{OrderASC, OrderDESC} {
+			less := c.key.Less(order)
+			sort.Slice(c.records, func(i, j int) bool {
+				return less(c.records[i], c.records[j])
+			})
+
+			for i := 1; i < len(c.records); i++ {
+				prev, cur := c.value(c.records[i-1]), c.value(c.records[i])
+				if order == OrderASC {
+					assert.LessOrEqual(t, prev, cur)
+				} else {
+					assert.GreaterOrEqual(t, prev, cur)
+				}
+			}
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey(90, 0, "bytes", 100)
+	require.NoError(t, err)
+	assert.Equal(t, Key{Offset: 90, Length: 10, Type: KeyBytes}, key)
+
+	_, err = ParseKey(0, 3, "uint-le", 100)
+	assert.Error(t, err, "3-byte integers")
+
+	_, err = ParseKey(96, 8, "float-be", 100)
+	assert.Error(t, err, "the key doesn't fit")
+
+	_, err = ParseKey(0, 8, "decimal", 100)
+	assert.Error(t, err, "unknown type")
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// KeyFunc appends the sort key of the token to dst and returns the extended slice.
+// Keys are compared byte-wise, so they must be encoded in an order-preserving way.
+type KeyFunc func(dst, token []byte) ([]byte, error)
+
+// WithKey returns the token prefixed with its key: uvarint(len(key)) + key + token.
+func (c *Config) WithKey(token []byte) ([]byte, error) {
+	key, err := c.KeyFunc(make([]byte, 0, 16), token)
+	if err != nil {
+		return nil, err
+	}
+
+	return AppendKeyed(make([]byte, 0, binary.MaxVarintLen64+len(key)+len(token)), key, token), nil
+}
+
+// AppendKeyed appends the token prefixed with the key like WithKey does to dst.
+func AppendKeyed(dst, key, token []byte) []byte {
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(key)))
+
+	dst = append(dst, prefix[:n]...)
+	dst = append(dst, key...)
+
+	return append(dst, token...)
+}
+
+// SplitKey splits a record made by WithKey into the key and the token.
+func SplitKey(record []byte) (key, token []byte) {
+	length, n := binary.Uvarint(record)
+	if n <= 0 || uint64(len(record)-n) < length {
+		return nil, record
+	}
+
+	return record[n : n+int(length)], record[n+int(length):]
+}
+
+// LessKeyed compares records made by WithKey by their keys.
+func LessKeyed(a, b []byte) bool {
+	keyA, _ := SplitKey(a)
+	keyB, _ := SplitKey(b)
+
+	return bytes.Compare(keyA, keyB) < 0
+}
+
+// LessByKey returns a comparator of tokens that extracts and compares their keys on every call.
+// Tokens whose keys can't be extracted are treated as equal to any other token.
+func LessByKey(keyFunc KeyFunc) func(a, b []byte) bool {
+	return func(a, b []byte) bool {
+		keyA, err := keyFunc(nil, a)
+		if err != nil {
+			return false
+		}
+
+		keyB, err := keyFunc(nil, b)
+		if err != nil {
+			return false
+		}
+
+		return bytes.Compare(keyA, keyB) < 0
+	}
+}
+
+// TokenKeyFunc returns a KeyFunc that makes the whole token a key in the given built-in order.
+func TokenKeyFunc(order Order) KeyFunc {
+	if order != OrderDESC {
+		return func(dst, token []byte) ([]byte, error) {
+			return append(dst, token...), nil
+		}
+	}
+
+	return func(dst, token []byte) ([]byte, error) {
+		start := len(dst)
+		dst = appendEscaped(dst, token)
+		invert(dst[start:])
+
+		return dst, nil
+	}
+}
+
+// invert flips all bits of b. Prefix-free encodings are ordered backwards after that.
+func invert(b []byte) {
+	for i := range b {
+		b[i] = ^b[i]
+	}
+}
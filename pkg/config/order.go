@@ -0,0 +1,62 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Order tells the algorithm whether Less is one of the built-in byte-wise orders.
+// The built-in orders allow to use radix sort instead of comparison sort.
+type Order int
+
+const (
+	// OrderCustom means that Less is an arbitrary comparator.
+	OrderCustom Order = iota
+
+	// OrderASC means that Less is LessASC.
+	OrderASC
+
+	// OrderDESC means that Less is LessDESC.
+	OrderDESC
+)
+
+// ParseOrder converts ASC and DESC (case-insensitive) to the corresponding order.
+func ParseOrder(s string) (Order, error) {
+	switch {
+	case strings.EqualFold(s, "ASC"):
+		return OrderASC, nil
+
+	case strings.EqualFold(s, "DESC"):
+		return OrderDESC, nil
+
+	default:
+		return OrderCustom, errors.Errorf("only ASC and DESC orders are supported, but %s was given", s)
+	}
+}
+
+// Less returns the comparator of a built-in order.
+// nil is returned for OrderCustom.
+func (o Order) Less() func(a, b []byte) bool {
+	switch o {
+	case OrderASC:
+		return LessASC
+
+	case OrderDESC:
+		return LessDESC
+
+	default:
+		return nil
+	}
+}
+
+// LessASC compares tokens in the byte-wise lexicographic order.
+func LessASC(a, b []byte) bool {
+	return bytes.Compare(a, b) < 0
+}
+
+// LessDESC compares tokens in the reversed byte-wise lexicographic order.
+func LessDESC(a, b []byte) bool {
+	return bytes.Compare(a, b) > 0
+}
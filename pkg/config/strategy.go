@@ -0,0 +1,21 @@
+package config
+
+import (
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+)
+
+// RunSorter sorts tokens of one run in main memory.
+type RunSorter interface {
+	SortRun(tokens [][]byte, cfg *Config)
+}
+
+// Merger merges several sorted runs into one sorted run.
+type Merger interface {
+	// FanIn returns how many runs can be merged at once.
+	// It must be at least 2.
+	FanIn(cfg *Config) int
+
+	// Merge reads tokens from sorted sources and writes them to dst in the order defined by less.
+	// At most FanIn sources are passed.
+	Merge(sources []buffer.TokenReader, dst buffer.TokenWriter, less func(a, b []byte) bool) error
+}
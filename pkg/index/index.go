@@ -0,0 +1,178 @@
+package index
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/lodthe/external-merge-sort/pkg/buffer"
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// Suffix of the index file written next to the sorted file.
+const Suffix = ".idx"
+
+// Entry is a token of the sorted file and the offset it's stored at.
+type Entry struct {
+	Token  []byte
+	Offset int64
+}
+
+// Index is a sparse index of a sorted file: it contains every Nth token of the file.
+// The first token is always indexed.
+type Index struct {
+	entries []Entry
+}
+
+// Build reads the sorted file and writes every Nth token with its offset to the index file.
+// Each entry is stored as a varint length-prefixed record: the offset as a big-endian uint64 followed by the token.
+// If cfg.Header is set, the first token of the sorted file isn't indexed.
+func Build(cfg *config.Config, sortedPath, indexPath string, every int) error {
+	if every < 1 {
+		return errors.Errorf("index step must be positive, but %d was given", every)
+	}
+
+	sorted, err := os.Open(sortedPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open sorted file")
+	}
+	defer func() {
+		_ = sorted.Close()
+	}()
+
+	output, err := os.OpenFile(indexPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create index file")
+	}
+	defer func() {
+		_ = output.Close()
+	}()
+
+	r := cfg.NewReader(sorted, 0, math.MaxInt64)
+	defer r.Release()
+
+	w := buffer.NewRecordWriter(output, 0, cfg.BlockSize, buffer.PrefixVarint)
+	defer w.Release()
+
+	if cfg.Header {
+		_, err = r.Next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return errors.Wrap(err, "failed to read the header")
+		}
+	}
+
+	var entry []byte
+	for i := 0; ; i++ {
+		offset := r.Offset()
+
+		token, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read the next token")
+		}
+
+		if i%every != 0 {
+			continue
+		}
+
+		entry = append(entry[:0], make([]byte, 8)...)
+		binary.BigEndian.PutUint64(entry, uint64(offset))
+		entry = append(entry, token...)
+
+		err = w.Write(entry)
+		if err != nil {
+			return errors.Wrap(err, "write failed")
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return errors.Wrap(err, "flush failed")
+	}
+
+	return output.Sync()
+}
+
+// Load reads the index file written by Build.
+func Load(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open index file")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	r := buffer.NewRecordReader(f, 0, math.MaxInt64, 64*1024, buffer.PrefixVarint)
+	defer r.Release()
+
+	index := &Index{}
+	for {
+		record, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return index, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the next entry")
+		}
+
+		if len(record) < 8 {
+			return nil, errors.Errorf("index entry of %d bytes is too short", len(record))
+		}
+
+		index.entries = append(index.entries, Entry{
+			Token:  record[8:],
+			Offset: int64(binary.BigEndian.Uint64(record)),
+		})
+	}
+}
+
+// Len returns the number of entries.
+func (ix *Index) Len() int {
+	return len(ix.entries)
+}
+
+// Seek returns the offset a scan for tokens not less than a key must start at:
+// the offset of the last entry less than the key, or the offset of the first token.
+// less reports whether an entry token is less than the key; its error stops the search and is returned.
+// If the index is empty, false is returned.
+func (ix *Index) Seek(less func(token []byte) (bool, error)) (int64, bool, error) {
+	if len(ix.entries) == 0 {
+		return 0, false, nil
+	}
+
+	// Equal tokens may be stored before an equal entry, so the search stops at the last strictly less entry.
+	var err error
+	i := sort.Search(len(ix.entries), func(i int) bool {
+		if err != nil {
+			return true
+		}
+
+		var isLess bool
+		isLess, err = less(ix.entries[i].Token)
+
+		return !isLess
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if i > 0 {
+		i--
+	}
+
+	return ix.entries[i].Offset, true, nil
+}
+
+// Start returns the offset of the first token. If the index is empty, false is returned.
+func (ix *Index) Start() (int64, bool) {
+	if len(ix.entries) == 0 {
+		return 0, false
+	}
+
+	return ix.entries[0].Offset, true
+}
@@ -0,0 +1,153 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSearcher writes the sorted tokens to a file, indexes every 2nd token and returns a searcher over them.
+func newSearcher(t *testing.T, tokens []string, configure func(cfg *config.Config)) *Searcher {
+	cfg := &config.Config{
+		BlockSize: 16,
+		Delimiter: []byte("\n"),
+		Less:      config.LessASC,
+		Order:     config.OrderASC,
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	dir := t.TempDir()
+	sortedPath := filepath.Join(dir, "sorted")
+	require.NoError(t, ioutil.WriteFile(sortedPath, []byte(strings.Join(tokens, "\n")+"\n"), 0644))
+	require.NoError(t, Build(cfg, sortedPath, sortedPath+Suffix, 2))
+
+	index, err := Load(sortedPath + Suffix)
+	require.NoError(t, err)
+
+	f, err := os.Open(sortedPath)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = f.Close()
+	})
+
+	return NewSearcher(cfg, f, index)
+}
+
+func collect(t *testing.T, query func(fn func(token []byte) error) error) []string {
+	var found []string
+	require.NoError(t, query(func(token []byte) error {
+		found = append(found, string(token))
+		return nil
+	}))
+
+	return found
+}
+
+func TestBuild(t *testing.T) {
+	s := newSearcher(t, []string{"name", "a", "b", "c", "d", "e"}, func(cfg *config.Config) {
+		cfg.Header = true
+	})
+
+	require.Equal(t, 3, s.index.Len())
+	assert.Equal(t, Entry{Token: []byte("a"), Offset: 5}, s.index.entries[0])
+	assert.Equal(t, Entry{Token: []byte("c"), Offset: 9}, s.index.entries[1])
+	assert.Equal(t, Entry{Token: []byte("e"), Offset: 13}, s.index.entries[2])
+
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, collect(t, func(fn func(token []byte) error) error {
+		return s.Range(nil, nil, fn)
+	}))
+}
+
+func TestSearcher(t *testing.T) {
+	tokens := []string{"ant", "bee", "bee", "bee", "bee", "cat", "cow", "cub", "dog", "eel"}
+	s := newSearcher(t, tokens, nil)
+
+	exact := func(key string) []string {
+		return collect(t, func(fn func(token []byte) error) error {
+			return s.Exact([]byte(key), fn)
+		})
+	}
+	assert.Equal(t, []string{"bee", "bee", "bee", "bee"}, exact("bee"))
+	assert.Equal(t, []string{"ant"}, exact("ant"))
+	assert.Equal(t, []string{"eel"}, exact("eel"))
+	assert.Empty(t, exact("cab"))
+	assert.Empty(t, exact("zebra"))
+
+	prefix := func(p string) []string {
+		return collect(t, func(fn func(token []byte) error) error {
+			return s.Prefix([]byte(p), fn)
+		})
+	}
+	assert.Equal(t, []string{"cat", "cow", "cub"}, prefix("c"))
+	assert.Equal(t, []string{"cow"}, prefix("co"))
+	assert.Equal(t, tokens, prefix(""))
+	assert.Empty(t, prefix("f"))
+
+	assert.Equal(t, []string{"bee", "bee", "bee", "bee", "cat"}, collect(t, func(fn func(token []byte) error) error {
+		return s.Range([]byte("b"), []byte("cow"), fn)
+	}))
+	assert.Equal(t, []string{"dog", "eel"}, collect(t, func(fn func(token []byte) error) error {
+		return s.Range([]byte("cz"), nil, fn)
+	}))
+}
+
+func TestSearcherDESC(t *testing.T) {
+	tokens := []string{"eel", "dog", "d", "cub", "cow", "cat", "c", "bee", "ant"}
+	s := newSearcher(t, tokens, func(cfg *config.Config) {
+		cfg.Less = config.LessDESC
+		cfg.Order = config.OrderDESC
+	})
+
+	assert.Equal(t, []string{"cub", "cow", "cat", "c"}, collect(t, func(fn func(token []byte) error) error {
+		return s.Prefix([]byte("c"), fn)
+	}))
+	assert.Equal(t, []string{"dog", "d"}, collect(t, func(fn func(token []byte) error) error {
+		return s.Prefix([]byte("d"), fn)
+	}))
+	assert.Equal(t, []string{"cow", "cat", "c", "bee"}, collect(t, func(fn func(token []byte) error) error {
+		return s.Range([]byte("cow"), []byte("ant"), fn)
+	}))
+}
+
+func TestSearcherKeyFunc(t *testing.T) {
+	keys, err := config.ParseJSONKeys(".id")
+	require.NoError(t, err)
+
+	tokens := []string{`{"id": 1}`, `{"id": 2, "v": "a"}`, `{"id": 2, "v": "b"}`, `{"id": 2, "v": "c"}`, `{"id": 10}`}
+	s := newSearcher(t, tokens, func(cfg *config.Config) {
+		cfg.KeyFunc = config.JSONKeyFunc(keys, config.MissingFirst)
+	})
+
+	assert.Equal(t, tokens[1:4], collect(t, func(fn func(token []byte) error) error {
+		return s.Exact([]byte(`{"id": 2}`), fn)
+	}))
+}
+
+func TestSearcherKeyErrors(t *testing.T) {
+	keys, err := config.ParseJSONKeys(".id")
+	require.NoError(t, err)
+
+	tokens := []string{`{"id": 1}`, `{"id": 2}`, `{"id": 3`, `{"id": 4}`, `{"id": 5}`}
+	s := newSearcher(t, tokens, func(cfg *config.Config) {
+		cfg.KeyFunc = config.JSONKeyFunc(keys, config.MissingError)
+	})
+
+	// The broken record is an index entry and a token of the scan.
+	ignore := func(token []byte) error {
+		return nil
+	}
+	assert.Error(t, s.Exact([]byte(`{"id": 4}`), ignore))
+	assert.Error(t, s.Range([]byte(`{"id": 2}`), nil, ignore))
+	assert.Error(t, s.Range(nil, []byte(`{"id": 4}`), ignore))
+
+	// So is the query.
+	assert.Error(t, s.Exact([]byte(`{"id"`), ignore))
+}
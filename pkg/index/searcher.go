@@ -0,0 +1,197 @@
+package index
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+
+	"github.com/lodthe/external-merge-sort/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// Searcher answers queries over a sorted file with its sparse index.
+// Only the part of the file between the found index entry and the last matching token is read.
+// Tokens are compared like the file was sorted: by keys extracted by cfg.KeyFunc if it's set, by cfg.Less otherwise.
+type Searcher struct {
+	cfg   *config.Config
+	less  func(a, b []byte) bool
+	file  *os.File
+	index *Index
+}
+
+func NewSearcher(cfg *config.Config, file *os.File, index *Index) *Searcher {
+	less := cfg.Less
+	if cfg.KeyFunc != nil {
+		less = func(a, b []byte) bool {
+			return bytes.Compare(a, b) < 0
+		}
+	}
+
+	return &Searcher{
+		cfg:   cfg,
+		less:  less,
+		file:  file,
+		index: index,
+	}
+}
+
+// key returns the key the token is compared by: its key if cfg.KeyFunc is set, the token itself otherwise.
+func (s *Searcher) key(dst, token []byte) ([]byte, error) {
+	if s.cfg.KeyFunc == nil {
+		return token, nil
+	}
+
+	return s.cfg.KeyFunc(dst[:0], token)
+}
+
+// queryKey returns the key of a query token. nil is returned for nil.
+func (s *Searcher) queryKey(token []byte) ([]byte, error) {
+	if token == nil {
+		return nil, nil
+	}
+
+	key, err := s.key(nil, token)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to extract the key of %q", token)
+	}
+
+	return key, nil
+}
+
+// Exact calls fn for each token equal to key.
+func (s *Searcher) Exact(key []byte, fn func(token []byte) error) error {
+	key, err := s.queryKey(key)
+	if err != nil {
+		return err
+	}
+
+	return s.scan(key, func(token, tokenKey []byte) (bool, error) {
+		if s.less(key, tokenKey) {
+			return false, nil
+		}
+
+		return true, fn(token)
+	})
+}
+
+// Range calls fn for each token t such that from <= t < to in the sort order.
+// If from is nil, the range starts at the first token. If to is nil, the range lasts until the last token.
+func (s *Searcher) Range(from, to []byte, fn func(token []byte) error) error {
+	from, err := s.queryKey(from)
+	if err != nil {
+		return err
+	}
+
+	to, err = s.queryKey(to)
+	if err != nil {
+		return err
+	}
+
+	return s.scan(from, func(token, tokenKey []byte) (bool, error) {
+		if to != nil && !s.less(tokenKey, to) {
+			return false, nil
+		}
+
+		return true, fn(token)
+	})
+}
+
+// Prefix calls fn for each token that begins with prefix.
+// The file must be sorted in a built-in byte-wise order (cfg.Order is OrderASC or OrderDESC).
+func (s *Searcher) Prefix(prefix []byte, fn func(token []byte) error) error {
+	// Tokens with the prefix go one after another. In the descending order, they are preceded
+	// by tokens greater than all of them, so the scan starts at the least of such tokens.
+	start := prefix
+	if s.cfg.Order == config.OrderDESC {
+		start = successor(prefix)
+	}
+
+	start, err := s.queryKey(start)
+	if err != nil {
+		return err
+	}
+
+	prefixKey, err := s.queryKey(prefix)
+	if err != nil {
+		return err
+	}
+
+	return s.scan(start, func(token, tokenKey []byte) (bool, error) {
+		if bytes.HasPrefix(token, prefix) {
+			return true, fn(token)
+		}
+
+		// Tokens that go after the prefix ones end the scan, the others are skipped.
+		return !s.less(prefixKey, tokenKey), nil
+	})
+}
+
+// scan reads tokens starting with the first token whose key isn't less than start (or the first token if start is nil)
+// and calls visit for each of them and its key until it returns false.
+// The key of each token is extracted once, and extraction errors stop the scan.
+func (s *Searcher) scan(start []byte, visit func(token, key []byte) (bool, error)) error {
+	offset, ok := s.index.Start()
+	if start != nil {
+		var entryKey []byte
+		var err error
+		offset, ok, err = s.index.Seek(func(entry []byte) (bool, error) {
+			var err error
+			entryKey, err = s.key(entryKey, entry)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to extract the key of index entry %q", entry)
+			}
+
+			return s.less(entryKey, start), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !ok {
+		return nil
+	}
+
+	r := s.cfg.NewReader(s.file, offset, math.MaxInt64)
+	defer r.Release()
+
+	var key []byte
+	for {
+		token, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read the next token")
+		}
+
+		key, err = s.key(key, token)
+		if err != nil {
+			return errors.Wrapf(err, "failed to extract the key of %q", token)
+		}
+
+		if start != nil && s.less(key, start) {
+			continue
+		}
+
+		more, err := visit(token, key)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// successor returns the least byte string that is greater than all strings with the prefix.
+// nil is returned if there is no such string (the prefix is empty or consists of 0xff bytes).
+func successor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			next := append([]byte(nil), prefix[:i+1]...)
+			next[i]++
+
+			return next
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Governor is a process-wide memory budget shared by concurrent sorts.
+//
+// A sort acquires its memory before it starts and releases it when it's done.
+// The sort gets as much memory as it asks for if it's free, and at least the minimum it can work with,
+// so concurrent sorts share the budget instead of each of them taking the whole memory limit.
+type Governor struct {
+	limit int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	used int
+}
+
+func NewGovernor(limit int) *Governor {
+	g := &Governor{
+		limit: limit,
+	}
+	g.cond = sync.NewCond(&g.mu)
+
+	return g
+}
+
+// Limit returns the total budget in bytes.
+func (g *Governor) Limit() int {
+	return g.limit
+}
+
+// InUse returns the number of acquired bytes.
+func (g *Governor) InUse() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.used
+}
+
+// Acquire waits until at least min bytes are free and reserves up to max bytes of them.
+// It returns the number of reserved bytes, which must be passed to Release later.
+// If ctx is canceled before the memory is available, ctx.Err() is returned.
+func (g *Governor) Acquire(ctx context.Context, min, max int) (int, error) {
+	if max < min {
+		max = min
+	}
+	if min > g.limit {
+		return 0, errors.Errorf("%d bytes are required, but the memory limit is %d", min, g.limit)
+	}
+
+	// Waiters must wake up when ctx is canceled.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			g.mu.Lock()
+			g.cond.Broadcast()
+			g.mu.Unlock()
+
+		case <-stop:
+		}
+	}()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.limit-g.used < min {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		g.cond.Wait()
+	}
+
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	granted := g.limit - g.used
+	if granted > max {
+		granted = max
+	}
+	g.used += granted
+
+	return granted, nil
+}
+
+// Release returns memory reserved by Acquire.
+func (g *Governor) Release(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.used -= n
+	g.cond.Broadcast()
+}
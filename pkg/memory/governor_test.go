@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGovernor(t *testing.T) {
+	g := NewGovernor(100)
+	ctx := context.Background()
+
+	n, err := g.Acquire(ctx, 10, 60)
+	require.NoError(t, err)
+	assert.Equal(t, 60, n)
+
+	// Only 40 bytes are left, so the second sort gets less than it asks for.
+	n, err = g.Acquire(ctx, 10, 60)
+	require.NoError(t, err)
+	assert.Equal(t, 40, n)
+	assert.Equal(t, 100, g.InUse())
+
+	acquired := make(chan int)
+	go func() {
+		n, err := g.Acquire(ctx, 30, 50)
+		assert.NoError(t, err)
+		acquired <- n
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("memory acquired while the budget is exhausted")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	g.Release(40)
+	assert.Equal(t, 40, <-acquired)
+
+	_, err = g.Acquire(ctx, 101, 101)
+	assert.Error(t, err, "more than the limit")
+}
+
+func TestGovernorCancel(t *testing.T) {
+	g := NewGovernor(10)
+
+	n, err := g.Acquire(context.Background(), 10, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = g.Acquire(ctx, 5, 5)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 10, g.InUse())
+}